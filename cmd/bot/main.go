@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -14,6 +16,8 @@ import (
 
 	"karakeep-telegram-bot/internal/app"
 	"karakeep-telegram-bot/internal/config"
+	"karakeep-telegram-bot/internal/ratelimit"
+	"karakeep-telegram-bot/internal/security"
 	"karakeep-telegram-bot/internal/storage"
 	"karakeep-telegram-bot/internal/telegram"
 )
@@ -32,12 +36,29 @@ func main() {
 		os.Exit(2)
 	}
 
-	store, err := storage.Open(context.Background(), cfg.DBPath, cfg.APIKeyMasterKey)
+	security.SetPolicy(security.Policy{
+		AllowedHosts: cfg.KarakeepAllowedHosts,
+		DeniedHosts:  cfg.KarakeepDeniedHosts,
+		PrivateHosts: cfg.KarakeepPrivateHosts,
+		AllowedNets:  cfg.KarakeepAllowedCIDRs,
+		DeniedNets:   cfg.KarakeepDeniedCIDRs,
+		HTTPHosts:    cfg.KarakeepHTTPHosts,
+	})
+
+	store, err := storage.Open(context.Background(), cfg.DBPath, storage.MasterKeys{
+		Current:         cfg.APIKeyMasterKey,
+		CurrentVersion:  cfg.APIKeyMasterKeyVersion,
+		Previous:        cfg.APIKeyMasterKeyPrevious,
+		PreviousVersion: cfg.APIKeyMasterKeyPreviousVersion,
+	})
 	if err != nil {
 		logger.Error("failed to open storage", "err", err)
 		os.Exit(2)
 	}
 	defer store.Close()
+	if n, err := store.StaleAPIKeys(context.Background()); err == nil && n > 0 {
+		logger.Warn("api keys sealed with an old master key, run rotatekeys", "count", n)
+	}
 
 	bot, err := tgbotapi.NewBotAPI(cfg.TelegramBotToken)
 	if err != nil {
@@ -55,20 +76,71 @@ func main() {
 	}
 	application.Downloader = telegram.NewDownloader(bot)
 	application.MaxUploadBytes = 50 << 20
+	application.JobMaxAttempts = cfg.JobMaxAttempts
+	application.JobRetention = cfg.JobRetention
+	application.StripHashtags = cfg.StripHashtags
+	application.Access = app.AccessPolicy{
+		Mode:         cfg.AccessMode,
+		Admins:       cfg.BotAdmins,
+		AllowedUsers: cfg.AllowedUsers,
+	}
+	application.Limits = app.Limits{
+		User:   ratelimit.New(cfg.UserRatePerMinute, cfg.UserRateBurst),
+		Server: ratelimit.New(cfg.ServerRatePerMinute, cfg.ServerRateBurst),
+	}
+	application.InteractiveWorkers = cfg.InteractiveWorkers
 	application.MediaGroups = telegram.NewMediaGroupCollector(2*time.Second, application.HandleMediaGroup)
 
+	dispatcher := telegram.NewDispatcher(telegram.DispatcherOpts{
+		Workers:   cfg.UpdateWorkers,
+		QueueSize: cfg.UpdateQueueSize,
+		Logger:    logger,
+		OnUpdate:  application.HandleUpdate,
+	})
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("ok"))
 	})
+	mux.HandleFunc("/healthz/queue", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]int{
+			"updates_queued":   dispatcher.QueueDepth(),
+			"updates_capacity": dispatcher.QueueCapacity(),
+		})
+	})
 
-	mux.Handle(cfg.TelegramWebhookPath, telegram.NewWebhookHandler(telegram.WebhookHandlerOpts{
-		Bot:         bot,
-		SecretToken: cfg.TelegramWebhookSecret,
-		Logger:      logger,
-		OnUpdate:    application.HandleUpdate,
-	}))
+	if prev, ok, _ := store.GetState(context.Background(), storage.StateTelegramMode); ok && prev != cfg.TelegramMode {
+		logger.Info("telegram mode switched", "from", prev, "to", cfg.TelegramMode)
+	}
+	switch cfg.TelegramMode {
+	case config.TelegramModeWebhook:
+		mux.Handle(cfg.TelegramWebhookPath, telegram.NewWebhookHandler(telegram.WebhookHandlerOpts{
+			Bot:         bot,
+			SecretToken: cfg.TelegramWebhookSecret,
+			Logger:      logger,
+			Dispatcher:  dispatcher,
+		}))
+		if cfg.TelegramWebhookURL != "" {
+			if err := telegram.SetWebhook(bot, cfg.TelegramWebhookURL, cfg.TelegramWebhookSecret, false); err != nil {
+				logger.Error("failed to set telegram webhook", "err", err)
+				os.Exit(2)
+			}
+			logger.Info("telegram webhook registered")
+		}
+	case config.TelegramModePolling:
+		// getUpdates is refused by Telegram while a webhook is set.
+		removed, err := telegram.DeleteWebhook(bot)
+		if err != nil {
+			logger.Error("failed to delete telegram webhook", "err", err)
+			os.Exit(2)
+		}
+		if removed != "" {
+			logger.Info("telegram webhook deleted for polling mode")
+		}
+	}
+	_ = store.SetState(context.Background(), storage.StateTelegramMode, cfg.TelegramMode)
 
 	srv := &http.Server{
 		Addr:              cfg.ListenAddr,
@@ -79,6 +151,46 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	// Jobs left running by a previous process (crash, deploy) are resumed by the workers.
+	if n, err := store.RequeueRunningJobs(ctx); err != nil {
+		logger.Error("failed to requeue unfinished jobs", "err", err)
+	} else if n > 0 {
+		logger.Info("resuming unfinished jobs", "count", n)
+	}
+	// On shutdown running saves may finish (up to SHUTDOWN_TIMEOUT); abortJobs interrupts them after that.
+	abortCtx, abortJobs := context.WithCancel(context.Background())
+	defer abortJobs()
+	workersDone := make(chan struct{})
+	go func() {
+		defer close(workersDone)
+		application.RunJobWorkers(ctx, abortCtx, cfg.JobWorkers)
+	}()
+
+	pollerDone := make(chan struct{})
+	if cfg.TelegramMode == config.TelegramModePolling {
+		poller := telegram.NewPoller(telegram.PollerOpts{
+			Bot:    bot,
+			Logger: logger,
+			LoadLastUpdateID: func(ctx context.Context) (int, error) {
+				v, ok, err := store.GetState(ctx, storage.StateLastUpdateID)
+				if err != nil || !ok {
+					return 0, err
+				}
+				return strconv.Atoi(v)
+			},
+			SaveLastUpdateID: func(ctx context.Context, id int) error {
+				return store.SetState(ctx, storage.StateLastUpdateID, strconv.Itoa(id))
+			},
+			Dispatcher: dispatcher,
+		})
+		go func() {
+			defer close(pollerDone)
+			poller.Run(ctx)
+		}()
+	} else {
+		close(pollerDone)
+	}
+
 	go func() {
 		logger.Info("http server listening", "addr", cfg.ListenAddr)
 		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
@@ -88,9 +200,36 @@ func main() {
 	}()
 
 	<-ctx.Done()
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	logger.Info("shutting down", "timeout", cfg.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 	defer cancel()
 	_ = srv.Shutdown(shutdownCtx)
+	// The poller must stop queuing before the dispatcher closes; what it didn't queue Telegram delivers again.
+	select {
+	case <-pollerDone:
+	case <-shutdownCtx.Done():
+	}
+	// Updates already accepted are handled before exit; their saves go to the jobs table.
+	if err := dispatcher.Shutdown(shutdownCtx); err != nil {
+		logger.Warn("telegram updates left unhandled", "queued", dispatcher.QueueDepth())
+	}
+	if err := application.WaitInteractive(shutdownCtx); err != nil {
+		logger.Warn("interactive handlers did not finish in time")
+	}
+	// Albums still waiting for late parts are queued as they are rather than lost with the timers.
+	// This comes after the drain so parts from the updates handled just now are included.
+	application.MediaGroups.Flush()
+	select {
+	case <-workersDone:
+	case <-shutdownCtx.Done():
+		logger.Warn("job workers did not finish in time, interrupting running jobs")
+		abortJobs()
+		select {
+		case <-workersDone:
+		case <-time.After(5 * time.Second):
+			logger.Warn("job workers did not stop")
+		}
+	}
 	logger.Info("shutdown complete")
 }
 
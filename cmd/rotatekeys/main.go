@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"karakeep-telegram-bot/internal/storage"
+)
+
+// rotatekeys re-encrypts all stored API keys with API_KEY_MASTER_KEY. Keys sealed with
+// API_KEY_MASTER_KEY_PREVIOUS (or before versioning) are decrypted with whichever key opens them.
+func main() {
+	// A mistyped version must not silently seal keys under the default one.
+	envVersion, err := envInt("API_KEY_MASTER_KEY_VERSION", 1)
+	if err != nil {
+		fatal(err)
+	}
+	envPreviousVersion, err := envInt("API_KEY_MASTER_KEY_PREVIOUS_VERSION", 0)
+	if err != nil {
+		fatal(err)
+	}
+	var (
+		dbPath          = flag.String("db", envString("DB_PATH", "./data/bot.sqlite"), "SQLite database path (or env DB_PATH)")
+		version         = flag.Int("version", envVersion, "Version of the new master key (or env API_KEY_MASTER_KEY_VERSION)")
+		previousVersion = flag.Int("previous-version", envPreviousVersion, "Version of the old master key (or env API_KEY_MASTER_KEY_PREVIOUS_VERSION; default version-1)")
+	)
+	flag.Parse()
+
+	// Secrets come from the environment only, so they don't end up in shell history or ps output.
+	current := strings.TrimSpace(os.Getenv("API_KEY_MASTER_KEY"))
+	previous := strings.TrimSpace(os.Getenv("API_KEY_MASTER_KEY_PREVIOUS"))
+	if current == "" {
+		fatal(errors.New("missing env API_KEY_MASTER_KEY (the new master key)"))
+	}
+	if *previousVersion == 0 {
+		*previousVersion = *version - 1
+	}
+
+	ctx := context.Background()
+	store, err := storage.Open(ctx, *dbPath, storage.MasterKeys{
+		Current:         current,
+		CurrentVersion:  *version,
+		Previous:        previous,
+		PreviousVersion: *previousVersion,
+	})
+	if err != nil {
+		fatal(err)
+	}
+	defer store.Close()
+
+	stats, err := store.RotateAPIKeys(ctx)
+	if err != nil {
+		fatal(fmt.Errorf("nothing changed: %w", err))
+	}
+	fmt.Printf("ok: re-encrypted %d keys (users %d, profiles %d, workspaces %d) with master key version %d\n",
+		stats.Total(), stats.Users, stats.Profiles, stats.Workspaces, *version)
+}
+
+func envString(key, def string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+func envInt(key string, def int) (int, error) {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid integer %q", key, v)
+	}
+	return n, nil
+}
+
+func fatal(err error) {
+	_, _ = fmt.Fprintln(os.Stderr, "error:", err)
+	os.Exit(2)
+}
@@ -8,6 +8,8 @@ import (
 	"strings"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"karakeep-telegram-bot/internal/telegram"
 )
 
 func main() {
@@ -34,13 +36,7 @@ func main() {
 		fatal(err)
 	}
 
-	cfg := tgbotapi.NewWebhook(*webhookURL)
-	if *secretToken != "" {
-		cfg.SecretToken = *secretToken
-	}
-	cfg.DropPendingUpdates = *dropPending
-
-	if _, err := bot.Request(cfg); err != nil {
+	if err := telegram.SetWebhook(bot, *webhookURL, *secretToken, *dropPending); err != nil {
 		fatal(err)
 	}
 
@@ -0,0 +1,264 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"karakeep-telegram-bot/internal/storage"
+)
+
+// Access modes.
+const (
+	// AccessOpen lets anyone use the bot.
+	AccessOpen = "open"
+	// AccessAllowlist admits admins, AllowedUsers and users approved with /approve.
+	AccessAllowlist = "allowlist"
+	// AccessInvite is AccessAllowlist plus self-registration with a code from /approve invite.
+	AccessInvite = "invite"
+)
+
+// AccessPolicy decides who may use the bot. It is checked before any command or save.
+type AccessPolicy struct {
+	// Mode is AccessOpen (default), AccessAllowlist or AccessInvite.
+	Mode string
+	// Admins may always use the bot and manage access with /approve, /revoke and /users.
+	Admins []int64
+	// AllowedUsers are numeric ids or @usernames approved by configuration rather than /approve.
+	AllowedUsers []string
+}
+
+func (p AccessPolicy) open() bool {
+	return p.Mode == "" || p.Mode == AccessOpen
+}
+
+func (p AccessPolicy) isAdmin(telegramUserID int64) bool {
+	for _, id := range p.Admins {
+		if id == telegramUserID {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed reports whether the user may use the bot; errors reading the grants deny access.
+func (a *App) allowed(ctx context.Context, telegramUserID int64, username string) bool {
+	p := a.Access
+	if p.open() || p.isAdmin(telegramUserID) {
+		return true
+	}
+	id := strconv.FormatInt(telegramUserID, 10)
+	name, _ := storage.AccessSubject(username)
+	for _, s := range p.AllowedUsers {
+		if s, ok := storage.AccessSubject(s); ok && (s == id || (username != "" && s == name)) {
+			return true
+		}
+	}
+	ok, err := a.Store.IsApproved(ctx, telegramUserID, username)
+	if err != nil {
+		a.logger().Warn("access check failed", "user_id", telegramUserID, "err", err)
+		return false
+	}
+	return ok
+}
+
+// admit applies the access policy to an incoming update. Unapproved users get a refusal in private
+// (or register with an invite code via /start); everything else from them is ignored.
+func (a *App) admit(ctx context.Context, upd tgbotapi.Update) bool {
+	switch {
+	case upd.CallbackQuery != nil:
+		cq := upd.CallbackQuery
+		if cq.From == nil {
+			return false
+		}
+		if a.allowed(ctx, cq.From.ID, cq.From.UserName) {
+			return true
+		}
+		a.answerCallback(cq, "Доступ к боту ограничен.")
+		return false
+	case upd.EditedMessage != nil:
+		from := upd.EditedMessage.From
+		return from != nil && a.allowed(ctx, from.ID, from.UserName)
+	case upd.Message != nil:
+		msg := upd.Message
+		if msg.From == nil || msg.Chat == nil {
+			return false
+		}
+		if a.allowed(ctx, msg.From.ID, msg.From.UserName) {
+			return true
+		}
+		if msg.Chat.IsPrivate() {
+			a.refuseAccess(ctx, msg)
+		}
+		return false
+	}
+	// Channel posts have no sender; they are checked against the channel's owner when queued.
+	return true
+}
+
+func (a *App) refuseAccess(ctx context.Context, msg *tgbotapi.Message) {
+	if a.Access.Mode == AccessInvite && msg.IsCommand() && strings.ToLower(msg.Command()) == "start" {
+		if code := strings.TrimSpace(msg.CommandArguments()); code != "" {
+			a.redeemAccessInvite(ctx, msg, code)
+			return
+		}
+	}
+	text := "Доступ к боту ограничен. Ваш id: " + strconv.FormatInt(msg.From.ID, 10) + " — передайте его администратору."
+	if a.Access.Mode == AccessInvite {
+		text += "\nЕсли у вас есть код приглашения: /start <код>"
+	}
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, text))
+}
+
+func (a *App) redeemAccessInvite(ctx context.Context, msg *tgbotapi.Message, code string) {
+	err := a.Store.RedeemAccessInvite(ctx, code, msg.From.ID, msg.From.UserName)
+	switch {
+	case errors.Is(err, storage.ErrInviteInvalid):
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Код приглашения неизвестен, уже использован или истёк."))
+		return
+	case err != nil:
+		a.logger().Warn("redeem access invite failed", "err", err)
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Не удалось принять приглашение."))
+		return
+	}
+	a.logger().Info("access granted by invite", "user_id", msg.From.ID)
+	if err := a.Store.UpsertUser(ctx, msg.From.ID); err != nil {
+		a.logger().Warn("upsert user failed", "err", err)
+	}
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "✅ Доступ открыт."))
+	a.cmdStart(ctx, msg)
+}
+
+const accessUsage = "Использование:\n" +
+	"/approve <id|@username> — открыть доступ\n" +
+	"/approve invite — одноразовый код приглашения (режим invite)\n" +
+	"/revoke <id|@username> — закрыть доступ\n" +
+	"/users — режим доступа и список пользователей"
+
+// requireBotAdmin answers non-admins as if the command didn't exist.
+func (a *App) requireBotAdmin(msg *tgbotapi.Message) bool {
+	if a.Access.isAdmin(msg.From.ID) {
+		return true
+	}
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Неизвестная команда. /help"))
+	return false
+}
+
+func (a *App) cmdApprove(ctx context.Context, msg *tgbotapi.Message) {
+	if !a.requireBotAdmin(msg) {
+		return
+	}
+	arg := strings.TrimSpace(msg.CommandArguments())
+	if strings.EqualFold(arg, "invite") {
+		a.createAccessInvite(ctx, msg)
+		return
+	}
+	subject, ok := storage.AccessSubject(arg)
+	if !ok {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, accessUsage))
+		return
+	}
+	if err := a.Store.ApproveUser(ctx, subject, msg.From.ID); err != nil {
+		a.logger().Warn("approve user failed", "err", err)
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Не удалось открыть доступ."))
+		return
+	}
+	a.logger().Info("access approved", "subject", subject, "by", msg.From.ID)
+	text := "✅ Доступ открыт: " + subject
+	if a.Access.open() {
+		text += "\n\nСейчас режим open: бот и так доступен всем. Режим задаётся ACCESS_MODE."
+	}
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, text))
+}
+
+func (a *App) createAccessInvite(ctx context.Context, msg *tgbotapi.Message) {
+	if a.Access.Mode != AccessInvite {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Коды приглашений работают только в режиме invite (ACCESS_MODE=invite)."))
+		return
+	}
+	code, expiresAt, err := a.Store.CreateAccessInvite(ctx, msg.From.ID)
+	if err != nil {
+		a.logger().Warn("create access invite failed", "err", err)
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Не удалось создать приглашение."))
+		return
+	}
+	text := "Код приглашения (одноразовый, до " + expiresAt.Format("02.01.2006 15:04") + " UTC):\n" +
+		"/start " + code
+	if a.Bot.Self.UserName != "" {
+		text += "\n\nИли ссылка: https://t.me/" + a.Bot.Self.UserName + "?start=" + code
+	}
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, text))
+}
+
+func (a *App) cmdRevoke(ctx context.Context, msg *tgbotapi.Message) {
+	if !a.requireBotAdmin(msg) {
+		return
+	}
+	subject, ok := storage.AccessSubject(msg.CommandArguments())
+	if !ok {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, accessUsage))
+		return
+	}
+	removed, err := a.Store.RevokeUser(ctx, subject)
+	if err != nil {
+		a.logger().Warn("revoke user failed", "err", err)
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Не удалось закрыть доступ."))
+		return
+	}
+	if !removed {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, subject+" не найден среди одобренных через /approve."))
+		return
+	}
+	a.logger().Info("access revoked", "subject", subject, "by", msg.From.ID)
+	text := "🚫 Доступ закрыт: " + subject
+	for _, s := range a.Access.AllowedUsers {
+		if s, _ := storage.AccessSubject(s); s == subject {
+			text += "\n\nОн также указан в ALLOWED_USERS — уберите его оттуда."
+			break
+		}
+	}
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, text))
+}
+
+func (a *App) cmdUsers(ctx context.Context, msg *tgbotapi.Message) {
+	if !a.requireBotAdmin(msg) {
+		return
+	}
+	grants, err := a.Store.AccessGrants(ctx)
+	if err != nil {
+		a.logger().Warn("list access grants failed", "err", err)
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Ошибка чтения списка пользователей."))
+		return
+	}
+	mode := a.Access.Mode
+	if mode == "" {
+		mode = AccessOpen
+	}
+	var b strings.Builder
+	b.WriteString("Режим доступа: " + mode + "\n\nАдминистраторы:\n")
+	for _, id := range a.Access.Admins {
+		b.WriteString("  " + strconv.FormatInt(id, 10) + "\n")
+	}
+	if len(a.Access.AllowedUsers) > 0 {
+		b.WriteString("\nИз ALLOWED_USERS:\n")
+		for _, s := range a.Access.AllowedUsers {
+			b.WriteString("  " + s + "\n")
+		}
+	}
+	b.WriteString("\nОдобренные:\n")
+	if len(grants) == 0 {
+		b.WriteString("  (нет)\n")
+	}
+	for _, g := range grants {
+		line := "  " + g.Subject
+		if g.Username != "" {
+			line += " (@" + g.Username + ")"
+		}
+		b.WriteString(line + " — " + g.CreatedAt.Format("02.01.2006") + "\n")
+	}
+	b.WriteString("\n" + accessUsage)
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, b.String()))
+}
@@ -4,15 +4,18 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"strings"
+	"sync"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 
 	"karakeep-telegram-bot/internal/classifier"
 	"karakeep-telegram-bot/internal/karakeep"
+	"karakeep-telegram-bot/internal/ratelimit"
 	"karakeep-telegram-bot/internal/security"
 	"karakeep-telegram-bot/internal/storage"
 	"karakeep-telegram-bot/internal/telegram"
@@ -30,6 +33,33 @@ type App struct {
 	Downloader *telegram.Downloader
 
 	MaxUploadBytes int64
+
+	// StripHashtags removes #tags from note text; they are attached as Karakeep tags either way.
+	StripHashtags bool
+
+	// JobMaxAttempts caps retries of a save job before it is marked failed (default 5).
+	JobMaxAttempts int
+	// JobRetention is how long finished jobs are kept before RunJobWorkers prunes them (default 7 days).
+	JobRetention time.Duration
+
+	// Access restricts who may use the bot; the zero value lets anyone.
+	Access AccessPolicy
+
+	// Limits throttles users and Karakeep servers; the zero value doesn't limit anything.
+	Limits Limits
+
+	// InteractiveWorkers caps handlers waiting on Karakeep for a user's answer at once (default 16).
+	InteractiveWorkers int
+
+	jobsWakeOnce sync.Once
+	jobsWakeCh   chan struct{}
+
+	noticesOnce    sync.Once
+	noticesLimiter *ratelimit.Limiter
+
+	interactiveOnce sync.Once
+	interactiveCh   chan struct{}
+	interactiveWG   sync.WaitGroup
 }
 
 func (a *App) HandleUpdate(ctx context.Context, upd tgbotapi.Update) {
@@ -37,11 +67,34 @@ func (a *App) HandleUpdate(ctx context.Context, upd tgbotapi.Update) {
 	if log == nil {
 		log = slog.Default()
 	}
+	if !a.admit(ctx, upd) {
+		return
+	}
+	if upd.CallbackQuery != nil {
+		if a.throttledCallback(upd.CallbackQuery) {
+			return
+		}
+		cq := upd.CallbackQuery
+		a.goInteractive(func() { a.handleCallback(ctx, cq) })
+		return
+	}
+	if upd.EditedMessage != nil {
+		a.handleEditedMessage(ctx, upd.EditedMessage)
+		return
+	}
+	if upd.ChannelPost != nil {
+		a.handleChannelPost(ctx, upd.ChannelPost)
+		return
+	}
 	if upd.Message == nil {
 		return
 	}
 	msg := upd.Message
-	if msg.From == nil {
+	if msg.From == nil || msg.Chat == nil {
+		return
+	}
+	if !msg.Chat.IsPrivate() {
+		a.handleGroupMessage(ctx, msg)
 		return
 	}
 
@@ -50,94 +103,169 @@ func (a *App) HandleUpdate(ctx context.Context, upd tgbotapi.Update) {
 		log.Warn("upsert user failed", "err", err)
 	}
 
-	// Commands: only in private chats.
-	if msg.IsCommand() {
-		if msg.Chat == nil || !msg.Chat.IsPrivate() {
-			_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Команды настройки доступны только в личных сообщениях с ботом."))
-			return
-		}
+	// Answers to questions the bot asked (ForceReply) are not saved as content. They come before
+	// the throttle: one answer per prompt is no flood, and a dropped API key would stay in the chat.
+	if a.handlePendingInput(ctx, msg) {
+		return
+	}
+
+	// An album is one save: it is charged once, in HandleMediaGroup.
+	if (msg.MediaGroupID == "" || a.MediaGroups == nil) && a.throttled(msg) {
+		return
+	}
 
+	if msg.IsCommand() {
 		switch strings.ToLower(msg.Command()) {
 		case "start":
 			a.cmdStart(ctx, msg)
 		case "help":
 			a.cmdHelp(ctx, msg)
 		case "server":
-			a.cmdServer(ctx, msg)
+			a.goInteractive(func() { a.cmdServer(ctx, msg) })
 		case "key":
-			a.cmdKey(ctx, msg)
+			a.goInteractive(func() { a.cmdKey(ctx, msg) })
 		case "status":
 			a.cmdStatus(ctx, msg)
+		case "list":
+			a.goInteractive(func() { a.cmdList(ctx, msg) })
+		case "search":
+			a.goInteractive(func() { a.cmdSearch(ctx, msg) })
+		case "undo":
+			a.goInteractive(func() { a.cmdUndo(ctx, msg) })
+		case "recent":
+			a.cmdRecent(ctx, msg)
+		case "links":
+			a.cmdLinks(ctx, msg)
+		case "bindchannel":
+			a.goInteractive(func() { a.cmdBindChannel(ctx, msg) })
+		case "unbindchannel":
+			a.cmdUnbindChannel(ctx, msg)
+		case "workspace":
+			a.cmdWorkspace(ctx, msg)
+		case "join":
+			a.cmdJoin(ctx, msg)
+		case "profile":
+			a.cmdProfile(ctx, msg)
+		case "use":
+			a.cmdUse(ctx, msg)
+		case "approve":
+			a.cmdApprove(ctx, msg)
+		case "revoke":
+			a.cmdRevoke(ctx, msg)
+		case "users":
+			a.cmdUsers(ctx, msg)
 		default:
 			_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Неизвестная команда. /help"))
 		}
 		return
 	}
 
-	// Non-command message: in the next todo we'll actually save it to Karakeep.
-	// We do a fast ACK, then process in background and edit the ACK message when enrichment is done.
+	// Non-command message: persist a save job; workers ACK, save to Karakeep and edit the ACK when enrichment is done.
 	if msg.MediaGroupID != "" && a.MediaGroups != nil {
 		a.MediaGroups.Collect(msg)
 		return
 	}
-	go a.processSingleMessage(context.Background(), msg)
+	if err := a.enqueueSave(ctx, jobKindMessage, []*tgbotapi.Message{msg}); err != nil {
+		log.Warn("enqueue message failed", "err", err)
+		a.reportEnqueueFailure(msg)
+	}
 }
 
 func (a *App) HandleMediaGroup(groupID string, msgs []*tgbotapi.Message) {
-	log := a.Logger
-	if log == nil {
-		log = slog.Default()
-	}
 	if len(msgs) == 0 {
 		return
 	}
-
-	go a.processMediaGroup(context.Background(), groupID, msgs)
-}
-
-func (a *App) processMediaGroup(ctx context.Context, groupID string, msgs []*tgbotapi.Message) {
-	// Pick the message that has caption/text if any, otherwise first.
-	pick := msgs[0]
-	for _, m := range msgs {
-		if strings.TrimSpace(m.Caption) != "" || strings.TrimSpace(m.Text) != "" {
-			pick = m
-			break
+	ctx := context.Background()
+	if first := msgs[0]; first.Chat != nil && first.Chat.IsChannel() {
+		a.enqueueChannelPost(ctx, msgs)
+		return
+	}
+	if first := msgs[0]; first.Chat != nil && !first.Chat.IsPrivate() {
+		if first.From == nil || first.SenderChat != nil {
+			return
+		}
+		cs, err := a.Store.GetChatSettings(ctx, first.Chat.ID)
+		if err != nil {
+			a.logger().Warn("get chat settings failed", "chat_id", first.Chat.ID, "err", err)
+			return
+		}
+		if !a.groupTriggered(cs, pickCaptionMessage(msgs)) {
+			return
+		}
+		if err := a.Store.UpsertUser(ctx, first.From.ID); err != nil {
+			a.logger().Warn("upsert user failed", "err", err)
 		}
 	}
-	// Process album as a single unit: caption from pick, attachments from all messages.
-	a.processMessageBatch(ctx, pick, msgs)
+	if first := pickCaptionMessage(msgs); first.From == nil || a.throttled(first) {
+		return
+	}
+	if err := a.enqueueSave(ctx, jobKindMediaGroup, msgs); err != nil {
+		a.logger().Warn("enqueue media group failed", "media_group_id", groupID, "err", err)
+		a.reportEnqueueFailure(msgs[0])
+	}
 }
 
-func (a *App) processSingleMessage(ctx context.Context, msg *tgbotapi.Message) {
-	a.processMessageBatch(ctx, msg, []*tgbotapi.Message{msg})
+func (a *App) reportEnqueueFailure(msg *tgbotapi.Message) {
+	if msg == nil || msg.Chat == nil {
+		return
+	}
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Не удалось поставить сообщение в очередь. Попробуйте ещё раз."))
 }
 
-func (a *App) processMessageBatch(ctx context.Context, msg *tgbotapi.Message, batch []*tgbotapi.Message) {
-	log := a.Logger
-	if log == nil {
-		log = slog.Default()
-	}
+// processMessageBatch saves msg (plus attachments from batch) to Karakeep on behalf of job.
+// It reuses job.AckMessageID / job.BookmarkID when the job is resumed, so a restart neither
+// spams a new ack nor creates a duplicate bookmark. Errors wrapped with retryable() are retried by the worker.
+func (a *App) processMessageBatch(ctx context.Context, job *storage.Job, msg *tgbotapi.Message, batch []*tgbotapi.Message) error {
+	log := a.logger()
 	if msg == nil || msg.From == nil || msg.Chat == nil {
-		return
+		return errors.New("message without sender/chat")
 	}
+	persistCtx := context.WithoutCancel(ctx)
 
 	u, err := a.Store.GetUser(ctx, msg.From.ID)
 	if err != nil {
-		return
+		return retryable(fmt.Errorf("get user: %w", err))
 	}
-	apiKey, ok, err := a.Store.DecryptAPIKey(u)
+	res := classifier.ClassifyMessageWithOptions(msg, a.classifyOptions(ctx, msg))
+	creds, err := a.Store.ResolveCredentials(ctx, u, res.Profile)
 	if err != nil {
-		log.Warn("decrypt api key failed", "err", err)
-		return
+		log.Warn("resolve credentials failed", "err", err)
+		return err
 	}
-	if strings.TrimSpace(u.ServerBaseURL) == "" || !ok {
-		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Не настроено. Сначала: /server https://<host> и /key <API_KEY>"))
-		return
+	if !creds.Configured() {
+		text := "❌ Не настроено. Сначала: /server https://<host> и /key <API_KEY>"
+		if !msg.Chat.IsPrivate() {
+			text = "❌ Не настроено. Напишите мне в личные сообщения: /server https://<host> и /key <API_KEY>"
+		}
+		if res.Profile != "" {
+			text = "❌ Профиль @" + res.Profile + " не настроен: /use " + res.Profile + ", затем /server и /key"
+		}
+		if job.AckMessageID != 0 {
+			_ = a.editAck(msg.Chat.ID, job.AckMessageID, text)
+		} else {
+			a.replyInThread(msg, text)
+		}
+		return errors.New("user is not configured")
+	}
+	if err := a.throttleServer(creds.ServerBaseURL); err != nil {
+		text := "⏳ На ваш сервер Karakeep сейчас идёт много сохранений, продолжу чуть позже…"
+		if job.AckMessageID != 0 {
+			_ = a.editAck(msg.Chat.ID, job.AckMessageID, text)
+		} else {
+			_, _ = a.ensureAck(job, msg.Chat.ID, text)
+		}
+		return err
+	}
+
+	// A reply to an already saved message extends that bookmark instead of creating a new one.
+	if target, ok := a.replyTarget(ctx, msg); ok {
+		return a.processReply(ctx, job, msg, batch, target)
 	}
 
-	res := classifier.ClassifyMessage(msg)
 	attachments := ExtractAttachments(batch)
 	log.Info("processing message",
+		"job_id", job.ID,
+		"attempt", job.Attempts,
 		"user_id", msg.From.ID,
 		"chat_id", msg.Chat.ID,
 		"message_id", msg.MessageID,
@@ -145,141 +273,310 @@ func (a *App) processMessageBatch(ctx context.Context, msg *tgbotapi.Message, ba
 		"urls_count", len(res.URLs),
 		"has_media", res.HasMedia,
 		"attachments_count", len(attachments),
+		"tags_count", len(res.Tags),
+		"forwarded", res.Forward != nil,
+		"profile", creds.Profile,
 	)
+	split := splitLinks(u, res)
+	saveKind := string(res.Kind)
+	if split {
+		saveKind = kindLinks
+	}
+	if job.BookmarkID == "" {
+		a.recordSave(persistCtx, job, saveKind, storage.SavePending, "")
+		if err := a.Store.SetSaveProfile(persistCtx, job.TelegramUserID, job.ChatID, job.MessageID, creds.Profile); err != nil {
+			log.Warn("record save profile failed", "job_id", job.ID, "err", err)
+		}
+	}
 
 	ackText := ""
-	switch res.Kind {
-	case classifier.KindBookmark:
+	switch {
+	case split:
+		ackText = fmt.Sprintf("⏳ Сохраняю ссылки (%d)…", len(res.URLs))
+	case res.Kind == classifier.KindBookmark:
 		ackText = "⏳ Сохраняю как закладку…"
-	case classifier.KindNote:
+	case res.Kind == classifier.KindNote:
 		ackText = "⏳ Сохраняю как заметку…"
-	case classifier.KindFile:
+	case res.Kind == classifier.KindFile:
 		ackText = "⏳ Загружаю файл…"
 	default:
 		ackText = "⏳ Сохраняю…"
 	}
-
-	ackMsg, err := a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, ackText))
+	ackID, err := a.ensureAck(job, msg.Chat.ID, ackText)
 	if err != nil {
-		log.Warn("failed to send ack", "err", err)
-		return
+		return err
 	}
 
 	client, err := karakeep.NewClient(karakeep.ClientOpts{
-		BaseURL: u.ServerBaseURL,
-		APIKey:  apiKey,
+		BaseURL: creds.ServerBaseURL,
+		APIKey:  creds.APIKey,
 		Timeout: 60 * time.Second,
 	})
 	if err != nil {
-		_ = a.editAck(msg.Chat.ID, ackMsg.MessageID, "❌ Ошибка конфигурации Karakeep: "+err.Error())
-		return
+		_ = a.editAck(msg.Chat.ID, ackID, "❌ Ошибка конфигурации Karakeep: "+err.Error())
+		return err
 	}
 
-	var b karakeep.Bookmark
-	var status int
-
-	switch res.Kind {
-	case classifier.KindBookmark:
-		b, status, err = client.CreateBookmark(ctx, res.URL, "", res.Notes)
-	case classifier.KindNote:
-		// Text note: create text-type bookmark. If text contains URLs and server requires link-type, fallback to first URL.
-		b, status, err = client.CreateBookmark(ctx, "", "", res.Text)
-		if err != nil && len(res.URLs) > 0 {
-			b, status, err = client.CreateBookmark(ctx, res.URLs[0], "", res.Text)
-		}
-	case classifier.KindFile:
-		notes := fmt.Sprintf("Telegram media (%s)", time.Unix(int64(msg.Date), 0).UTC().Format(time.RFC3339))
-		b, status, err = client.CreateBookmark(ctx, "", "", notes)
+	if split {
+		return a.processLinks(ctx, job, msg, client, u, res, ackID)
 	}
 
-	if err != nil {
-		log.Warn("karakeep create failed", "status", status, "err", err)
-		_ = a.editAck(msg.Chat.ID, ackMsg.MessageID, userFacingKarakeepError(status, err))
-		return
+	// footer carries extra per-save lines (tags, target list) into every later ack edit.
+	var footer []string
+	if job.Footer != "" {
+		footer = strings.Split(job.Footer, "\n")
 	}
-	log.Info("karakeep created", "bookmark_id", b.ID, "status", status)
-
-	// Upload + attach assets (if any)
-	if b.ID != "" && len(attachments) > 0 {
-		if a.Downloader == nil {
-			a.Downloader = telegram.NewDownloader(a.Bot)
-		}
-		maxBytes := a.MaxUploadBytes
-		if maxBytes <= 0 {
-			maxBytes = 50 << 20
+	withFooter := func(text string) string {
+		if len(footer) == 0 {
+			return text
 		}
-		for _, att := range attachments {
-			if att.SizeBytes > 0 && att.SizeBytes > maxBytes {
-				_ = a.editAck(msg.Chat.ID, ackMsg.MessageID, fmt.Sprintf("❌ Слишком большой файл: %s (%d bytes), лимит %d bytes", att.Filename, att.SizeBytes, maxBytes))
-				return
+		return text + "\n\n" + strings.Join(footer, "\n")
+	}
+
+	b := karakeep.Bookmark{ID: job.BookmarkID}
+	if job.BookmarkID == "" {
+		var status int
+		b, status, err = createBookmark(ctx, client, msg, res)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
 			}
-			data, filePath, err := a.Downloader.DownloadFileByID(ctx, att.FileID, maxBytes)
-			if err != nil {
-				log.Warn("telegram download failed", "err", err)
-				_ = a.editAck(msg.Chat.ID, ackMsg.MessageID, "❌ Ошибка скачивания файла из Telegram: "+err.Error())
-				return
+			log.Warn("karakeep create failed", "status", status, "err", err)
+			_ = a.editAck(msg.Chat.ID, ackID, userFacingKarakeepError(status, err))
+			if transientStatus(status) {
+				return retryable(err)
 			}
-			filename := att.Filename
-			if strings.TrimSpace(filename) == "" {
-				// fallback to filePath tail
-				parts := strings.Split(filePath, "/")
-				if len(parts) > 0 {
-					filename = parts[len(parts)-1]
-				}
+			return err
+		}
+		log.Info("karakeep created", "bookmark_id", b.ID, "status", status)
+
+		// Remember the bookmark right away: a retry after a failed upload must not create a duplicate.
+		if b.ID != "" {
+			if err := a.Store.SetJobBookmarkID(persistCtx, job.ID, b.ID); err != nil {
+				log.Warn("persist job bookmark id failed", "job_id", job.ID, "err", err)
 			}
-			asset, st, err := client.UploadAsset(ctx, data, filename, att.Mime)
-			if err != nil {
-				log.Warn("karakeep upload asset failed", "status", st, "err", err)
-				_ = a.editAck(msg.Chat.ID, ackMsg.MessageID, fmt.Sprintf("❌ Ошибка загрузки в Karakeep (%d): %v", st, err))
-				return
+			job.BookmarkID = b.ID
+		}
+	}
+
+	if job.Stage < storage.JobStageSetUp {
+		if err := a.attachAssets(ctx, client, job, msg.Chat.ID, ackID, b.ID, attachments); err != nil {
+			return err
+		}
+		if b.ID != "" && len(res.Tags) > 0 {
+			if st, err := client.AttachTags(ctx, b.ID, res.Tags); err != nil {
+				log.Warn("karakeep attach tags failed", "status", st, "err", err)
+				footer = append(footer, "⚠️ Не удалось добавить теги")
+			} else {
+				footer = append(footer, "🏷 #"+strings.Join(res.Tags, " #"))
 			}
-			if strings.TrimSpace(asset.ID) == "" {
-				log.Warn("karakeep upload asset returned empty id")
-				_ = a.editAck(msg.Chat.ID, ackMsg.MessageID, "❌ Karakeep вернул asset без id (проверьте схему Upload a new asset).")
-				return
+		}
+		if b.ID != "" {
+			if line := a.addToTargetList(ctx, client, u, res.ListName, b.ID); line != "" {
+				footer = append(footer, line)
 			}
-			_, st, err = client.AttachAsset(ctx, b.ID, asset.ID)
-			if err != nil {
-				log.Warn("karakeep attach asset failed", "status", st, "err", err)
-				_ = a.editAck(msg.Chat.ID, ackMsg.MessageID, fmt.Sprintf("❌ Ошибка attach asset (%d): %v", st, err))
-				return
+		}
+
+		// The footer goes first: a job resumed at JobStageSetUp can't rebuild it without redoing tags and list.
+		if len(footer) > 0 {
+			job.Footer = strings.Join(footer, "\n")
+			if err := a.Store.SetJobFooter(persistCtx, job.ID, job.Footer); err != nil {
+				log.Warn("persist job footer failed", "job_id", job.ID, "err", err)
 			}
 		}
-	}
+		if err := a.Store.SetJobStage(persistCtx, job.ID, storage.JobStageSetUp); err != nil {
+			log.Warn("persist job stage failed", "job_id", job.ID, "err", err)
+		}
+		job.Stage = storage.JobStageSetUp
+		if b.ID != "" {
+			a.recordSave(persistCtx, job, string(res.Kind), storage.SaveSaved, "")
+		}
 
-	_ = a.Store.SetLastSuccess(ctx, msg.From.ID, b.ID)
+		_ = a.Store.SetLastSuccess(persistCtx, msg.From.ID, b.ID)
+	}
 
-	_ = a.editAck(msg.Chat.ID, ackMsg.MessageID, fmt.Sprintf("✅ Сохранено (id=%s). Жду загрузку контента…", b.ID))
+	var kb *tgbotapi.InlineKeyboardMarkup
+	if b.ID != "" {
+		markup := bookmarkKeyboard(b.ID)
+		kb = &markup
+	}
+	_ = a.editAckMarkup(msg.Chat.ID, ackID, withFooter(fmt.Sprintf("✅ Сохранено (id=%s). Жду загрузку контента…", b.ID)), kb)
 
 	// Enrichment:
 	// - For link bookmarks: poll until Karakeep extracted content, then summarize.
 	// - For text notes: summarize immediately.
+	// If the bookmark is deleted meanwhile (🗑 button), stop quietly: the ack already says so.
 	if b.ID == "" {
-		_ = a.editAck(msg.Chat.ID, ackMsg.MessageID, "✅ Сохранено.")
-		return
+		_ = a.editAckMarkup(msg.Chat.ID, ackID, withFooter("✅ Сохранено."), kb)
+		return nil
 	}
 
 	ready := true
 	if res.Kind == classifier.KindBookmark {
-		ready = a.waitForExtractedContent(ctx, client, b.ID, 3*time.Second, 3*time.Minute)
+		var err error
+		ready, err = a.waitForExtractedContent(ctx, client, b.ID, 3*time.Second, 3*time.Minute)
+		if errors.Is(err, errBookmarkGone) {
+			return nil
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
 
 	if !ready {
-		_ = a.editAck(msg.Chat.ID, ackMsg.MessageID, "⚠️ Контент не загрузился за 3 минуты. Смотрите саммари в приложении.")
-		return
+		_ = a.editAckMarkup(msg.Chat.ID, ackID, withFooter("⚠️ Контент не загрузился за 3 минуты. Смотрите саммари в приложении."), kb)
+		return nil
 	}
 
-	got, ok := a.waitForNonEmptySummary(ctx, client, b.ID, 3*time.Second, 3*time.Minute)
+	got, ok, err := a.waitForNonEmptySummary(ctx, client, b.ID, 3*time.Second, 3*time.Minute)
+	if errors.Is(err, errBookmarkGone) {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 	if ok {
 		final := formatFinalMessage(res.Kind, got)
-		_ = a.editAck(msg.Chat.ID, ackMsg.MessageID, final)
-		return
+		_ = a.editAckMarkup(msg.Chat.ID, ackID, withFooter(final), kb)
+		return nil
+	}
+	_ = a.editAckMarkup(msg.Chat.ID, ackID, withFooter("⚠️ Саммари ещё не готово. Смотрите саммари в приложении."), kb)
+	return nil
+}
+
+// createBookmark creates the bookmark for a classified message; assets, tags and lists are added by the caller.
+func createBookmark(ctx context.Context, client *karakeep.Client, msg *tgbotapi.Message, res classifier.Result) (karakeep.Bookmark, int, error) {
+	switch res.Kind {
+	case classifier.KindBookmark:
+		return client.CreateBookmark(ctx, res.URL, "", res.Notes)
+	case classifier.KindNote:
+		// Text note: create text-type bookmark. If text contains URLs and server requires link-type, fallback to first URL.
+		b, status, err := client.CreateBookmark(ctx, "", "", res.Text)
+		if err != nil && len(res.URLs) > 0 {
+			b, status, err = client.CreateBookmark(ctx, res.URLs[0], "", res.Text)
+		}
+		return b, status, err
+	case classifier.KindFile:
+		notes := res.Notes
+		if notes == "" {
+			notes = fmt.Sprintf("Telegram media (%s)", time.Unix(int64(msg.Date), 0).UTC().Format(time.RFC3339))
+		}
+		return client.CreateBookmark(ctx, "", "", notes)
+	}
+	return karakeep.Bookmark{}, 0, fmt.Errorf("unknown kind %q", res.Kind)
+}
+
+// attachAssets downloads attachments from Telegram and attaches them to bookmarkID.
+// Progress is persisted in job.AssetsAttached after each one, so a retried job only attaches the rest.
+// Failures are reported in the ack; ackID 0 means there is no ack to edit (channel posts).
+func (a *App) attachAssets(ctx context.Context, client *karakeep.Client, job *storage.Job, chatID int64, ackID int, bookmarkID string, attachments []Attachment) error {
+	log := a.logger()
+	if bookmarkID == "" || job.AssetsAttached >= len(attachments) {
+		return nil
+	}
+	report := func(text string) {
+		if ackID != 0 {
+			_ = a.editAck(chatID, ackID, text)
+		}
+	}
+	if a.Downloader == nil {
+		a.Downloader = telegram.NewDownloader(a.Bot)
+	}
+	maxBytes := a.MaxUploadBytes
+	if maxBytes <= 0 {
+		maxBytes = 50 << 20
+	}
+	for _, att := range attachments[job.AssetsAttached:] {
+		if att.SizeBytes > 0 && att.SizeBytes > maxBytes {
+			report(fmt.Sprintf("❌ Слишком большой файл: %s (%d bytes), лимит %d bytes", att.Filename, att.SizeBytes, maxBytes))
+			return fmt.Errorf("attachment too large: %d bytes", att.SizeBytes)
+		}
+		data, filePath, err := a.Downloader.DownloadFileByID(ctx, att.FileID, maxBytes)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Warn("telegram download failed", "err", err)
+			report("❌ Ошибка скачивания файла из Telegram: " + err.Error())
+			return retryable(err)
+		}
+		filename := att.Filename
+		if strings.TrimSpace(filename) == "" {
+			// fallback to filePath tail
+			parts := strings.Split(filePath, "/")
+			if len(parts) > 0 {
+				filename = parts[len(parts)-1]
+			}
+		}
+		asset, st, err := client.UploadAsset(ctx, data, filename, att.Mime)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Warn("karakeep upload asset failed", "status", st, "err", err)
+			report(fmt.Sprintf("❌ Ошибка загрузки в Karakeep (%d): %v", st, err))
+			if transientStatus(st) {
+				return retryable(err)
+			}
+			return err
+		}
+		if strings.TrimSpace(asset.ID) == "" {
+			log.Warn("karakeep upload asset returned empty id")
+			report("❌ Karakeep вернул asset без id (проверьте схему Upload a new asset).")
+			return errors.New("karakeep upload asset returned empty id")
+		}
+		_, st, err = client.AttachAsset(ctx, bookmarkID, asset.ID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Warn("karakeep attach asset failed", "status", st, "err", err)
+			report(fmt.Sprintf("❌ Ошибка attach asset (%d): %v", st, err))
+			if transientStatus(st) {
+				return retryable(err)
+			}
+			return err
+		}
+		job.AssetsAttached++
+		if err := a.Store.SetJobAssetsAttached(context.WithoutCancel(ctx), job.ID, job.AssetsAttached); err != nil {
+			log.Warn("persist attached assets failed", "job_id", job.ID, "err", err)
+		}
+	}
+	return nil
+}
+
+// ensureAck sends the progress message for job, or re-edits the one sent before a restart.
+func (a *App) ensureAck(job *storage.Job, chatID int64, text string) (int, error) {
+	if job.AckMessageID != 0 {
+		_ = a.editAck(chatID, job.AckMessageID, "⏳ Возобновляю сохранение…")
+		return job.AckMessageID, nil
+	}
+	out := tgbotapi.NewMessage(chatID, text)
+	if chatID != job.TelegramUserID {
+		// Group chat: answer in-thread so it's clear whose message was saved.
+		out.ReplyToMessageID = job.MessageID
+		out.AllowSendingWithoutReply = true
+	}
+	ackMsg, err := a.Bot.Send(out)
+	if err != nil {
+		a.logger().Warn("failed to send ack", "err", err)
+		return 0, retryable(fmt.Errorf("send ack: %w", err))
+	}
+	job.AckMessageID = ackMsg.MessageID
+	if err := a.Store.SetJobAckMessageID(context.Background(), job.ID, ackMsg.MessageID); err != nil {
+		a.logger().Warn("persist ack message id failed", "job_id", job.ID, "err", err)
 	}
-	_ = a.editAck(msg.Chat.ID, ackMsg.MessageID, "⚠️ Саммари ещё не готово. Смотрите саммари в приложении.")
+	return ackMsg.MessageID, nil
 }
 
 func (a *App) editAck(chatID int64, messageID int, text string) error {
+	return a.editAckMarkup(chatID, messageID, text, nil)
+}
+
+// editAckMarkup edits the ack text and sets its inline keyboard; a nil markup removes the keyboard.
+func (a *App) editAckMarkup(chatID int64, messageID int, text string, markup *tgbotapi.InlineKeyboardMarkup) error {
 	edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
+	edit.ReplyMarkup = markup
 	_, err := a.Bot.Send(edit)
 	if err != nil {
 		log := a.Logger
@@ -352,7 +649,10 @@ func userFacingKarakeepError(status int, err error) string {
 	return msg
 }
 
-func (a *App) waitForExtractedContent(ctx context.Context, client *karakeep.Client, bookmarkID string, interval time.Duration, timeout time.Duration) bool {
+// errBookmarkGone is returned by the polling helpers when the bookmark was deleted while we waited.
+var errBookmarkGone = errors.New("bookmark was deleted")
+
+func (a *App) waitForExtractedContent(ctx context.Context, client *karakeep.Client, bookmarkID string, interval time.Duration, timeout time.Duration) (bool, error) {
 	log := a.Logger
 	if log == nil {
 		log = slog.Default()
@@ -383,18 +683,20 @@ func (a *App) waitForExtractedContent(ctx context.Context, client *karakeep.Clie
 				)
 			}
 			if ok {
-				return true
+				return true, nil
 			}
+		} else if karakeep.IsNotFound(err) {
+			return false, errBookmarkGone
 		} else {
 			log.Warn("karakeep get bookmark during poll failed", "err", err)
 		}
 
 		if time.Now().After(deadline) {
-			return false
+			return false, nil
 		}
 		select {
 		case <-ctx.Done():
-			return false
+			return false, ctx.Err()
 		case <-t.C:
 		}
 	}
@@ -463,7 +765,7 @@ func findNonTrivialContent(v any, depth int, sig map[string]any) (bool, map[stri
 	return false, sig
 }
 
-func (a *App) waitForNonEmptySummary(ctx context.Context, client *karakeep.Client, bookmarkID string, interval time.Duration, timeout time.Duration) (karakeep.Bookmark, bool) {
+func (a *App) waitForNonEmptySummary(ctx context.Context, client *karakeep.Client, bookmarkID string, interval time.Duration, timeout time.Duration) (karakeep.Bookmark, bool, error) {
 	log := a.Logger
 	if log == nil {
 		log = slog.Default()
@@ -491,18 +793,20 @@ func (a *App) waitForNonEmptySummary(ctx context.Context, client *karakeep.Clien
 				log.Info("summary poll", "bookmark_id", bookmarkID, "len", len(s))
 			}
 			if s != "" && !looksEmptySummary(s) {
-				return got, true
+				return got, true, nil
 			}
+		} else if karakeep.IsNotFound(err) {
+			return karakeep.Bookmark{}, false, errBookmarkGone
 		} else {
 			log.Warn("karakeep get bookmark during summary poll failed", "err", err)
 		}
 
 		if time.Now().After(deadline) {
-			return karakeep.Bookmark{}, false
+			return karakeep.Bookmark{}, false, nil
 		}
 		select {
 		case <-ctx.Done():
-			return karakeep.Bookmark{}, false
+			return karakeep.Bookmark{}, false, ctx.Err()
 		case <-t.C:
 		}
 	}
@@ -514,13 +818,9 @@ func looksEmptySummary(s string) bool {
 }
 
 func (a *App) cmdStart(ctx context.Context, msg *tgbotapi.Message) {
-	u, _ := a.Store.GetUser(ctx, msg.From.ID)
-	server := strings.TrimSpace(u.ServerBaseURL)
-	if server == "" {
-		server = "(не задан)"
-	}
+	creds, _ := a.credentials(ctx, msg.From.ID)
 	text := "Привет! Я сохраняю сообщения в Karakeep по API key.\n\n" +
-		"Текущий сервер: " + server + "\n\n" +
+		"Текущий сервер: " + formatServer(creds) + "\n\n" +
 		"Сначала настрой:\n" +
 		"/server https://<ваш_karakeep>\n" +
 		"/key <API_KEY>\n\n" +
@@ -532,64 +832,177 @@ func (a *App) cmdHelp(ctx context.Context, msg *tgbotapi.Message) {
 	text := "Команды:\n" +
 		"/server — показать текущий сервер\n" +
 		"/server <url> — установить сервер (только https)\n" +
-		"/key — проверить, задан ли API key\n" +
+		"/key — проверить API key и задать новый ответом (сообщение с ключом удаляется)\n" +
 		"/key <token> — установить API key\n" +
+		"/list — список по умолчанию и все списки\n" +
+		"/list <название> — сохранять в этот список (off — только Inbox)\n" +
+		"#list:<название> в сообщении — список для этого сообщения\n" +
+		"/search <запрос> — поиск по закладкам\n" +
+		"/undo — удалить последнюю сохранённую закладку\n" +
+		"/recent [n] — последние сохранения\n" +
+		"/links split|note — несколько ссылок: отдельные закладки или одна заметка\n" +
+		"/bindchannel @канал — сохранять все посты канала (без аргументов — привязанные каналы)\n" +
+		"/unbindchannel @канал — отвязать канал\n" +
+		"/workspace — общее пространство: один Karakeep на команду\n" +
+		"/join <код> — присоединиться к пространству по приглашению\n" +
+		"/profile — профили: несколько серверов Karakeep (add/remove)\n" +
+		"/use <профиль> — активный профиль; @профиль в сообщении — разово\n" +
 		"/status — статус\n" +
 		"/help — справка"
+	if a.Access.isAdmin(msg.From.ID) {
+		text += "\n\nАдминистратор:\n" +
+			"/approve <id|@username> — открыть доступ (invite — код приглашения)\n" +
+			"/revoke <id|@username> — закрыть доступ\n" +
+			"/users — кто имеет доступ"
+	}
 	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, text))
 }
 
 func (a *App) cmdServer(ctx context.Context, msg *tgbotapi.Message) {
 	arg := strings.TrimSpace(msg.CommandArguments())
 	if arg == "" {
-		u, err := a.Store.GetUser(ctx, msg.From.ID)
-		if err != nil && err != sql.ErrNoRows {
+		creds, err := a.credentials(ctx, msg.From.ID)
+		if err != nil {
 			_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Ошибка чтения настроек."))
 			return
 		}
-		server := strings.TrimSpace(u.ServerBaseURL)
-		if server == "" {
-			server = "(не задан)"
-		}
-		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Текущий сервер: "+server+"\nУстановить: /server https://<host>"))
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Текущий сервер: "+formatServer(creds)+"\nУстановить: /server https://<host>"))
 		return
 	}
 
 	norm, err := security.ValidateServerBaseURL(arg)
 	if err != nil {
-		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Некорректный / небезопасный URL. Разрешён только публичный https. Пример: /server https://karakeep.example.com"))
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Некорректный / небезопасный URL ("+err.Error()+"). Разрешён только публичный https, если администратор бота не разрешил другое. Пример: /server https://karakeep.example.com"))
+		return
+	}
+
+	ws, m, inWorkspace, err := a.Store.GetUserWorkspace(ctx, msg.From.ID)
+	if err != nil {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Ошибка чтения настроек."))
+		return
+	}
+	if inWorkspace && !m.CanManage() {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Вы в пространстве «"+ws.Name+"»: сервер меняет его владелец или админ."))
+		return
+	}
+	creds, err := a.credentials(ctx, msg.From.ID)
+	if err != nil {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Ошибка чтения настроек."))
+		return
+	}
+	// The server is saved even if the current key doesn't fit it: moving to a new server
+	// means setting the server first and then its key.
+	report, rejected := a.verifyCredentials(ctx, norm, creds.APIKey)
+	if rejected {
+		report += " Пришлите ключ от этого сервера: /key <API_KEY>"
+	}
+
+	if inWorkspace {
+		if err := a.Store.SetWorkspaceServerBaseURL(ctx, ws.ID, norm); err != nil {
+			_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Не удалось сохранить сервер."))
+			return
+		}
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "✅ Сервер пространства «"+ws.Name+"» сохранён: "+norm+"\n"+report))
 		return
 	}
 
-	if err := a.Store.SetServerBaseURL(ctx, msg.From.ID, norm); err != nil {
+	profile := a.activeProfile(ctx, msg.From.ID)
+	if err := a.Store.SetProfileServerBaseURL(ctx, msg.From.ID, profile, norm); err != nil {
 		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Не удалось сохранить сервер."))
 		return
 	}
-	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "✅ Сервер сохранён: "+norm))
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "✅ Сервер"+profileSuffix(profile)+" сохранён: "+norm+"\n"+report))
 }
 
 func (a *App) cmdKey(ctx context.Context, msg *tgbotapi.Message) {
 	arg := strings.TrimSpace(msg.CommandArguments())
 	if arg == "" {
-		u, err := a.Store.GetUser(ctx, msg.From.ID)
-		if err != nil && err != sql.ErrNoRows {
-			_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Ошибка чтения настроек."))
-			return
-		}
-		_, ok, _ := a.Store.DecryptAPIKey(u)
-		if ok {
-			_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "API key: задан ✅"))
-		} else {
-			_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "API key: не задан ❌\nУстановить: /key <API_KEY>"))
-		}
+		a.askAPIKey(ctx, msg)
 		return
 	}
+	a.setAPIKey(ctx, msg, arg)
+}
 
-	if err := a.Store.SetAPIKey(ctx, msg.From.ID, arg); err != nil {
-		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Не удалось сохранить API key."))
+// askAPIKey shows whether a key is set and asks for a new one with ForceReply, so it can be
+// pasted as a plain reply that is deleted right after saving.
+func (a *App) askAPIKey(ctx context.Context, msg *tgbotapi.Message) {
+	creds, err := a.credentials(ctx, msg.From.ID)
+	if err != nil {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Ошибка чтения настроек."))
+		return
+	}
+	var text string
+	switch {
+	case creds.APIKey != "" && creds.Workspace != nil:
+		text = "API key: задан ✅ (пространство «" + creds.Workspace.Name + "»)"
+	case creds.APIKey != "":
+		text = "API key: задан ✅" + profileSuffix(creds.Profile)
+	default:
+		text = "API key: не задан ❌"
+	}
+	if _, m, inWorkspace, err := a.Store.GetUserWorkspace(ctx, msg.From.ID); err == nil && inWorkspace && !m.CanManage() {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, text))
 		return
 	}
-	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "✅ API key сохранён."))
+	text += "\n\nЧтобы задать ключ, пришлите его ответом на это сообщение — я сохраню его и удалю из чата."
+	if err := a.askForInput(ctx, msg.Chat.ID, msg.From.ID, inputAPIKey, "", text); err != nil {
+		a.logger().Warn("ask api key failed", "err", err)
+	}
+}
+
+// setAPIKey stores apiKey sent in msg (a /key command or an answer to askAPIKey) and then deletes msg,
+// whether the key was accepted or not: it is a secret either way.
+func (a *App) setAPIKey(ctx context.Context, msg *tgbotapi.Message, apiKey string) {
+	text := a.storeAPIKey(ctx, msg, apiKey)
+	if !a.deleteSecretMessage(msg) {
+		text += "\n\n⚠️ Не удалось удалить сообщение с ключом — удалите его из чата вручную."
+	}
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, text))
+}
+
+// storeAPIKey checks and saves apiKey for the user's workspace or active profile; returns the reply text.
+func (a *App) storeAPIKey(ctx context.Context, msg *tgbotapi.Message, apiKey string) string {
+	if apiKey == "" || strings.ContainsAny(apiKey, " \n\t") {
+		return "Ключ не распознан: пришлите его одной строкой."
+	}
+	ws, m, inWorkspace, err := a.Store.GetUserWorkspace(ctx, msg.From.ID)
+	if err != nil {
+		return "Ошибка чтения настроек."
+	}
+	if inWorkspace && !m.CanManage() {
+		return "Вы в пространстве «" + ws.Name + "»: API key меняет его владелец или админ."
+	}
+	creds, err := a.credentials(ctx, msg.From.ID)
+	if err != nil {
+		return "Ошибка чтения настроек."
+	}
+	report, rejected := a.verifyCredentials(ctx, strings.TrimSpace(creds.ServerBaseURL), apiKey)
+	if rejected {
+		return report + " Ключ не сохранён: проверьте, что он от " + creds.ServerBaseURL + " и не отозван."
+	}
+
+	if inWorkspace {
+		if err := a.Store.SetWorkspaceAPIKey(ctx, ws.ID, apiKey); err != nil {
+			return "Не удалось сохранить API key."
+		}
+		return "✅ API key пространства «" + ws.Name + "» сохранён, он уже действует для всех участников.\n" + report
+	}
+
+	profile := a.activeProfile(ctx, msg.From.ID)
+	if err := a.Store.SetProfileAPIKey(ctx, msg.From.ID, profile, apiKey); err != nil {
+		return "Не удалось сохранить API key."
+	}
+	return "✅ API key" + profileSuffix(profile) + " сохранён.\n" + report
+}
+
+// deleteSecretMessage removes a message carrying an API key from the chat. It fails e.g. after 48 hours
+// or in a group where the bot is not an admin; the caller then asks the user to delete it.
+func (a *App) deleteSecretMessage(msg *tgbotapi.Message) bool {
+	if _, err := a.Bot.Request(tgbotapi.NewDeleteMessage(msg.Chat.ID, msg.MessageID)); err != nil {
+		a.logger().Warn("delete api key message failed", "chat_id", msg.Chat.ID, "err", err)
+		return false
+	}
+	return true
 }
 
 func (a *App) cmdStatus(ctx context.Context, msg *tgbotapi.Message) {
@@ -598,15 +1011,14 @@ func (a *App) cmdStatus(ctx context.Context, msg *tgbotapi.Message) {
 		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Ошибка чтения настроек."))
 		return
 	}
-
-	server := strings.TrimSpace(u.ServerBaseURL)
-	if server == "" {
-		server = "(не задан)"
+	creds, err := a.credentials(ctx, msg.From.ID)
+	if err != nil {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Ошибка чтения настроек."))
+		return
 	}
 
-	_, keySet, _ := a.Store.DecryptAPIKey(u)
 	keyStr := "нет"
-	if keySet {
+	if creds.APIKey != "" {
 		keyStr = "да"
 	}
 
@@ -615,7 +1027,18 @@ func (a *App) cmdStatus(ctx context.Context, msg *tgbotapi.Message) {
 		last = u.LastSuccessAt.Time.In(time.Local).Format(time.RFC3339)
 	}
 
-	text := fmt.Sprintf("Сервер: %s\nКлюч: %s\nПоследняя успешная запись: %s\nВерсия: %s", server, keyStr, last, strings.TrimSpace(a.Version))
+	text := fmt.Sprintf("Сервер: %s\nКлюч: %s\nПоследняя успешная запись: %s\nВерсия: %s", formatServer(creds), keyStr, last, strings.TrimSpace(a.Version))
 	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, text))
 }
 
+// formatServer renders the server for settings replies, noting when it comes from a workspace.
+func formatServer(creds storage.Credentials) string {
+	server := strings.TrimSpace(creds.ServerBaseURL)
+	if server == "" {
+		server = "(не задан)"
+	}
+	if creds.Workspace != nil {
+		server += " (пространство «" + creds.Workspace.Name + "»)"
+	}
+	return server + profileSuffix(creds.Profile)
+}
@@ -0,0 +1,159 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"karakeep-telegram-bot/internal/security"
+	"karakeep-telegram-bot/internal/storage"
+)
+
+// fakeServers records what the bot asks of Telegram and Karakeep.
+type fakeServers struct {
+	mu       sync.Mutex
+	telegram []string // method names
+	acks     []string // texts of editMessageText calls
+	karakeep []string // "METHOD /path" below the API prefix
+}
+
+func (f *fakeServers) record(list *[]string, s string) {
+	f.mu.Lock()
+	*list = append(*list, s)
+	f.mu.Unlock()
+}
+
+// newTestApp wires an App to fake Telegram and Karakeep servers and a fresh store
+// with user 1 configured for that Karakeep.
+func newTestApp(t *testing.T, karakeep http.HandlerFunc) (*App, *fakeServers) {
+	t.Helper()
+	f := &fakeServers{}
+
+	tg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		f.record(&f.telegram, method)
+		var result any = map[string]any{"message_id": 1, "date": 0, "chat": map[string]any{"id": 1, "type": "private"}}
+		switch method {
+		case "getMe":
+			result = map[string]any{"id": 42, "is_bot": true, "first_name": "bot", "username": "test_bot"}
+		case "editMessageText":
+			f.record(&f.acks, r.FormValue("text"))
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+	}))
+	t.Cleanup(tg.Close)
+	kk := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.record(&f.karakeep, r.Method+" "+strings.TrimPrefix(r.URL.Path, "/api/v1"))
+		karakeep(w, r)
+	}))
+	t.Cleanup(kk.Close)
+
+	security.SetPolicy(security.Policy{HTTPHosts: []string{"127.0.0.1"}, PrivateHosts: []string{"127.0.0.1"}})
+	t.Cleanup(func() { security.SetPolicy(security.Policy{}) })
+
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint("token", tg.URL+"/bot%s/%s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	store, err := storage.Open(ctx, filepath.Join(t.TempDir(), "bot.sqlite"), storage.MasterKeys{Current: "test master key", CurrentVersion: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	if err := store.UpsertUser(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetServerBaseURL(ctx, 1, kk.URL); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetAPIKey(ctx, 1, "api key"); err != nil {
+		t.Fatal(err)
+	}
+	return &App{Bot: bot, Store: store}, f
+}
+
+// A job resumed at JobStageSetUp only enriches its bookmark: nothing is created or attached again,
+// and the final ack still carries the tags and list lines from the first run.
+func TestResumeAtStageSetUp(t *testing.T) {
+	ctx := context.Background()
+	a, f := newTestApp(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/api/v1/bookmarks/bm1" {
+			fmt.Fprint(w, `{"id":"bm1","summary":"Short summary.","content":{"type":"text","text":"note"}}`)
+			return
+		}
+		fmt.Fprint(w, `{}`)
+	})
+
+	msg := &tgbotapi.Message{
+		MessageID: 7,
+		From:      &tgbotapi.User{ID: 1},
+		Chat:      &tgbotapi.Chat{ID: 1, Type: "private"},
+		Caption:   "A note about Go #go #list:Reading",
+		CaptionEntities: []tgbotapi.MessageEntity{
+			{Type: "hashtag", Offset: 16, Length: 3},
+			{Type: "hashtag", Offset: 20, Length: 5},
+		},
+		Photo: []tgbotapi.PhotoSize{{FileID: "photo", FileUniqueID: "photo", Width: 10, Height: 10, FileSize: 100}},
+	}
+	if err := a.enqueueSave(ctx, jobKindMessage, []*tgbotapi.Message{msg}); err != nil {
+		t.Fatal(err)
+	}
+	job, ok, err := a.Store.ClaimNextJob(ctx)
+	if err != nil || !ok {
+		t.Fatalf("ClaimNextJob = %v, %v", ok, err)
+	}
+	// The first run got as far as JobStageSetUp before the process died.
+	footer := "🏷 #go\n📁 Список: Reading"
+	for _, err := range []error{
+		a.Store.SetJobAckMessageID(ctx, job.ID, 100),
+		a.Store.SetJobBookmarkID(ctx, job.ID, "bm1"),
+		a.Store.SetJobAssetsAttached(ctx, job.ID, 1),
+		a.Store.SetJobFooter(ctx, job.ID, footer),
+		a.Store.SetJobStage(ctx, job.ID, storage.JobStageSetUp),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := a.Store.RequeueRunningJobs(ctx); err != nil {
+		t.Fatal(err)
+	}
+	job, ok, err = a.Store.ClaimNextJob(ctx)
+	if err != nil || !ok {
+		t.Fatalf("ClaimNextJob after requeue = %v, %v", ok, err)
+	}
+
+	a.runJob(ctx, job)
+
+	if unfinished, err := a.Store.HasUnfinishedJob(ctx, 1, 7, jobKindMessage); err != nil || unfinished {
+		t.Errorf("job still unfinished (%v, %v)", unfinished, err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, call := range f.karakeep {
+		if call != "POST /bookmarks/bm1/summarize" && call != "GET /bookmarks/bm1" {
+			t.Errorf("unexpected Karakeep call on resume: %s", call)
+		}
+	}
+	for _, method := range f.telegram {
+		if method != "getMe" && method != "editMessageText" {
+			t.Errorf("unexpected Telegram call on resume: %s", method)
+		}
+	}
+	if len(f.acks) == 0 {
+		t.Fatal("ack was never edited")
+	}
+	last := f.acks[len(f.acks)-1]
+	if !strings.Contains(last, "Short summary.") || !strings.HasSuffix(last, "\n\n"+footer) {
+		t.Errorf("final ack = %q, want the summary and the footer", last)
+	}
+}
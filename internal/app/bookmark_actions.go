@@ -0,0 +1,221 @@
+package app
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"karakeep-telegram-bot/internal/classifier"
+	"karakeep-telegram-bot/internal/karakeep"
+	"karakeep-telegram-bot/internal/storage"
+)
+
+// Bookmark buttons carry "bm:<op>:<bookmarkID>". IDs are validated before use: callback data comes from
+// the client and must not be trusted to be a well-formed id (it ends up in the request path).
+const (
+	bmTag           = "tag"
+	bmArchive       = "arc"
+	bmFavourite     = "fav"
+	bmSummarize     = "sum"
+	bmDelete        = "del"
+	bmDeleteConfirm = "delok"
+	bmKeyboard      = "kb"
+	bmUndo          = "undo"
+)
+
+var bookmarkIDRE = regexp.MustCompile(`^[A-Za-z0-9_-]{1,48}$`)
+
+func bookmarkCallback(op string, bookmarkID string) string {
+	return cbBookmark + ":" + op + ":" + bookmarkID
+}
+
+func bookmarkKeyboard(bookmarkID string) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🏷 Теги", bookmarkCallback(bmTag, bookmarkID)),
+			tgbotapi.NewInlineKeyboardButtonData("⭐ Избранное", bookmarkCallback(bmFavourite, bookmarkID)),
+			tgbotapi.NewInlineKeyboardButtonData("📦 Архив", bookmarkCallback(bmArchive, bookmarkID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔄 Саммари", bookmarkCallback(bmSummarize, bookmarkID)),
+			tgbotapi.NewInlineKeyboardButtonData("🗑 Удалить", bookmarkCallback(bmDelete, bookmarkID)),
+			tgbotapi.NewInlineKeyboardButtonData("↩️ Отменить", bookmarkCallback(bmUndo, bookmarkID)),
+		),
+	)
+}
+
+func confirmDeleteKeyboard(bookmarkID string) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🗑 Да, удалить", bookmarkCallback(bmDeleteConfirm, bookmarkID)),
+			tgbotapi.NewInlineKeyboardButtonData("Отмена", bookmarkCallback(bmKeyboard, bookmarkID)),
+		),
+	)
+}
+
+func (a *App) cbBookmark(ctx context.Context, cq *tgbotapi.CallbackQuery, arg string) {
+	log := a.logger()
+	op, bookmarkID, _ := strings.Cut(arg, ":")
+	if !bookmarkIDRE.MatchString(bookmarkID) || cq.Message == nil {
+		a.answerCallback(cq, "Кнопка устарела.")
+		return
+	}
+	chatID, messageID := cq.Message.Chat.ID, cq.Message.MessageID
+
+	// Keyboard-only transitions don't need Karakeep.
+	switch op {
+	case bmDelete:
+		a.answerCallback(cq, "")
+		a.editMarkup(chatID, messageID, confirmDeleteKeyboard(bookmarkID))
+		return
+	case bmKeyboard:
+		a.answerCallback(cq, "")
+		a.editMarkup(chatID, messageID, bookmarkKeyboard(bookmarkID))
+		return
+	case bmTag:
+		a.answerCallback(cq, "")
+		if err := a.askForInput(ctx, chatID, cq.From.ID, inputTags, bookmarkID, "🏷 Пришлите теги для закладки ответом на это сообщение (через пробел или запятую)."); err != nil {
+			log.Warn("ask for tags failed", "err", err)
+		}
+		return
+	}
+
+	client, _, err := a.bookmarkClient(ctx, cq.From.ID, bookmarkID)
+	if err != nil {
+		a.answerCallback(cq, "❌ Не настроено. /server и /key")
+		return
+	}
+
+	switch op {
+	case bmArchive, bmFavourite:
+		b, st, err := client.GetBookmark(ctx, bookmarkID)
+		if err != nil {
+			log.Warn("karakeep get bookmark failed", "status", st, "err", err)
+			a.answerCallback(cq, callbackKarakeepError(st))
+			return
+		}
+		field, on, onText, offText := "archived", !b.Archived, "📦 В архиве", "📦 Убрано из архива"
+		if op == bmFavourite {
+			field, on, onText, offText = "favourited", !b.Favourited, "⭐ В избранном", "⭐ Убрано из избранного"
+		}
+		if _, st, err := client.UpdateBookmark(ctx, bookmarkID, map[string]any{field: on}); err != nil {
+			log.Warn("karakeep update bookmark failed", "status", st, "err", err)
+			a.answerCallback(cq, callbackKarakeepError(st))
+			return
+		}
+		if on {
+			a.answerCallback(cq, onText)
+		} else {
+			a.answerCallback(cq, offText)
+		}
+	case bmSummarize:
+		a.answerCallback(cq, "🔄 Пересчитываю саммари…")
+		if _, st, err := client.Summarize(ctx, bookmarkID); err != nil {
+			log.Warn("karakeep summarize failed", "status", st, "err", err)
+			_, _ = a.Bot.Send(tgbotapi.NewMessage(chatID, userFacingKarakeepError(st, err)))
+			return
+		}
+		got, st, err := client.GetBookmark(ctx, bookmarkID)
+		if err != nil {
+			log.Warn("karakeep get bookmark failed", "status", st, "err", err)
+			return
+		}
+		kb := bookmarkKeyboard(bookmarkID)
+		_ = a.editAckMarkup(chatID, messageID, formatFinalMessage(kindOfBookmark(got), got), &kb)
+	case bmDeleteConfirm:
+		if st, err := client.DeleteBookmark(ctx, bookmarkID); err != nil && !karakeep.IsNotFound(err) {
+			log.Warn("karakeep delete bookmark failed", "status", st, "err", err)
+			a.answerCallback(cq, callbackKarakeepError(st))
+			return
+		}
+		if err := a.Store.SetSaveStatusByBookmark(ctx, cq.From.ID, bookmarkID, storage.SaveDeleted); err != nil {
+			log.Warn("mark save deleted failed", "err", err)
+		}
+		a.answerCallback(cq, "🗑 Удалено")
+		_ = a.editAck(chatID, messageID, "🗑 Закладка удалена (id="+bookmarkID+").")
+	case bmUndo:
+		text, err := a.undoBookmark(ctx, client, cq.From.ID, bookmarkID)
+		if err != nil {
+			a.answerCallback(cq, "❌ Не удалось отменить.")
+			_, _ = a.Bot.Send(tgbotapi.NewMessage(chatID, text))
+			return
+		}
+		a.answerCallback(cq, "↩️ Отменено")
+		_ = a.editAck(chatID, messageID, text)
+	default:
+		a.answerCallback(cq, "Кнопка устарела.")
+	}
+}
+
+func (a *App) answerTagsInput(ctx context.Context, msg *tgbotapi.Message, bookmarkID string) {
+	if !bookmarkIDRE.MatchString(bookmarkID) {
+		return
+	}
+	tags := parseTagList(msg.Text)
+	if len(tags) == 0 {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Теги не распознаны."))
+		return
+	}
+	client, _, err := a.bookmarkClient(ctx, msg.From.ID, bookmarkID)
+	if err != nil {
+		a.sendClientError(msg.Chat.ID, err)
+		return
+	}
+	if st, err := client.AttachTags(ctx, bookmarkID, tags); err != nil {
+		a.logger().Warn("karakeep attach tags failed", "status", st, "err", err)
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, userFacingKarakeepError(st, err)))
+		return
+	}
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "🏷 Добавлены теги: #"+strings.Join(tags, " #")))
+}
+
+// parseTagList splits "go, #golang readlater" into tag names.
+func parseTagList(s string) []string {
+	var out []string
+	seen := map[string]struct{}{}
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' || r == '\t' }) {
+		tag := strings.TrimPrefix(strings.TrimSpace(f), "#")
+		if tag == "" {
+			continue
+		}
+		if _, ok := seen[strings.ToLower(tag)]; ok {
+			continue
+		}
+		seen[strings.ToLower(tag)] = struct{}{}
+		out = append(out, tag)
+	}
+	return out
+}
+
+func kindOfBookmark(b karakeep.Bookmark) classifier.Kind {
+	switch b.Content.Type {
+	case "link":
+		return classifier.KindBookmark
+	case "asset":
+		return classifier.KindFile
+	case "text":
+		return classifier.KindNote
+	}
+	return ""
+}
+
+// callbackKarakeepError is a short variant of userFacingKarakeepError for callback toasts (200 chars max).
+func callbackKarakeepError(status int) string {
+	switch {
+	case status == 404:
+		return "❌ Закладка не найдена."
+	case status == 401 || status == 403:
+		return "❌ Karakeep отклонил ключ (/key)."
+	case status == 0:
+		return "❌ Karakeep недоступен."
+	}
+	return "❌ Ошибка Karakeep."
+}
+
+func (a *App) editMarkup(chatID int64, messageID int, markup tgbotapi.InlineKeyboardMarkup) {
+	if _, err := a.Bot.Send(tgbotapi.NewEditMessageReplyMarkup(chatID, messageID, markup)); err != nil {
+		a.logger().Warn("failed to edit keyboard", "chat_id", chatID, "message_id", messageID, "err", err)
+	}
+}
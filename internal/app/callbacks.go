@@ -0,0 +1,50 @@
+package app
+
+import (
+	"context"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Callback data is "<action>:<arg>" and must fit into Telegram's 64 bytes.
+const (
+	cbSearch   = "search"
+	cbBookmark = "bm"
+)
+
+func (a *App) handleCallback(ctx context.Context, cq *tgbotapi.CallbackQuery) {
+	if cq == nil || cq.From == nil {
+		return
+	}
+	if !callbackFromOwner(cq) {
+		a.answerCallback(cq, "Это не ваша закладка.")
+		return
+	}
+	action, arg, _ := strings.Cut(cq.Data, ":")
+	switch action {
+	case cbSearch:
+		a.cbSearch(ctx, cq, arg)
+	case cbBookmark:
+		a.cbBookmark(ctx, cq, arg)
+	default:
+		a.answerCallback(cq, "Кнопка устарела.")
+	}
+}
+
+func (a *App) answerCallback(cq *tgbotapi.CallbackQuery, text string) {
+	if _, err := a.Bot.Request(tgbotapi.NewCallback(cq.ID, text)); err != nil {
+		a.logger().Warn("answer callback failed", "err", err)
+	}
+}
+
+// callbackFromOwner reports whether cq was pressed by the user whose message the bot answered.
+// In groups acks reply to the saved message, so its sender owns the buttons; private chats have one user anyway.
+func callbackFromOwner(cq *tgbotapi.CallbackQuery) bool {
+	m := cq.Message
+	if m == nil || m.Chat == nil || m.Chat.IsPrivate() {
+		return true
+	}
+	orig := m.ReplyToMessage
+	return orig != nil && orig.From != nil && orig.From.ID == cq.From.ID
+}
@@ -0,0 +1,346 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"karakeep-telegram-bot/internal/classifier"
+	"karakeep-telegram-bot/internal/karakeep"
+	"karakeep-telegram-bot/internal/storage"
+)
+
+// kindChannel marks saves of posts read from a bound channel.
+const kindChannel = "channel"
+
+// handleChannelPost queues a post of a bound channel for saving; posts of other channels are ignored.
+func (a *App) handleChannelPost(ctx context.Context, msg *tgbotapi.Message) {
+	if msg == nil || msg.Chat == nil {
+		return
+	}
+	if msg.MediaGroupID != "" && a.MediaGroups != nil {
+		a.MediaGroups.Collect(msg)
+		return
+	}
+	a.enqueueChannelPost(ctx, []*tgbotapi.Message{msg})
+}
+
+// enqueueChannelPost persists a save job on behalf of the user who bound the channel.
+// Channel posts have no sender, so failures are reported to the owner in private instead of in the channel.
+func (a *App) enqueueChannelPost(ctx context.Context, msgs []*tgbotapi.Message) {
+	chatID := msgs[0].Chat.ID
+	b, ok, err := a.Store.GetChannelBinding(ctx, chatID)
+	if err != nil {
+		a.logger().Warn("get channel binding failed", "chat_id", chatID, "err", err)
+		return
+	}
+	if !ok {
+		return
+	}
+	if !a.allowed(ctx, b.TelegramUserID, "") {
+		return
+	}
+	if err := a.enqueueJob(ctx, jobKindChannel, b.TelegramUserID, msgs); err != nil {
+		a.logger().Warn("enqueue channel post failed", "chat_id", chatID, "err", err)
+		a.notifyChannelOwner(b, "❌ Не удалось поставить пост в очередь.")
+	}
+}
+
+// processChannelPost saves a channel post into the Karakeep of the channel's owner.
+// There is no ack to edit: success is silent, errors go to the owner in private.
+func (a *App) processChannelPost(ctx context.Context, job *storage.Job, msg *tgbotapi.Message, batch []*tgbotapi.Message) error {
+	log := a.logger()
+	if msg == nil || msg.Chat == nil {
+		return errors.New("channel post without chat")
+	}
+	persistCtx := context.WithoutCancel(ctx)
+
+	b, ok, err := a.Store.GetChannelBinding(ctx, msg.Chat.ID)
+	if err != nil {
+		return retryable(fmt.Errorf("get channel binding: %w", err))
+	}
+	if !ok || b.TelegramUserID != job.TelegramUserID {
+		// Unbound (or taken over) while the job was queued.
+		return nil
+	}
+	if job.Stage >= storage.JobStageSetUp {
+		return nil
+	}
+
+	u, err := a.Store.GetUser(ctx, b.TelegramUserID)
+	if err != nil {
+		return retryable(fmt.Errorf("get user: %w", err))
+	}
+	creds, err := a.Store.ResolveCredentials(ctx, u, "")
+	if err != nil {
+		return err
+	}
+	if !creds.Configured() {
+		a.notifyChannelOwner(b, "❌ Не удалось сохранить пост: Karakeep не настроен. Проверьте /server и /key.")
+		return errNotConfigured
+	}
+	if err := a.throttleServer(creds.ServerBaseURL); err != nil {
+		return err
+	}
+	client, err := karakeep.NewClient(karakeep.ClientOpts{
+		BaseURL: creds.ServerBaseURL,
+		APIKey:  creds.APIKey,
+		Timeout: 60 * time.Second,
+	})
+	if err != nil {
+		a.notifyChannelOwner(b, "❌ Ошибка конфигурации Karakeep: "+err.Error())
+		return err
+	}
+	if b.ListID != "" {
+		u.DefaultListID, u.DefaultListName = b.ListID, b.ListName
+	}
+
+	res := classifier.ClassifyMessageWithOptions(msg, classifier.Options{StripHashtags: a.StripHashtags})
+	res.Tags = mergeTags(res.Tags, b.Tags)
+	attachments := ExtractAttachments(batch)
+	log.Info("processing channel post",
+		"job_id", job.ID,
+		"attempt", job.Attempts,
+		"user_id", b.TelegramUserID,
+		"chat_id", msg.Chat.ID,
+		"message_id", msg.MessageID,
+		"kind", res.Kind,
+		"attachments_count", len(attachments),
+		"tags_count", len(res.Tags),
+	)
+	bm := karakeep.Bookmark{ID: job.BookmarkID}
+	if job.BookmarkID == "" {
+		a.recordSave(persistCtx, job, kindChannel, storage.SavePending, "")
+		var status int
+		bm, status, err = createBookmark(ctx, client, msg, res)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Warn("karakeep create failed", "status", status, "err", err)
+			if transientStatus(status) {
+				return retryable(err)
+			}
+			a.notifyChannelOwner(b, userFacingKarakeepError(status, err))
+			return err
+		}
+		// Remember the bookmark right away: a retry after a failed upload must not create a duplicate.
+		if bm.ID != "" {
+			if err := a.Store.SetJobBookmarkID(persistCtx, job.ID, bm.ID); err != nil {
+				log.Warn("persist job bookmark id failed", "job_id", job.ID, "err", err)
+			}
+			job.BookmarkID = bm.ID
+		}
+	}
+	if err := a.attachAssets(ctx, client, job, msg.Chat.ID, 0, bm.ID, attachments); err != nil {
+		return err
+	}
+	if bm.ID == "" {
+		return nil
+	}
+	if len(res.Tags) > 0 {
+		if st, err := client.AttachTags(ctx, bm.ID, res.Tags); err != nil {
+			log.Warn("karakeep attach tags failed", "status", st, "err", err)
+		}
+	}
+	a.addToTargetList(ctx, client, u, res.ListName, bm.ID)
+
+	if err := a.Store.SetJobStage(persistCtx, job.ID, storage.JobStageSetUp); err != nil {
+		log.Warn("persist job stage failed", "job_id", job.ID, "err", err)
+	}
+	job.Stage = storage.JobStageSetUp
+	a.recordSave(persistCtx, job, kindChannel, storage.SaveSaved, "")
+	log.Info("karakeep created from channel post", "bookmark_id", bm.ID, "chat_id", msg.Chat.ID)
+	return nil
+}
+
+func (a *App) notifyChannelOwner(b storage.ChannelBinding, text string) {
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(b.TelegramUserID, "Канал «"+b.Title+"»: "+text))
+}
+
+// mergeTags appends extra to tags, skipping case-insensitive duplicates.
+func mergeTags(tags []string, extra []string) []string {
+	seen := make(map[string]struct{}, len(tags)+len(extra))
+	var out []string
+	for _, t := range append(append([]string(nil), tags...), extra...) {
+		key := strings.ToLower(t)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		out = append(out, t)
+	}
+	return out
+}
+
+const bindChannelUsage = "Использование:\n" +
+	"/bindchannel @канал [#list:<название>] [#тег …] — сохранять все новые посты канала в ваш Karakeep\n" +
+	"/unbindchannel @канал — отвязать\n\n" +
+	"Вместо @канал можно указать числовой id (-100…). Бот должен быть админом канала, вы — тоже."
+
+func (a *App) cmdBindChannel(ctx context.Context, msg *tgbotapi.Message) {
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) == 0 {
+		a.sendChannelBindings(ctx, msg)
+		return
+	}
+	listName, rest := classifier.ExtractListDirective(strings.Join(args[1:], " "))
+	var tags []string
+	for _, f := range strings.Fields(rest) {
+		tag := strings.TrimPrefix(f, "#")
+		if !strings.HasPrefix(f, "#") || tag == "" {
+			_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, bindChannelUsage))
+			return
+		}
+		tags = append(tags, tag)
+	}
+
+	ch, err := a.lookupChannel(args[0])
+	if err != nil {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Не нашёл канал "+args[0]+". Добавьте бота в канал админом и попробуйте ещё раз."))
+		return
+	}
+	if !a.isChatAdmin(ch.ID, a.Bot.Self.ID) {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Сначала добавьте бота в канал «"+ch.Title+"» администратором."))
+		return
+	}
+	if !a.isChatAdmin(ch.ID, msg.From.ID) {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Привязать канал может только его администратор."))
+		return
+	}
+
+	client, _, err := a.userClient(ctx, msg.From.ID)
+	if err != nil {
+		a.sendClientError(msg.Chat.ID, err)
+		return
+	}
+	b := storage.ChannelBinding{
+		ChannelID:      ch.ID,
+		Title:          ch.Title,
+		TelegramUserID: msg.From.ID,
+		Tags:           mergeTags(tags, nil),
+	}
+	if listName != "" {
+		l, _, err := resolveList(ctx, client, listName, true)
+		if err != nil {
+			var apiErr *karakeep.APIError
+			if errors.As(err, &apiErr) {
+				_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, userFacingKarakeepError(apiErr.StatusCode, err)))
+				return
+			}
+			_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Не удалось найти или создать список: "+err.Error()))
+			return
+		}
+		b.ListID, b.ListName = l.ID, l.Name
+	}
+
+	if prev, ok, err := a.Store.GetChannelBinding(ctx, ch.ID); err == nil && ok && prev.TelegramUserID != msg.From.ID {
+		a.logger().Info("channel binding taken over", "chat_id", ch.ID, "from_user_id", prev.TelegramUserID, "to_user_id", msg.From.ID)
+		a.notifyChannelOwner(prev, "другой администратор привязал канал к своему Karakeep, посты больше не сохраняются к вам.")
+	}
+	if err := a.Store.SetChannelBinding(ctx, b); err != nil {
+		a.logger().Warn("set channel binding failed", "chat_id", ch.ID, "err", err)
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Не удалось сохранить привязку канала."))
+		return
+	}
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "✅ Новые посты канала будут сохраняться в Karakeep.\n\n"+formatChannelBinding(b)))
+}
+
+func (a *App) cmdUnbindChannel(ctx context.Context, msg *tgbotapi.Message) {
+	arg := strings.TrimSpace(msg.CommandArguments())
+	if arg == "" {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, bindChannelUsage))
+		return
+	}
+	channelID, err := a.channelIDFromRef(ctx, msg.From.ID, arg)
+	if err != nil {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Не нашёл канал "+arg+"."))
+		return
+	}
+	ok, err := a.Store.DeleteChannelBinding(ctx, channelID, msg.From.ID)
+	if err != nil {
+		a.logger().Warn("delete channel binding failed", "chat_id", channelID, "err", err)
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Не удалось отвязать канал."))
+		return
+	}
+	if !ok {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Этот канал не привязан к вам."))
+		return
+	}
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "✅ Канал отвязан."))
+}
+
+func (a *App) sendChannelBindings(ctx context.Context, msg *tgbotapi.Message) {
+	bs, err := a.Store.ChannelBindings(ctx, msg.From.ID)
+	if err != nil {
+		a.logger().Warn("list channel bindings failed", "err", err)
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Ошибка чтения настроек."))
+		return
+	}
+	var sb strings.Builder
+	if len(bs) == 0 {
+		sb.WriteString("Привязанных каналов нет.\n\n")
+	} else {
+		sb.WriteString("Привязанные каналы:\n")
+		for _, b := range bs {
+			sb.WriteString("\n" + formatChannelBinding(b) + "\n")
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString(bindChannelUsage)
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, sb.String()))
+}
+
+// lookupChannel resolves "@username" or a numeric chat id to a channel the bot can see.
+func (a *App) lookupChannel(ref string) (tgbotapi.Chat, error) {
+	cfg := tgbotapi.ChatInfoConfig{}
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		cfg.ChatID = id
+	} else {
+		cfg.SuperGroupUsername = "@" + strings.TrimPrefix(strings.TrimPrefix(ref, "https://t.me/"), "@")
+	}
+	ch, err := a.Bot.GetChat(cfg)
+	if err != nil {
+		return tgbotapi.Chat{}, err
+	}
+	if !ch.IsChannel() {
+		return tgbotapi.Chat{}, fmt.Errorf("chat %d is not a channel", ch.ID)
+	}
+	return ch, nil
+}
+
+// channelIDFromRef finds the channel to unbind; a channel the bot was removed from can only be found by id or title.
+func (a *App) channelIDFromRef(ctx context.Context, telegramUserID int64, ref string) (int64, error) {
+	if id, err := strconv.ParseInt(ref, 10, 64); err == nil {
+		return id, nil
+	}
+	if ch, err := a.lookupChannel(ref); err == nil {
+		return ch.ID, nil
+	}
+	bs, err := a.Store.ChannelBindings(ctx, telegramUserID)
+	if err != nil {
+		return 0, err
+	}
+	for _, b := range bs {
+		if strings.EqualFold(b.Title, ref) {
+			return b.ChannelID, nil
+		}
+	}
+	return 0, fmt.Errorf("channel %q not found", ref)
+}
+
+func formatChannelBinding(b storage.ChannelBinding) string {
+	line := fmt.Sprintf("📣 %s (id=%d)", b.Title, b.ChannelID)
+	if b.ListName != "" {
+		line += "\n📁 Список: " + b.ListName
+	}
+	if len(b.Tags) > 0 {
+		line += "\n🏷 #" + strings.Join(b.Tags, " #")
+	}
+	return line
+}
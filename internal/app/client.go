@@ -0,0 +1,119 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"karakeep-telegram-bot/internal/karakeep"
+	"karakeep-telegram-bot/internal/storage"
+)
+
+var errNotConfigured = errors.New("karakeep server or api key is not configured")
+
+// userClient builds a Karakeep client from the user's stored settings (or their workspace's).
+func (a *App) userClient(ctx context.Context, telegramUserID int64) (*karakeep.Client, storage.User, error) {
+	return a.profileClient(ctx, telegramUserID, "")
+}
+
+// profileClient builds a Karakeep client for one of the user's profiles; an empty profile means
+// whatever new saves use (workspace or active profile).
+func (a *App) profileClient(ctx context.Context, telegramUserID int64, profile string) (*karakeep.Client, storage.User, error) {
+	u, err := a.Store.GetUser(ctx, telegramUserID)
+	if err != nil {
+		return nil, storage.User{}, err
+	}
+	creds, err := a.Store.ResolveCredentials(ctx, u, profile)
+	if err != nil {
+		return nil, u, err
+	}
+	if !creds.Configured() {
+		return nil, u, errNotConfigured
+	}
+	client, err := karakeep.NewClient(karakeep.ClientOpts{
+		BaseURL: creds.ServerBaseURL,
+		APIKey:  creds.APIKey,
+		Timeout: 30 * time.Second,
+	})
+	if err != nil {
+		return nil, u, err
+	}
+	return client, u, nil
+}
+
+// bookmarkClient builds a client for the Karakeep holding bookmarkID: the profile it was saved with,
+// which may differ from the one active now.
+func (a *App) bookmarkClient(ctx context.Context, telegramUserID int64, bookmarkID string) (*karakeep.Client, storage.User, error) {
+	sv, ok, err := a.Store.GetSaveByBookmark(ctx, telegramUserID, bookmarkID)
+	if err != nil {
+		a.logger().Warn("lookup save by bookmark failed", "err", err)
+	}
+	if !ok {
+		return a.userClient(ctx, telegramUserID)
+	}
+	return a.profileClient(ctx, telegramUserID, sv.Profile)
+}
+
+// credentials returns the server and key telegramUserID saves with; unknown users get empty credentials.
+func (a *App) credentials(ctx context.Context, telegramUserID int64) (storage.Credentials, error) {
+	u, err := a.Store.GetUser(ctx, telegramUserID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return storage.Credentials{}, err
+	}
+	u.TelegramUserID = telegramUserID
+	return a.Store.ResolveCredentials(ctx, u, "")
+}
+
+func (a *App) sendClientError(chatID int64, err error) {
+	if errors.Is(err, errNotConfigured) || errors.Is(err, sql.ErrNoRows) {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(chatID, "❌ Не настроено. Сначала: /server https://<host> и /key <API_KEY>"))
+		return
+	}
+	if errors.Is(err, storage.ErrProfileNotFound) {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(chatID, "❌ Профиль закладки удалён. Профили: /profile"))
+		return
+	}
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(chatID, "Ошибка чтения настроек."))
+}
+
+// verifyCredentials checks serverBaseURL + apiKey against Karakeep for /key and /server replies.
+// It returns a line describing the result and whether Karakeep rejected the key (401/403);
+// other failures (server down, no key yet) don't block saving.
+func (a *App) verifyCredentials(ctx context.Context, serverBaseURL, apiKey string) (report string, rejected bool) {
+	switch {
+	case serverBaseURL == "":
+		return "Сервер не задан — ключ проверю после /server.", false
+	case apiKey == "":
+		return "API key не задан — проверю сервер после /key.", false
+	}
+	client, err := karakeep.NewClient(karakeep.ClientOpts{
+		BaseURL: serverBaseURL,
+		APIKey:  apiKey,
+		Timeout: 15 * time.Second,
+	})
+	if err != nil {
+		return "⚠️ Не удалось проверить: " + err.Error(), false
+	}
+	acc, status, err := client.WhoAmI(ctx)
+	if karakeep.IsUnauthorized(err) {
+		return fmt.Sprintf("❌ Karakeep отклонил ключ (%d).", status), true
+	}
+	if err != nil {
+		a.logger().Warn("karakeep credentials check failed", "status", status, "err", err)
+		return "⚠️ Ключ не проверен: " + strings.TrimPrefix(userFacingKarakeepError(status, err), "❌ "), false
+	}
+	version, _, err := client.ServerVersion(ctx)
+	if err != nil || version == "" {
+		version = "неизвестна"
+	}
+	account := firstNonEmptyString(acc.Name, acc.Email, acc.ID)
+	if acc.Name != "" && acc.Email != "" {
+		account += " <" + acc.Email + ">"
+	}
+	return fmt.Sprintf("Аккаунт Karakeep: %s\nAPI: %s, версия сервера: %s", account, client.APIPrefix(), version), false
+}
@@ -0,0 +1,155 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"karakeep-telegram-bot/internal/classifier"
+	"karakeep-telegram-bot/internal/karakeep"
+	"karakeep-telegram-bot/internal/storage"
+)
+
+// editWaitInterval is how long an edit waits before checking again whether the original save is done.
+const editWaitInterval = 15 * time.Second
+
+// handleEditedMessage queues an update of the bookmark created from the original message.
+// Edits go through the job queue too: the original save may still be in flight.
+func (a *App) handleEditedMessage(ctx context.Context, msg *tgbotapi.Message) {
+	if msg == nil || msg.From == nil || msg.Chat == nil || msg.IsCommand() {
+		return
+	}
+	if !msg.Chat.IsPrivate() {
+		// Most group messages were never saved; don't queue a job for every edit in the chat.
+		if _, ok, err := a.Store.GetSaveByMessage(ctx, msg.Chat.ID, msg.MessageID); err != nil || !ok {
+			return
+		}
+	}
+	if a.throttled(msg) {
+		return
+	}
+	if err := a.enqueueSave(ctx, jobKindEdit, []*tgbotapi.Message{msg}); err != nil {
+		a.logger().Warn("enqueue edit failed", "err", err)
+	}
+}
+
+// processEdit re-classifies an edited message and patches the existing bookmark instead of creating a new one.
+func (a *App) processEdit(ctx context.Context, msg *tgbotapi.Message) error {
+	log := a.logger()
+	if msg == nil || msg.From == nil || msg.Chat == nil {
+		return errors.New("message without sender/chat")
+	}
+
+	// The save may still be queued, or postponed by a rate limit, before it records anything.
+	queued, err := a.Store.HasUnfinishedJob(ctx, msg.Chat.ID, msg.MessageID, jobKindMessage, jobKindMediaGroup)
+	if err != nil {
+		return retryable(err)
+	}
+	if queued {
+		return &postponedError{reason: "original save is still queued", after: editWaitInterval}
+	}
+
+	sv, ok, err := a.Store.GetSaveByMessage(ctx, msg.Chat.ID, msg.MessageID)
+	if err != nil {
+		return retryable(err)
+	}
+	if !ok || sv.TelegramUserID != msg.From.ID || sv.Kind == kindReply || sv.Kind == kindLinks {
+		// Never saved (or saved by someone else): nothing to update.
+		// Replies were appended to another bookmark's notes; rewriting those notes would lose them.
+		// Split links fan out into several bookmarks, and an edit can't be mapped back onto them.
+		return nil
+	}
+	switch sv.Status {
+	case storage.SavePending:
+		return retryable(errors.New("original save is still in progress"))
+	case storage.SaveSaved:
+	default:
+		return nil
+	}
+	if sv.BookmarkID == "" {
+		return nil
+	}
+
+	client, _, err := a.profileClient(ctx, msg.From.ID, sv.Profile)
+	if err != nil {
+		return err
+	}
+
+	res := classifier.ClassifyMessageWithOptions(msg, a.classifyOptions(ctx, msg))
+	current, st, err := client.GetBookmark(ctx, sv.BookmarkID)
+	if err != nil {
+		if karakeep.IsNotFound(err) {
+			return nil
+		}
+		log.Warn("karakeep get bookmark failed", "status", st, "err", err)
+		if transientStatus(st) {
+			return retryable(err)
+		}
+		return err
+	}
+
+	patch := editPatch(current, res)
+	if len(patch) > 0 {
+		if _, st, err := client.UpdateBookmark(ctx, sv.BookmarkID, patch); err != nil {
+			log.Warn("karakeep update bookmark failed", "status", st, "err", err)
+			if transientStatus(st) {
+				return retryable(err)
+			}
+			return err
+		}
+	}
+	if len(res.Tags) > 0 {
+		if st, err := client.AttachTags(ctx, sv.BookmarkID, res.Tags); err != nil {
+			log.Warn("karakeep attach tags failed", "status", st, "err", err)
+		}
+	}
+	log.Info("karakeep bookmark updated from edit", "bookmark_id", sv.BookmarkID, "fields", len(patch))
+
+	reply := tgbotapi.NewMessage(msg.Chat.ID, "✏️ Закладка обновлена (id="+sv.BookmarkID+").")
+	reply.ReplyToMessageID = msg.MessageID
+	_, _ = a.Bot.Send(reply)
+	return nil
+}
+
+// editPatch maps the re-classified message onto the fields of the existing bookmark type.
+// A bookmark's type can't change, so a message that became a link keeps being a note and vice versa.
+func editPatch(current karakeep.Bookmark, res classifier.Result) map[string]any {
+	patch := map[string]any{}
+	switch current.Content.Type {
+	case "text":
+		text := strings.TrimSpace(res.Text)
+		if res.Kind == classifier.KindBookmark {
+			text = strings.TrimSpace(firstNonEmptyString(res.Notes, res.URL))
+		}
+		if text != "" && text != strings.TrimSpace(current.Content.Text) {
+			patch["text"] = text
+		}
+	default:
+		// Link bookmarks (and unknown shapes): the message text lives in notes, followed by
+		// the text of replies appended since; only the message's own part is replaced.
+		notes := strings.TrimSpace(res.Notes)
+		if res.Kind == classifier.KindNote {
+			notes = strings.TrimSpace(res.Text)
+		}
+		own, replies := splitReplyNotes(current.Notes)
+		if notes != own {
+			patch["notes"] = joinNotes(notes, replies)
+		}
+		if res.Kind == classifier.KindBookmark && res.URL != "" && current.Link() != "" && res.URL != current.Link() {
+			patch["url"] = res.URL
+		}
+	}
+	return patch
+}
+
+func firstNonEmptyString(vals ...string) string {
+	for _, v := range vals {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,76 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"karakeep-telegram-bot/internal/classifier"
+	"karakeep-telegram-bot/internal/karakeep"
+)
+
+func TestEditPatchKeepsReplyNotes(t *testing.T) {
+	date := int(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC).Unix())
+	replies := joinNotes(replyNote(date, "first reply"), replyNote(date+3600, "second\n\nparagraph"))
+	link := func(notes string) karakeep.Bookmark {
+		return karakeep.Bookmark{Notes: notes, Content: karakeep.BookmarkContent{Type: "link", URL: "https://example.com"}}
+	}
+
+	tests := []struct {
+		name    string
+		current karakeep.Bookmark
+		res     classifier.Result
+		want    any // nil: notes must not be patched
+	}{
+		{
+			name:    "edited text, replies kept",
+			current: link(joinNotes("old text", replies)),
+			res:     classifier.Result{Kind: classifier.KindBookmark, URL: "https://example.com", Notes: "new text"},
+			want:    joinNotes("new text", replies),
+		},
+		{
+			name:    "unchanged text",
+			current: link(joinNotes("old text", replies)),
+			res:     classifier.Result{Kind: classifier.KindBookmark, URL: "https://example.com", Notes: "old text"},
+			want:    nil,
+		},
+		{
+			name:    "text added to a bare link with replies",
+			current: link(replies),
+			res:     classifier.Result{Kind: classifier.KindBookmark, URL: "https://example.com", Notes: "now with text"},
+			want:    joinNotes("now with text", replies),
+		},
+		{
+			name:    "text removed, replies kept",
+			current: link(joinNotes("old text", replies)),
+			res:     classifier.Result{Kind: classifier.KindBookmark, URL: "https://example.com"},
+			want:    replies,
+		},
+		{
+			name:    "no replies",
+			current: link("old text"),
+			res:     classifier.Result{Kind: classifier.KindNote, Text: "new text"},
+			want:    "new text",
+		},
+	}
+	for _, tt := range tests {
+		patch := editPatch(tt.current, tt.res)
+		got, ok := patch["notes"]
+		switch {
+		case tt.want == nil && ok:
+			t.Errorf("%s: notes patched to %q, want no change", tt.name, got)
+		case tt.want != nil && got != tt.want:
+			t.Errorf("%s: notes = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSplitReplyNotes(t *testing.T) {
+	date := int(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC).Unix())
+	own, replies := splitReplyNotes(joinNotes("text [not a stamp]\n\nmore", replyNote(date, "reply")))
+	if own != "text [not a stamp]\n\nmore" || replies != "[2024-05-01 10:00] reply" {
+		t.Errorf("splitReplyNotes = %q, %q", own, replies)
+	}
+	if own, replies := splitReplyNotes("  just text  "); own != "just text" || replies != "" {
+		t.Errorf("splitReplyNotes(no replies) = %q, %q", own, replies)
+	}
+}
@@ -0,0 +1,194 @@
+package app
+
+import (
+	"context"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"karakeep-telegram-bot/internal/classifier"
+	"karakeep-telegram-bot/internal/storage"
+)
+
+// handleGroupMessage is HandleUpdate for groups: only messages addressed to the bot are saved,
+// each under the sender's own Karakeep settings.
+func (a *App) handleGroupMessage(ctx context.Context, msg *tgbotapi.Message) {
+	log := a.logger()
+
+	if a.handlePendingInput(ctx, msg) {
+		return
+	}
+	if msg.IsCommand() {
+		if !a.commandForBot(msg) || a.throttled(msg) {
+			return
+		}
+		switch strings.ToLower(msg.Command()) {
+		case "group":
+			a.cmdGroup(ctx, msg)
+		case "help", "start":
+			a.replyInThread(msg, groupHelpText(a.Bot.Self.UserName))
+		case "key":
+			text := "API key задаётся только в личных сообщениях с ботом."
+			if strings.TrimSpace(msg.CommandArguments()) != "" && !a.deleteSecretMessage(msg) {
+				text += "\n⚠️ Не удалось удалить сообщение с ключом — удалите его и отзовите ключ в Karakeep: его видели участники группы."
+			}
+			a.replyInThread(msg, text)
+		default:
+			a.replyInThread(msg, "Команды настройки доступны только в личных сообщениях с ботом.")
+		}
+		return
+	}
+	// Messages sent on behalf of a channel or an anonymous admin have no personal Karakeep settings.
+	if msg.SenderChat != nil {
+		return
+	}
+
+	cs, err := a.Store.GetChatSettings(ctx, msg.Chat.ID)
+	if err != nil {
+		log.Warn("get chat settings failed", "chat_id", msg.Chat.ID, "err", err)
+		return
+	}
+	if !cs.Enabled {
+		return
+	}
+	// Album parts are collected first: the trigger usually sits in the caption of just one of them.
+	if msg.MediaGroupID != "" && a.MediaGroups != nil {
+		a.MediaGroups.Collect(msg)
+		return
+	}
+	if !a.groupTriggered(cs, msg) || a.throttled(msg) {
+		return
+	}
+
+	if err := a.Store.UpsertUser(ctx, msg.From.ID); err != nil {
+		log.Warn("upsert user failed", "err", err)
+	}
+	if err := a.enqueueSave(ctx, jobKindMessage, []*tgbotapi.Message{msg}); err != nil {
+		log.Warn("enqueue message failed", "err", err)
+		a.reportEnqueueFailure(msg)
+	}
+}
+
+// groupTriggered reports whether a group message asks the bot to save it.
+func (a *App) groupTriggered(cs storage.ChatSettings, msg *tgbotapi.Message) bool {
+	if cs.OnMention && classifier.MentionsUser(msg, a.Bot.Self.UserName) {
+		return true
+	}
+	if cs.OnReply && msg.ReplyToMessage != nil && msg.ReplyToMessage.From != nil && msg.ReplyToMessage.From.ID == a.Bot.Self.ID {
+		return true
+	}
+	return cs.TriggerTag != "" && classifier.HasHashtag(msg, cs.TriggerTag)
+}
+
+// commandForBot filters out "/cmd@otherbot" in groups with several bots.
+func (a *App) commandForBot(msg *tgbotapi.Message) bool {
+	_, at, found := strings.Cut(msg.CommandWithAt(), "@")
+	return !found || strings.EqualFold(at, a.Bot.Self.UserName)
+}
+
+// classifyOptions returns classifier options for msg: the sender's profile names for "@<profile>" prefixes,
+// and in groups the bot mention and trigger tag, which are not content.
+func (a *App) classifyOptions(ctx context.Context, msg *tgbotapi.Message) classifier.Options {
+	opts := classifier.Options{StripHashtags: a.StripHashtags}
+	if msg.From != nil {
+		if u, err := a.Store.GetUser(ctx, msg.From.ID); err == nil {
+			opts.Profiles = u.ProfileNames()
+		}
+	}
+	if msg.Chat == nil || msg.Chat.IsPrivate() {
+		return opts
+	}
+	opts.BotUsername = a.Bot.Self.UserName
+	if cs, err := a.Store.GetChatSettings(ctx, msg.Chat.ID); err == nil {
+		opts.TriggerTag = cs.TriggerTag
+	}
+	return opts
+}
+
+// replyInThread answers msg with a reply, so it's clear in a busy group whom the bot answers.
+func (a *App) replyInThread(msg *tgbotapi.Message, text string) {
+	out := tgbotapi.NewMessage(msg.Chat.ID, text)
+	out.ReplyToMessageID = msg.MessageID
+	out.AllowSendingWithoutReply = true
+	_, _ = a.Bot.Send(out)
+}
+
+func (a *App) isChatAdmin(chatID int64, userID int64) bool {
+	m, err := a.Bot.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chatID, UserID: userID},
+	})
+	if err != nil {
+		a.logger().Warn("get chat member failed", "chat_id", chatID, "err", err)
+		return false
+	}
+	return m.IsCreator() || m.IsAdministrator()
+}
+
+func groupHelpText(botUsername string) string {
+	return "В группе я сохраняю только сообщения, адресованные мне:\n" +
+		"• с упоминанием @" + botUsername + "\n" +
+		"• ответом на моё сообщение\n" +
+		"• с хэштегом-триггером, если он задан (/group tag <хэштег>)\n\n" +
+		"Сохраняю в Karakeep отправителя: сначала настройте бота в личных сообщениях (/server и /key).\n\n" +
+		"Настройки группы (только админы): /group"
+}
+
+func (a *App) cmdGroup(ctx context.Context, msg *tgbotapi.Message) {
+	cs, err := a.Store.GetChatSettings(ctx, msg.Chat.ID)
+	if err != nil {
+		a.replyInThread(msg, "Ошибка чтения настроек группы.")
+		return
+	}
+
+	args := strings.Fields(strings.ToLower(msg.CommandArguments()))
+	if len(args) == 0 {
+		a.replyInThread(msg, formatChatSettings(cs))
+		return
+	}
+	if !a.isChatAdmin(msg.Chat.ID, msg.From.ID) {
+		a.replyInThread(msg, "Менять настройки группы могут только администраторы.")
+		return
+	}
+
+	switch {
+	case len(args) == 1 && (args[0] == "on" || args[0] == "off"):
+		cs.Enabled = args[0] == "on"
+	case len(args) == 2 && args[0] == "mention" && (args[1] == "on" || args[1] == "off"):
+		cs.OnMention = args[1] == "on"
+	case len(args) == 2 && args[0] == "reply" && (args[1] == "on" || args[1] == "off"):
+		cs.OnReply = args[1] == "on"
+	case len(args) == 2 && args[0] == "tag":
+		cs.TriggerTag = strings.TrimPrefix(args[1], "#")
+		if cs.TriggerTag == "off" || cs.TriggerTag == "-" {
+			cs.TriggerTag = ""
+		}
+	default:
+		a.replyInThread(msg, "Использование:\n/group on|off\n/group mention on|off\n/group reply on|off\n/group tag <хэштег>|off")
+		return
+	}
+
+	if err := a.Store.SetChatSettings(ctx, cs); err != nil {
+		a.logger().Warn("set chat settings failed", "chat_id", msg.Chat.ID, "err", err)
+		a.replyInThread(msg, "Не удалось сохранить настройки группы.")
+		return
+	}
+	a.replyInThread(msg, "✅ Сохранено.\n\n"+formatChatSettings(cs))
+}
+
+func formatChatSettings(cs storage.ChatSettings) string {
+	onOff := func(v bool) string {
+		if v {
+			return "вкл"
+		}
+		return "выкл"
+	}
+	tag := "(нет)"
+	if cs.TriggerTag != "" {
+		tag = "#" + cs.TriggerTag
+	}
+	return "Настройки группы:\n" +
+		"Сохранение: " + onOff(cs.Enabled) + "\n" +
+		"По упоминанию: " + onOff(cs.OnMention) + "\n" +
+		"По ответу на моё сообщение: " + onOff(cs.OnReply) + "\n" +
+		"Хэштег-триггер: " + tag
+}
@@ -0,0 +1,54 @@
+package app
+
+import "context"
+
+// defaultInteractiveWorkers caps handlers waiting on Karakeep at once when App.InteractiveWorkers is unset.
+const defaultInteractiveWorkers = 16
+
+// goInteractive runs fn, a handler that waits on Karakeep while the user waits for an answer
+// (search, buttons, /server and /key checks), beside the update worker instead of on it: that worker
+// serves other chats too, and Karakeep may take up to the client timeout. fn gets no ordering
+// against later updates of the chat. goInteractive blocks only while all InteractiveWorkers are busy.
+func (a *App) goInteractive(fn func()) {
+	slots := a.interactiveSlots()
+	a.interactiveWG.Add(1)
+	slots <- struct{}{}
+	go func() {
+		defer a.interactiveWG.Done()
+		defer func() { <-slots }()
+		defer func() {
+			// prevent panics from crashing the bot
+			if r := recover(); r != nil {
+				a.logger().Error("panic in interactive handler", "recover", r)
+			}
+		}()
+		fn()
+	}()
+}
+
+// WaitInteractive waits for handlers started for already handled updates, or until ctx is done.
+// Call it once no more updates are handled.
+func (a *App) WaitInteractive(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		a.interactiveWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (a *App) interactiveSlots() chan struct{} {
+	a.interactiveOnce.Do(func() {
+		n := a.InteractiveWorkers
+		if n <= 0 {
+			n = defaultInteractiveWorkers
+		}
+		a.interactiveCh = make(chan struct{}, n)
+	})
+	return a.interactiveCh
+}
@@ -0,0 +1,37 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestGoInteractive(t *testing.T) {
+	a := &App{InteractiveWorkers: 1}
+	release := make(chan struct{})
+	a.goInteractive(func() { <-release }) // returns without waiting for the handler
+
+	second := make(chan struct{})
+	go func() {
+		a.goInteractive(func() { panic("boom") })
+		close(second)
+	}()
+	select {
+	case <-second:
+		t.Fatal("goInteractive started a handler beyond InteractiveWorkers")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := a.WaitInteractive(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WaitInteractive with a busy handler = %v, want deadline exceeded", err)
+	}
+
+	close(release)
+	<-second
+	if err := a.WaitInteractive(context.Background()); err != nil {
+		t.Fatalf("WaitInteractive: %v", err)
+	}
+}
@@ -0,0 +1,310 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"karakeep-telegram-bot/internal/storage"
+)
+
+const (
+	jobKindMessage    = "message"
+	jobKindMediaGroup = "media_group"
+	jobKindEdit       = "edit"
+	jobKindChannel    = "channel_post"
+)
+
+// jobPayload is what we persist for a save job: the raw Telegram messages, so the job can be replayed after restart.
+type jobPayload struct {
+	Messages []*tgbotapi.Message `json:"messages"`
+}
+
+// retryableError marks failures worth another attempt (network errors, Karakeep 5xx/429, Telegram hiccups).
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// postponedError puts a job back to pending for a while without spending one of its attempts:
+// it isn't failing, it has to wait (a server rate limit, an edit of a message whose save is still queued).
+type postponedError struct {
+	reason string
+	after  time.Duration
+}
+
+func (e *postponedError) Error() string {
+	return fmt.Sprintf("%s, retry in %s", e.reason, e.after.Round(time.Second))
+}
+
+func retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &retryableError{err: err}
+}
+
+func isRetryable(err error) bool {
+	var re *retryableError
+	return errors.As(err, &re)
+}
+
+// transientStatus reports whether a Karakeep HTTP status (0 = transport error) is worth retrying.
+func transientStatus(status int) bool {
+	return status == 0 || status == 429 || status >= 500
+}
+
+func (a *App) enqueueSave(ctx context.Context, kind string, msgs []*tgbotapi.Message) error {
+	if len(msgs) == 0 {
+		return errors.New("no messages to enqueue")
+	}
+	first := msgs[0]
+	if first == nil || first.From == nil || first.Chat == nil {
+		return errors.New("message without sender/chat")
+	}
+	return a.enqueueJob(ctx, kind, first.From.ID, msgs)
+}
+
+// enqueueJob persists msgs as a job run on behalf of telegramUserID (not always the sender: channel posts have none).
+func (a *App) enqueueJob(ctx context.Context, kind string, telegramUserID int64, msgs []*tgbotapi.Message) error {
+	first := msgs[0]
+	payload, err := json.Marshal(jobPayload{Messages: msgs})
+	if err != nil {
+		return fmt.Errorf("marshal job payload: %w", err)
+	}
+	id, err := a.Store.EnqueueJob(ctx, storage.Job{
+		Kind:           kind,
+		TelegramUserID: telegramUserID,
+		ChatID:         first.Chat.ID,
+		MessageID:      first.MessageID,
+		Payload:        string(payload),
+	})
+	if err != nil {
+		return err
+	}
+	a.logger().Info("job enqueued", "job_id", id, "kind", kind, "messages", len(msgs))
+	a.wakeJobWorkers()
+	return nil
+}
+
+// RunJobWorkers drains the jobs table with n workers. Once ctx is cancelled no new jobs are claimed
+// and RunJobWorkers returns when the running ones finish; cancelling abort interrupts them instead,
+// putting them back to pending so the next process picks them up.
+func (a *App) RunJobWorkers(ctx, abort context.Context, n int) {
+	if n <= 0 {
+		n = 1
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		a.pruneJobs(ctx)
+	}()
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.jobWorker(ctx, abort)
+		}()
+	}
+	wg.Wait()
+}
+
+// pruneJobs deletes finished jobs older than JobRetention, at startup and then hourly, until ctx is cancelled.
+func (a *App) pruneJobs(ctx context.Context) {
+	t := time.NewTicker(time.Hour)
+	defer t.Stop()
+	for {
+		n, err := a.Store.PruneJobs(ctx, time.Now().Add(-a.jobRetention()))
+		switch {
+		case err != nil && ctx.Err() == nil:
+			a.logger().Warn("prune jobs failed", "err", err)
+		case n > 0:
+			a.logger().Info("pruned finished jobs", "count", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (a *App) jobWorker(ctx, abort context.Context) {
+	log := a.logger()
+	idle := time.NewTicker(time.Second)
+	defer idle.Stop()
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		job, ok, err := a.Store.ClaimNextJob(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Warn("claim job failed", "err", err)
+		}
+		if ok {
+			a.runJob(abort, job)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.jobsWake():
+		case <-idle.C:
+		}
+	}
+}
+
+func (a *App) runJob(ctx context.Context, job storage.Job) {
+	log := a.logger()
+	// Status bookkeeping must survive shutdown cancellation.
+	persistCtx := context.WithoutCancel(ctx)
+
+	err := a.executeJob(ctx, &job)
+	var postponed *postponedError
+	switch {
+	case ctx.Err() != nil:
+		if rerr := a.Store.ReleaseJob(persistCtx, job.ID); rerr != nil {
+			log.Warn("release job failed", "job_id", job.ID, "err", rerr)
+		}
+		log.Info("job interrupted, will resume", "job_id", job.ID)
+	case errors.As(err, &postponed):
+		next := time.Now().Add(postponed.after)
+		log.Info("job postponed", "job_id", job.ID, "reason", postponed.reason, "next_run_at", next)
+		if derr := a.Store.DeferJob(persistCtx, job.ID, next); derr != nil {
+			log.Warn("defer job failed", "job_id", job.ID, "err", derr)
+		}
+	case err == nil:
+		if cerr := a.Store.CompleteJob(persistCtx, job.ID); cerr != nil {
+			log.Warn("complete job failed", "job_id", job.ID, "err", cerr)
+		}
+	case isRetryable(err) && job.Attempts < a.jobMaxAttempts():
+		next := time.Now().Add(jobBackoff(job.Attempts))
+		log.Warn("job failed, will retry", "job_id", job.ID, "attempt", job.Attempts, "next_run_at", next, "err", err)
+		if rerr := a.Store.RetryJob(persistCtx, job.ID, err.Error(), next); rerr != nil {
+			log.Warn("retry job failed", "job_id", job.ID, "err", rerr)
+		}
+		a.recordSave(persistCtx, &job, "", storage.SavePending, err.Error())
+		if job.AckMessageID != 0 {
+			_ = a.editAck(job.ChatID, job.AckMessageID, fmt.Sprintf("⏳ Не получилось (попытка %d/%d), повторю позже…", job.Attempts, a.jobMaxAttempts()))
+		}
+	default:
+		log.Warn("job failed", "job_id", job.ID, "attempt", job.Attempts, "err", err)
+		if ferr := a.Store.FailJob(persistCtx, job.ID, err.Error()); ferr != nil {
+			log.Warn("fail job failed", "job_id", job.ID, "err", ferr)
+		}
+		a.recordSave(persistCtx, &job, "", storage.SaveFailed, err.Error())
+		if isRetryable(err) && job.AckMessageID != 0 {
+			_ = a.editAck(job.ChatID, job.AckMessageID, fmt.Sprintf("❌ Не удалось сохранить после %d попыток.", job.Attempts))
+		}
+	}
+}
+
+func (a *App) executeJob(ctx context.Context, job *storage.Job) error {
+	var p jobPayload
+	if err := json.Unmarshal([]byte(job.Payload), &p); err != nil {
+		return fmt.Errorf("decode job payload: %w", err)
+	}
+	if len(p.Messages) == 0 {
+		return errors.New("job payload has no messages")
+	}
+	switch job.Kind {
+	case jobKindMessage:
+		return a.processMessageBatch(ctx, job, p.Messages[0], p.Messages)
+	case jobKindMediaGroup:
+		// Process album as a single unit: caption from pick, attachments from all messages.
+		return a.processMessageBatch(ctx, job, pickCaptionMessage(p.Messages), p.Messages)
+	case jobKindEdit:
+		return a.processEdit(ctx, p.Messages[0])
+	case jobKindChannel:
+		return a.processChannelPost(ctx, job, pickCaptionMessage(p.Messages), p.Messages)
+	default:
+		return fmt.Errorf("unknown job kind %q", job.Kind)
+	}
+}
+
+// recordSave updates the per-message save history for the message that started job.
+// Edit jobs only patch an existing save and leave its history alone.
+func (a *App) recordSave(ctx context.Context, job *storage.Job, kind string, status storage.SaveStatus, errText string) {
+	if job.Kind == jobKindEdit {
+		return
+	}
+	err := a.Store.RecordSave(ctx, storage.Save{
+		TelegramUserID: job.TelegramUserID,
+		ChatID:         job.ChatID,
+		MessageID:      job.MessageID,
+		BookmarkID:     primaryBookmarkID(job.BookmarkID),
+		Kind:           kind,
+		Status:         status,
+		Error:          errText,
+	})
+	if err != nil {
+		a.logger().Warn("record save failed", "job_id", job.ID, "err", err)
+	}
+}
+
+// pickCaptionMessage picks the message that has caption/text if any, otherwise first.
+func pickCaptionMessage(msgs []*tgbotapi.Message) *tgbotapi.Message {
+	for _, m := range msgs {
+		if m != nil && (strings.TrimSpace(m.Caption) != "" || strings.TrimSpace(m.Text) != "") {
+			return m
+		}
+	}
+	return msgs[0]
+}
+
+func jobBackoff(attempt int) time.Duration {
+	d := 10 * time.Second
+	for i := 1; i < attempt && d < 10*time.Minute; i++ {
+		d *= 2
+	}
+	if d > 10*time.Minute {
+		d = 10 * time.Minute
+	}
+	return d
+}
+
+func (a *App) jobMaxAttempts() int {
+	if a.JobMaxAttempts <= 0 {
+		return 5
+	}
+	return a.JobMaxAttempts
+}
+
+func (a *App) jobRetention() time.Duration {
+	if a.JobRetention <= 0 {
+		return 7 * 24 * time.Hour
+	}
+	return a.JobRetention
+}
+
+func (a *App) jobsWake() chan struct{} {
+	a.jobsWakeOnce.Do(func() {
+		a.jobsWakeCh = make(chan struct{}, 1)
+	})
+	return a.jobsWakeCh
+}
+
+func (a *App) wakeJobWorkers() {
+	select {
+	case a.jobsWake() <- struct{}{}:
+	default:
+	}
+}
+
+func (a *App) logger() *slog.Logger {
+	if a.Logger == nil {
+		return slog.Default()
+	}
+	return a.Logger
+}
@@ -0,0 +1,102 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"karakeep-telegram-bot/internal/ratelimit"
+)
+
+// Limits protect the bot and users' Karakeep servers from floods; a nil limiter disables its limit.
+// Concurrent saves are capped by the number of job workers.
+type Limits struct {
+	// User limits commands, button presses and saves per Telegram user; excess updates are dropped.
+	User *ratelimit.Limiter
+	// Server limits saves per Karakeep server; excess save jobs are postponed, not dropped.
+	Server *ratelimit.Limiter
+}
+
+// throttleNoticeInterval keeps a flooding user from also flooding their chat with "slow down" replies.
+const throttleNoticeInterval = 30 * time.Second
+
+// throttled spends one of the sender's tokens and reports whether msg must be dropped;
+// the user is told once per throttleNoticeInterval. A dropped /key with a key is deleted all the same.
+func (a *App) throttled(msg *tgbotapi.Message) bool {
+	ok, wait := a.Limits.User.Allow(strconv.FormatInt(msg.From.ID, 10))
+	if ok {
+		return false
+	}
+	a.logger().Info("user throttled", "user_id", msg.From.ID, "chat_id", msg.Chat.ID, "retry_in", wait)
+	if carriesAPIKey(msg) {
+		text := fmt.Sprintf("⏳ Слишком много сообщений подряд: ключ не сохранён. Сообщение с ним удалено — повторите /key через %d с.", waitSeconds(wait))
+		if !a.deleteSecretMessage(msg) {
+			text = fmt.Sprintf("⏳ Слишком много сообщений подряд: ключ не сохранён, повторите /key через %d с.\n⚠️ Не удалось удалить сообщение с ключом — удалите его сами.", waitSeconds(wait))
+		}
+		if msg.Chat.IsPrivate() {
+			_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, text))
+		} else {
+			a.replyInThread(msg, text)
+		}
+		return true
+	}
+	if noticed, _ := a.throttleNotices().Allow(strconv.FormatInt(msg.From.ID, 10)); !noticed {
+		return true
+	}
+	text := fmt.Sprintf("⏳ Слишком много сообщений подряд. Подождите %d с — до этого новые сообщения и команды я пропускаю.", waitSeconds(wait))
+	if msg.Chat.IsPrivate() {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, text))
+	} else {
+		a.replyInThread(msg, text)
+	}
+	return true
+}
+
+// carriesAPIKey reports whether msg is /key with an argument, which must not be left in the chat.
+func carriesAPIKey(msg *tgbotapi.Message) bool {
+	return msg.IsCommand() && strings.ToLower(msg.Command()) == "key" && strings.TrimSpace(msg.CommandArguments()) != ""
+}
+
+// throttledCallback is throttled for button presses; the answer is a toast, so it needs no throttling of its own.
+func (a *App) throttledCallback(cq *tgbotapi.CallbackQuery) bool {
+	ok, wait := a.Limits.User.Allow(strconv.FormatInt(cq.From.ID, 10))
+	if ok {
+		return false
+	}
+	a.answerCallback(cq, fmt.Sprintf("⏳ Слишком часто. Подождите %d с.", waitSeconds(wait)))
+	return true
+}
+
+// throttleServer spends one of serverBaseURL's save tokens; a *postponedError means the job should wait.
+func (a *App) throttleServer(serverBaseURL string) error {
+	if ok, wait := a.Limits.Server.Allow(serverKey(serverBaseURL)); !ok {
+		return &postponedError{reason: "karakeep server rate limit", after: wait}
+	}
+	return nil
+}
+
+// serverKey is the rate limit key of a Karakeep server: its base URL as stored by /server
+// (scheme+host[:port]), lowercased, so profiles and workspaces on one server share a bucket.
+func serverKey(serverBaseURL string) string {
+	u, err := url.Parse(strings.TrimSpace(serverBaseURL))
+	if err != nil || u.Host == "" {
+		return serverBaseURL
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+func (a *App) throttleNotices() *ratelimit.Limiter {
+	a.noticesOnce.Do(func() {
+		a.noticesLimiter = ratelimit.New(float64(time.Minute)/float64(throttleNoticeInterval), 1)
+	})
+	return a.noticesLimiter
+}
+
+func waitSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
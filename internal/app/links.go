@@ -0,0 +1,280 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"karakeep-telegram-bot/internal/classifier"
+	"karakeep-telegram-bot/internal/karakeep"
+	"karakeep-telegram-bot/internal/storage"
+)
+
+// kindLinks marks saves where every link of the message became its own bookmark.
+const kindLinks = "links"
+
+const (
+	// maxSplitLinks caps how many bookmarks a single message may fan out into.
+	maxSplitLinks = 20
+	// splitLinksParallel limits concurrent enrichment polls against the user's Karakeep.
+	splitLinksParallel = 4
+	// skippedLinkID stands for a link Karakeep refused, so job.BookmarkID stays aligned with the URL list.
+	skippedLinkID = "-"
+)
+
+// splitLinks reports whether the message should be saved as one link bookmark per URL.
+func splitLinks(u storage.User, res classifier.Result) bool {
+	return u.MultiURLMode == storage.MultiURLSplit && res.Kind == classifier.KindNote && !res.HasMedia && len(res.URLs) > 1
+}
+
+// linkIDs splits the comma-separated job.BookmarkID of a split-links job.
+func linkIDs(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// primaryBookmarkID is the bookmark that represents the whole message (first created link for split-links jobs).
+func primaryBookmarkID(s string) string {
+	for _, id := range linkIDs(s) {
+		if id != skippedLinkID {
+			return id
+		}
+	}
+	return ""
+}
+
+// sharedNote is the message text without its links: the comment that goes into every link's notes.
+// Lines that were nothing but a link (or a link plus punctuation) are dropped.
+func sharedNote(text string, urls []string) string {
+	var out []string
+	for _, line := range strings.Split(text, "\n") {
+		for _, u := range urls {
+			line = strings.ReplaceAll(line, u, "")
+		}
+		line = strings.Join(strings.Fields(line), " ")
+		if strings.IndexFunc(line, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) < 0 {
+			continue
+		}
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n")
+}
+
+// linkProgress is the per-link state rendered into the ack message.
+type linkProgress struct {
+	mu     sync.Mutex
+	lines  []string
+	footer []string
+}
+
+func (p *linkProgress) set(i int, line string) {
+	p.mu.Lock()
+	p.lines[i] = line
+	p.mu.Unlock()
+}
+
+func (p *linkProgress) render(header string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var sb strings.Builder
+	sb.WriteString(header)
+	for i, l := range p.lines {
+		fmt.Fprintf(&sb, "\n\n%d. %s", i+1, l)
+	}
+	if len(p.footer) > 0 {
+		sb.WriteString("\n\n" + strings.Join(p.footer, "\n"))
+	}
+	// Telegram rejects messages over 4096 characters.
+	return truncateRunes(sb.String(), 4000)
+}
+
+// processLinks saves every link of a multi-link message as its own bookmark with the surrounding text as notes.
+// Created ids are persisted one by one into job.BookmarkID, so a resumed job continues with the next link.
+func (a *App) processLinks(ctx context.Context, job *storage.Job, msg *tgbotapi.Message, client *karakeep.Client, u storage.User, res classifier.Result, ackID int) error {
+	log := a.logger()
+	persistCtx := context.WithoutCancel(ctx)
+	chatID := msg.Chat.ID
+
+	urls := res.URLs
+	header := fmt.Sprintf("🔗 Ссылок: %d", len(urls))
+	if len(urls) > maxSplitLinks {
+		header += fmt.Sprintf(" (сохраняю первые %d)", maxSplitLinks)
+		urls = urls[:maxSplitLinks]
+	}
+	note := sharedNote(res.Text, res.URLs)
+
+	ids := linkIDs(job.BookmarkID)
+	p := &linkProgress{lines: make([]string, len(urls))}
+	for i, link := range urls {
+		switch {
+		case i >= len(ids):
+			p.lines[i] = "⏳ " + link
+		case ids[i] == skippedLinkID:
+			p.lines[i] = "❌ " + link
+		default:
+			p.lines[i] = "✅ " + link
+		}
+	}
+
+	tagsFailed := false
+	for i := len(ids); i < len(urls); i++ {
+		link := urls[i]
+		_ = a.editAck(chatID, ackID, p.render(header))
+
+		b, status, err := client.CreateBookmark(ctx, link, "", note)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Warn("karakeep create link failed", "status", status, "url", link, "err", err)
+			if transientStatus(status) {
+				p.set(i, "⏳ "+link+" — повторю позже")
+				_ = a.editAck(chatID, ackID, p.render(header))
+				return retryable(err)
+			}
+			// Karakeep refused this particular link (bad URL, 400): keep going with the rest.
+			p.set(i, "❌ "+link+fmt.Sprintf(" — ошибка Karakeep (%d)", status))
+			b.ID = skippedLinkID
+		} else {
+			log.Info("karakeep created", "bookmark_id", b.ID, "status", status)
+			if len(res.Tags) > 0 {
+				if st, err := client.AttachTags(ctx, b.ID, res.Tags); err != nil {
+					log.Warn("karakeep attach tags failed", "status", st, "err", err)
+					tagsFailed = true
+				}
+			}
+			if line := a.addToTargetList(ctx, client, u, res.ListName, b.ID); line != "" && !contains(p.footer, line) {
+				p.footer = append(p.footer, line)
+			}
+			p.set(i, fmt.Sprintf("✅ %s (id=%s)", link, b.ID))
+			_ = a.Store.SetLastSuccess(persistCtx, msg.From.ID, b.ID)
+		}
+
+		ids = append(ids, b.ID)
+		job.BookmarkID = strings.Join(ids, ",")
+		if err := a.Store.SetJobBookmarkID(persistCtx, job.ID, job.BookmarkID); err != nil {
+			log.Warn("persist job bookmark id failed", "job_id", job.ID, "err", err)
+		}
+	}
+	if len(res.Tags) > 0 {
+		if tagsFailed {
+			p.footer = append(p.footer, "⚠️ Не все теги удалось добавить")
+		} else {
+			p.footer = append(p.footer, "🏷 #"+strings.Join(res.Tags, " #"))
+		}
+	}
+
+	if primaryBookmarkID(job.BookmarkID) == "" {
+		a.recordSave(persistCtx, job, kindLinks, storage.SaveFailed, "karakeep refused every link")
+		_ = a.editAck(chatID, ackID, p.render("❌ Не удалось сохранить ни одной ссылки"))
+		return nil
+	}
+	a.recordSave(persistCtx, job, kindLinks, storage.SaveSaved, "")
+
+	header = fmt.Sprintf("✅ Сохранено ссылок: %d. Жду саммари…", len(urls))
+	_ = a.editAck(chatID, ackID, p.render(header))
+
+	// Enrichment: each link is polled independently; the ack is re-rendered whenever one of them is done.
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, splitLinksParallel)
+	for i, id := range ids {
+		if id == skippedLinkID {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			p.set(i, a.enrichLink(ctx, client, urls[i], id))
+			if ctx.Err() == nil {
+				_ = a.editAck(chatID, ackID, p.render(header))
+			}
+		}(i, id)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	_ = a.editAck(chatID, ackID, p.render(fmt.Sprintf("✅ Сохранено ссылок: %d", len(urls))))
+	return nil
+}
+
+// enrichLink waits for one link bookmark to get content and a summary and returns its progress line.
+func (a *App) enrichLink(ctx context.Context, client *karakeep.Client, link, id string) string {
+	ready, err := a.waitForExtractedContent(ctx, client, id, 3*time.Second, 3*time.Minute)
+	if errors.Is(err, errBookmarkGone) {
+		return "🗑 " + link
+	}
+	if !ready {
+		return fmt.Sprintf("⚠️ %s (id=%s) — контент не загрузился", link, id)
+	}
+	got, ok, err := a.waitForNonEmptySummary(ctx, client, id, 3*time.Second, 3*time.Minute)
+	if errors.Is(err, errBookmarkGone) {
+		return "🗑 " + link
+	}
+	if !ok {
+		return fmt.Sprintf("⚠️ %s (id=%s) — саммари ещё не готово", link, id)
+	}
+	line := "✅ " + link
+	if t := oneLine(got.Title); t != "" {
+		line = "✅ " + t + "\n" + link
+	}
+	if s := oneLine(got.SummaryText()); s != "" {
+		line += "\n" + truncateRunes(s, 300)
+	}
+	return line
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *App) cmdLinks(ctx context.Context, msg *tgbotapi.Message) {
+	arg := strings.ToLower(strings.TrimSpace(msg.CommandArguments()))
+	switch arg {
+	case "":
+		u, err := a.Store.GetUser(ctx, msg.From.ID)
+		if err != nil {
+			_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Ошибка чтения настроек."))
+			return
+		}
+		current := "одна заметка на сообщение"
+		if u.MultiURLMode == storage.MultiURLSplit {
+			current = "отдельная закладка на каждую ссылку"
+		}
+		text := "Сообщения с несколькими ссылками: " + current + "\n\n" +
+			"/links split — каждая ссылка отдельной закладкой, текст сообщения — в заметки\n" +
+			"/links note — всё сообщение одной заметкой"
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, text))
+		return
+	case storage.MultiURLSplit, storage.MultiURLNote:
+	default:
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Использование: /links split|note"))
+		return
+	}
+
+	if err := a.Store.SetMultiURLMode(ctx, msg.From.ID, arg); err != nil {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Не удалось сохранить настройку."))
+		return
+	}
+	text := "✅ Несколько ссылок в сообщении сохраняю одной заметкой."
+	if arg == storage.MultiURLSplit {
+		text = "✅ Каждую ссылку из сообщения сохраняю отдельной закладкой."
+	}
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, text))
+}
@@ -0,0 +1,123 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"karakeep-telegram-bot/internal/karakeep"
+	"karakeep-telegram-bot/internal/storage"
+)
+
+// resolveList finds a list by name (case-insensitive, "_" matches a space, so "#list:read_later" finds "Read later").
+// When create is true, a missing list is created.
+func resolveList(ctx context.Context, client *karakeep.Client, name string, create bool) (karakeep.List, bool, error) {
+	lists, _, err := client.ListLists(ctx)
+	if err != nil {
+		return karakeep.List{}, false, err
+	}
+	want := normalizeListName(name)
+	for _, l := range lists {
+		if normalizeListName(l.Name) == want {
+			return l, false, nil
+		}
+	}
+	if !create {
+		return karakeep.List{}, false, fmt.Errorf("list %q not found", name)
+	}
+	l, _, err := client.CreateList(ctx, strings.ReplaceAll(strings.TrimSpace(name), "_", " "), "")
+	if err != nil {
+		return karakeep.List{}, false, err
+	}
+	return l, true, nil
+}
+
+func normalizeListName(s string) string {
+	s = strings.ReplaceAll(strings.TrimSpace(s), "_", " ")
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// addToTargetList puts a fresh bookmark into the list from a "#list:" directive, or the user's default list.
+// Returns a short line for the ack message; empty when there is no target list.
+func (a *App) addToTargetList(ctx context.Context, client *karakeep.Client, u storage.User, override string, bookmarkID string) string {
+	log := a.logger()
+	listID, listName := u.DefaultListID, u.DefaultListName
+	if override != "" {
+		l, _, err := resolveList(ctx, client, override, true)
+		if err != nil {
+			log.Warn("resolve list failed", "list", override, "err", err)
+			return "⚠️ Не удалось найти/создать список " + override
+		}
+		listID, listName = l.ID, l.Name
+	}
+	if listID == "" {
+		return ""
+	}
+	if st, err := client.AddBookmarkToList(ctx, listID, bookmarkID); err != nil {
+		log.Warn("karakeep add to list failed", "status", st, "list_id", listID, "err", err)
+		return "⚠️ Не удалось добавить в список " + listName
+	}
+	return "📁 Список: " + listName
+}
+
+func (a *App) cmdList(ctx context.Context, msg *tgbotapi.Message) {
+	arg := strings.TrimSpace(msg.CommandArguments())
+
+	client, u, err := a.userClient(ctx, msg.From.ID)
+	if err != nil {
+		a.sendClientError(msg.Chat.ID, err)
+		return
+	}
+
+	switch strings.ToLower(arg) {
+	case "":
+		current := u.DefaultListName
+		if current == "" {
+			current = "(нет, только Inbox)"
+		}
+		var sb strings.Builder
+		sb.WriteString("Список по умолчанию: " + current + "\n")
+		lists, st, err := client.ListLists(ctx)
+		if err != nil {
+			sb.WriteString("\n" + userFacingKarakeepError(st, err))
+		} else if len(lists) > 0 {
+			sb.WriteString("\nСписки в Karakeep:\n")
+			for _, l := range lists {
+				sb.WriteString("• " + strings.TrimSpace(l.Icon+" "+l.Name) + "\n")
+			}
+		}
+		sb.WriteString("\nУстановить: /list <название>\nСбросить: /list off\nДля одного сообщения: #list:<название>")
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, sb.String()))
+		return
+	case "off", "-", "none":
+		if err := a.Store.SetDefaultList(ctx, msg.From.ID, "", ""); err != nil {
+			_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Не удалось сохранить список."))
+			return
+		}
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "✅ Список по умолчанию сброшен, сохраняю только в Inbox."))
+		return
+	}
+
+	l, created, err := resolveList(ctx, client, arg, true)
+	if err != nil {
+		var apiErr *karakeep.APIError
+		if errors.As(err, &apiErr) {
+			_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, userFacingKarakeepError(apiErr.StatusCode, err)))
+			return
+		}
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Не удалось найти или создать список: "+err.Error()))
+		return
+	}
+	if err := a.Store.SetDefaultList(ctx, msg.From.ID, l.ID, l.Name); err != nil {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Не удалось сохранить список."))
+		return
+	}
+	text := "✅ Список по умолчанию: " + l.Name
+	if created {
+		text += " (создан)"
+	}
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, text))
+}
@@ -0,0 +1,57 @@
+package app
+
+import (
+	"context"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"karakeep-telegram-bot/internal/storage"
+)
+
+// Kinds of questions asked with ForceReply (storage.PendingInput.Kind).
+const (
+	inputTags   = "tags"
+	inputAPIKey = "api_key"
+)
+
+// askForInput sends prompt with ForceReply and remembers what the answer is for.
+func (a *App) askForInput(ctx context.Context, chatID int64, telegramUserID int64, kind string, payload string, prompt string) error {
+	out := tgbotapi.NewMessage(chatID, prompt)
+	out.ReplyMarkup = tgbotapi.ForceReply{ForceReply: true, Selective: true}
+	sent, err := a.Bot.Send(out)
+	if err != nil {
+		return err
+	}
+	return a.Store.SetPendingInput(ctx, storage.PendingInput{
+		TelegramUserID:  telegramUserID,
+		Kind:            kind,
+		Payload:         payload,
+		ChatID:          chatID,
+		PromptMessageID: sent.MessageID,
+	})
+}
+
+// handlePendingInput consumes msg if it answers a question the bot asked this user.
+func (a *App) handlePendingInput(ctx context.Context, msg *tgbotapi.Message) bool {
+	if msg.ReplyToMessage == nil || msg.Chat == nil {
+		return false
+	}
+	p, ok, err := a.Store.TakePendingInput(ctx, msg.From.ID, msg.Chat.ID, msg.ReplyToMessage.MessageID)
+	if err != nil {
+		a.logger().Warn("take pending input failed", "err", err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+	switch p.Kind {
+	case inputTags:
+		a.goInteractive(func() { a.answerTagsInput(ctx, msg, p.Payload) })
+	case inputAPIKey:
+		a.goInteractive(func() { a.setAPIKey(ctx, msg, strings.TrimSpace(msg.Text)) })
+	default:
+		a.logger().Warn("unknown pending input kind", "kind", p.Kind)
+	}
+	return true
+}
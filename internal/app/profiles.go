@@ -0,0 +1,155 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"karakeep-telegram-bot/internal/security"
+	"karakeep-telegram-bot/internal/storage"
+)
+
+const profileUsage = "Использование:\n" +
+	"/profile — список профилей\n" +
+	"/profile add <имя> [https://<host>] — добавить профиль\n" +
+	"/profile remove <имя> — удалить профиль\n" +
+	"/use <имя> — переключиться: /server и /key меняют активный профиль\n\n" +
+	"@<имя> в начале сообщения — сохранить в этот профиль, не переключаясь."
+
+func (a *App) cmdProfile(ctx context.Context, msg *tgbotapi.Message) {
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) == 0 || (len(args) == 1 && strings.ToLower(args[0]) == "list") {
+		a.sendProfiles(ctx, msg)
+		return
+	}
+	switch sub := strings.ToLower(args[0]); {
+	case sub == "add" && (len(args) == 2 || len(args) == 3):
+		server := ""
+		if len(args) == 3 {
+			norm, err := security.ValidateServerBaseURL(args[2])
+			if err != nil {
+				_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Некорректный / небезопасный URL ("+err.Error()+"). Разрешён только публичный https, если администратор бота не разрешил другое."))
+				return
+			}
+			server = norm
+		}
+		a.addProfile(ctx, msg, strings.ToLower(args[1]), server)
+	case sub == "remove" && len(args) == 2:
+		a.removeProfile(ctx, msg, strings.ToLower(args[1]))
+	default:
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, profileUsage))
+	}
+}
+
+func (a *App) cmdUse(ctx context.Context, msg *tgbotapi.Message) {
+	name := strings.ToLower(strings.TrimSpace(msg.CommandArguments()))
+	if name == "" {
+		a.sendProfiles(ctx, msg)
+		return
+	}
+	err := a.Store.SetActiveProfile(ctx, msg.From.ID, name)
+	if errors.Is(err, storage.ErrProfileNotFound) {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Профиль «"+name+"» не найден. Профили: /profile"))
+		return
+	}
+	if err != nil {
+		a.logger().Warn("set active profile failed", "err", err)
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Не удалось переключить профиль."))
+		return
+	}
+	text := "✅ Активный профиль: " + name + ". /server и /key теперь меняют его."
+	if _, _, inWorkspace, err := a.Store.GetUserWorkspace(ctx, msg.From.ID); err == nil && inWorkspace {
+		text += "\n\nВы в общем пространстве: без @" + name + " в сообщении сохраняю в его Karakeep."
+	}
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, text))
+}
+
+func (a *App) sendProfiles(ctx context.Context, msg *tgbotapi.Message) {
+	u, err := a.Store.GetUser(ctx, msg.From.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Ошибка чтения настроек."))
+		return
+	}
+	var b strings.Builder
+	b.WriteString("Профили:\n")
+	for _, name := range u.ProfileNames() {
+		p, _ := u.Profile(name)
+		mark := "  "
+		if name == u.ActiveProfileName() {
+			mark = "▶ "
+		}
+		server := strings.TrimSpace(p.ServerBaseURL)
+		if server == "" {
+			server = "(сервер не задан)"
+		}
+		key := "ключ ✅"
+		if p.APIKeyCiphertextB64 == "" {
+			key = "ключ ❌"
+		}
+		b.WriteString(mark + name + " — " + server + ", " + key + "\n")
+	}
+	b.WriteString("\n" + profileUsage)
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, b.String()))
+}
+
+func (a *App) addProfile(ctx context.Context, msg *tgbotapi.Message, name, server string) {
+	if !storage.ValidProfileName(name) || name == storage.DefaultProfile {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Имя профиля: латиница, цифры, _ и -, до 32 символов (кроме «default»)."))
+		return
+	}
+	err := a.Store.AddProfile(ctx, msg.From.ID, name, server)
+	if errors.Is(err, storage.ErrProfileExists) {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Профиль «"+name+"» уже есть."))
+		return
+	}
+	if err != nil {
+		a.logger().Warn("add profile failed", "err", err)
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Не удалось добавить профиль."))
+		return
+	}
+	text := "✅ Профиль «" + name + "» добавлен. Настроить: /use " + name + ", затем "
+	if server == "" {
+		text += "/server и /key"
+	} else {
+		text += "/key"
+	}
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, text))
+}
+
+func (a *App) removeProfile(ctx context.Context, msg *tgbotapi.Message, name string) {
+	if name == storage.DefaultProfile {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Профиль «default» удалить нельзя."))
+		return
+	}
+	ok, err := a.Store.RemoveProfile(ctx, msg.From.ID, name)
+	if err != nil {
+		a.logger().Warn("remove profile failed", "err", err)
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Не удалось удалить профиль."))
+		return
+	}
+	if !ok {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Профиль «"+name+"» не найден."))
+		return
+	}
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "🗑 Профиль «"+name+"» удалён."))
+}
+
+// activeProfile returns the profile /server and /key change; unknown users have the default one.
+func (a *App) activeProfile(ctx context.Context, telegramUserID int64) string {
+	u, err := a.Store.GetUser(ctx, telegramUserID)
+	if err != nil {
+		return storage.DefaultProfile
+	}
+	return u.ActiveProfileName()
+}
+
+// profileSuffix names a non-default profile in settings replies; the default one stays implicit.
+func profileSuffix(profile string) string {
+	if profile == "" || profile == storage.DefaultProfile {
+		return ""
+	}
+	return " (профиль " + profile + ")"
+}
@@ -0,0 +1,109 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"karakeep-telegram-bot/internal/storage"
+)
+
+const (
+	recentDefault = 10
+	recentMax     = 50
+	// recentChunkRunes keeps each reply under Telegram's 4096-character limit, with room for
+	// emoji that count as two characters there.
+	recentChunkRunes = 4000
+)
+
+func (a *App) cmdRecent(ctx context.Context, msg *tgbotapi.Message) {
+	n := recentDefault
+	if arg := strings.TrimSpace(msg.CommandArguments()); arg != "" {
+		v, err := strconv.Atoi(arg)
+		if err != nil || v <= 0 {
+			_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Использование: /recent [n]"))
+			return
+		}
+		n = min(v, recentMax)
+	}
+
+	saves, err := a.Store.RecentSaves(ctx, msg.From.ID, n)
+	if err != nil {
+		a.logger().Warn("recent saves failed", "err", err)
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Ошибка чтения истории."))
+		return
+	}
+	if len(saves) == 0 {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Пока ничего не сохранено."))
+		return
+	}
+
+	lines := make([]string, 0, len(saves))
+	for _, sv := range saves {
+		lines = append(lines, formatSaveLine(sv))
+	}
+	header := fmt.Sprintf("Последние сохранения (%d):\n", len(saves))
+	for _, text := range chunkLines(header, lines, recentChunkRunes) {
+		if _, err := a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, text)); err != nil {
+			a.logger().Warn("send recent saves failed", "chat_id", msg.Chat.ID, "err", err)
+			return
+		}
+	}
+}
+
+// chunkLines joins header and lines into messages of at most limit runes each, breaking only
+// between lines. A single line longer than limit is truncated.
+func chunkLines(header string, lines []string, limit int) []string {
+	var chunks []string
+	var sb strings.Builder
+	sb.WriteString(header)
+	size := utf8.RuneCountInString(header)
+	for _, l := range lines {
+		l = truncateRunes(l, limit-2)
+		n := utf8.RuneCountInString(l) + 1
+		if size+n > limit && size > 0 {
+			chunks = append(chunks, sb.String())
+			sb.Reset()
+			size = 0
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(l)
+		size += n
+	}
+	if sb.Len() > 0 {
+		chunks = append(chunks, sb.String())
+	}
+	return chunks
+}
+
+func formatSaveLine(sv storage.Save) string {
+	icon := "⏳"
+	switch sv.Status {
+	case storage.SaveSaved:
+		icon = "✅"
+	case storage.SaveFailed:
+		icon = "❌"
+	case storage.SaveUndone:
+		icon = "↩️"
+	case storage.SaveDeleted:
+		icon = "🗑"
+	}
+	line := fmt.Sprintf("%s %s", icon, sv.CreatedAt.In(time.Local).Format("2006-01-02 15:04"))
+	if sv.Kind != "" {
+		line += " " + sv.Kind
+	}
+	if sv.BookmarkID != "" {
+		line += " id=" + sv.BookmarkID
+	}
+	if sv.Error != "" && sv.Status != storage.SaveSaved {
+		line += "\n   " + truncateRunes(oneLine(sv.Error), 120)
+	}
+	return line
+}
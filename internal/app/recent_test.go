@@ -0,0 +1,41 @@
+package app
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestChunkLines(t *testing.T) {
+	line := "✅ 2024-05-01 10:00 message id=abc\n   " + strings.Repeat("ошибка ", 17)
+	lines := make([]string, recentMax)
+	for i := range lines {
+		lines[i] = line
+	}
+	header := "Последние сохранения (50):\n"
+	chunks := chunkLines(header, lines, recentChunkRunes)
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunk(s), want the text split", len(chunks))
+	}
+	if !strings.HasPrefix(chunks[0], header+"\n"+line) {
+		t.Errorf("first chunk starts with %q", chunks[0][:80])
+	}
+	total := 0
+	for i, c := range chunks {
+		if n := utf8.RuneCountInString(c); n > recentChunkRunes {
+			t.Errorf("chunk %d has %d runes, limit %d", i, n, recentChunkRunes)
+		}
+		total += strings.Count(c, "✅")
+	}
+	if total != len(lines) {
+		t.Errorf("chunks hold %d lines, want %d", total, len(lines))
+	}
+
+	if got := chunkLines("h\n", []string{"a", "b"}, recentChunkRunes); len(got) != 1 || got[0] != "h\n\na\nb" {
+		t.Errorf("short list = %q", got)
+	}
+	long := chunkLines("", []string{strings.Repeat("x", 50)}, 10)
+	if len(long) != 1 || utf8.RuneCountInString(long[0]) > 10 {
+		t.Errorf("over-long line = %q", long)
+	}
+}
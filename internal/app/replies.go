@@ -0,0 +1,166 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"karakeep-telegram-bot/internal/classifier"
+	"karakeep-telegram-bot/internal/karakeep"
+	"karakeep-telegram-bot/internal/storage"
+)
+
+// kindReply marks saves that extended an existing bookmark rather than creating one.
+const kindReply = "reply"
+
+// replyNoteRe matches the start of a segment processReply appended to a bookmark's notes.
+var replyNoteRe = regexp.MustCompile(`(?:^|\n\n)\[\d{4}-\d{2}-\d{2} \d{2}:\d{2}\] `)
+
+// replyNote formats a reply's text as a notes segment; splitReplyNotes recognizes it.
+func replyNote(date int, text string) string {
+	return fmt.Sprintf("[%s] %s", time.Unix(int64(date), 0).UTC().Format("2006-01-02 15:04"), text)
+}
+
+// splitReplyNotes separates a bookmark's notes into the part that came from the saved message
+// and the reply segments appended after it.
+func splitReplyNotes(notes string) (own, replies string) {
+	notes = strings.TrimSpace(notes)
+	loc := replyNoteRe.FindStringIndex(notes)
+	if loc == nil {
+		return notes, ""
+	}
+	return strings.TrimSpace(notes[:loc[0]]), strings.TrimSpace(notes[loc[0]:])
+}
+
+// joinNotes concatenates notes parts, skipping empty ones.
+func joinNotes(parts ...string) string {
+	var out []string
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return strings.Join(out, "\n\n")
+}
+
+// replyTarget returns the save that msg replies to, if the bot saved that message successfully.
+func (a *App) replyTarget(ctx context.Context, msg *tgbotapi.Message) (storage.Save, bool) {
+	if msg.ReplyToMessage == nil {
+		return storage.Save{}, false
+	}
+	sv, ok, err := a.Store.GetSaveByMessage(ctx, msg.Chat.ID, msg.ReplyToMessage.MessageID)
+	if err != nil {
+		a.logger().Warn("lookup reply target failed", "err", err)
+		return storage.Save{}, false
+	}
+	// A message split into several link bookmarks has no single bookmark to extend.
+	// In groups the replied-to message may have been saved by someone else, into their Karakeep.
+	if !ok || sv.Status != storage.SaveSaved || sv.BookmarkID == "" || sv.Kind == kindLinks || sv.TelegramUserID != msg.From.ID {
+		return storage.Save{}, false
+	}
+	return sv, true
+}
+
+// processReply appends the reply text to the target bookmark's notes and attaches the reply's media.
+func (a *App) processReply(ctx context.Context, job *storage.Job, msg *tgbotapi.Message, batch []*tgbotapi.Message, target storage.Save) error {
+	log := a.logger()
+	persistCtx := context.WithoutCancel(ctx)
+	bookmarkID := target.BookmarkID
+
+	if job.BookmarkID != "" {
+		// Resumed after the reply was already applied.
+		return nil
+	}
+	a.recordReply(persistCtx, job, bookmarkID, storage.SavePending)
+	if err := a.Store.SetSaveProfile(persistCtx, job.TelegramUserID, job.ChatID, job.MessageID, target.Profile); err != nil {
+		log.Warn("record save profile failed", "job_id", job.ID, "err", err)
+	}
+
+	ackID, err := a.ensureAck(job, msg.Chat.ID, "⏳ Добавляю к закладке…")
+	if err != nil {
+		return err
+	}
+
+	client, _, err := a.profileClient(ctx, msg.From.ID, target.Profile)
+	if err != nil {
+		_ = a.editAck(msg.Chat.ID, ackID, "❌ Ошибка конфигурации Karakeep: "+err.Error())
+		return err
+	}
+
+	res := classifier.ClassifyMessageWithOptions(msg, a.classifyOptions(ctx, msg))
+	text := strings.TrimSpace(res.Text)
+	if res.Kind == classifier.KindBookmark {
+		text = strings.TrimSpace(firstNonEmptyString(res.Notes, res.URL))
+	}
+	log.Info("processing reply", "job_id", job.ID, "bookmark_id", bookmarkID, "text_len", len(text))
+
+	// The notes are appended once: a retry after a failed upload only attaches the rest.
+	if text != "" && job.Stage < storage.JobStageNotes {
+		current, st, err := client.GetBookmark(ctx, bookmarkID)
+		if err != nil {
+			return a.replyKarakeepError(ctx, msg.Chat.ID, ackID, st, err)
+		}
+		notes := joinNotes(current.Notes, replyNote(msg.Date, text))
+		if _, st, err := client.UpdateBookmark(ctx, bookmarkID, map[string]any{"notes": notes}); err != nil {
+			return a.replyKarakeepError(ctx, msg.Chat.ID, ackID, st, err)
+		}
+		if err := a.Store.SetJobStage(persistCtx, job.ID, storage.JobStageNotes); err != nil {
+			log.Warn("persist job stage failed", "job_id", job.ID, "err", err)
+		}
+		job.Stage = storage.JobStageNotes
+	}
+
+	if err := a.attachAssets(ctx, client, job, msg.Chat.ID, ackID, bookmarkID, ExtractAttachments(batch)); err != nil {
+		return err
+	}
+	if len(res.Tags) > 0 {
+		if st, err := client.AttachTags(ctx, bookmarkID, res.Tags); err != nil {
+			log.Warn("karakeep attach tags failed", "status", st, "err", err)
+		}
+	}
+
+	if err := a.Store.SetJobBookmarkID(persistCtx, job.ID, bookmarkID); err != nil {
+		log.Warn("persist job bookmark id failed", "job_id", job.ID, "err", err)
+	}
+	job.BookmarkID = bookmarkID
+	a.recordReply(persistCtx, job, bookmarkID, storage.SaveSaved)
+
+	// No bookmark keyboard here: its buttons would delete the whole target bookmark, not this addition.
+	_ = a.editAck(msg.Chat.ID, ackID, "💬 Добавлено к закладке (id="+bookmarkID+").")
+	return nil
+}
+
+func (a *App) replyKarakeepError(ctx context.Context, chatID int64, ackID int, status int, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	a.logger().Warn("karakeep reply update failed", "status", status, "err", err)
+	if karakeep.IsNotFound(err) {
+		_ = a.editAck(chatID, ackID, "❌ Исходная закладка уже удалена.")
+		return err
+	}
+	_ = a.editAck(chatID, ackID, userFacingKarakeepError(status, err))
+	if transientStatus(status) {
+		return retryable(err)
+	}
+	return err
+}
+
+// recordReply maps the reply message to the bookmark it extended, so replies to replies work too.
+func (a *App) recordReply(ctx context.Context, job *storage.Job, bookmarkID string, status storage.SaveStatus) {
+	err := a.Store.RecordSave(ctx, storage.Save{
+		TelegramUserID: job.TelegramUserID,
+		ChatID:         job.ChatID,
+		MessageID:      job.MessageID,
+		BookmarkID:     bookmarkID,
+		Kind:           kindReply,
+		Status:         status,
+	})
+	if err != nil {
+		a.logger().Warn("record reply failed", "job_id", job.ID, "err", err)
+	}
+}
@@ -0,0 +1,147 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"karakeep-telegram-bot/internal/karakeep"
+)
+
+const searchPageSize = 5
+
+// searchState is persisted behind the "next page" button token.
+type searchState struct {
+	Query  string `json:"q"`
+	Cursor string `json:"c,omitempty"`
+	Page   int    `json:"p"`
+}
+
+func (a *App) cmdSearch(ctx context.Context, msg *tgbotapi.Message) {
+	query := strings.TrimSpace(msg.CommandArguments())
+	if query == "" {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Использование: /search <запрос>"))
+		return
+	}
+
+	client, _, err := a.userClient(ctx, msg.From.ID)
+	if err != nil {
+		a.sendClientError(msg.Chat.ID, err)
+		return
+	}
+
+	text, markup, err := a.renderSearchPage(ctx, client, msg.From.ID, searchState{Query: query, Page: 1})
+	if err != nil {
+		text = err.Error()
+	}
+	out := tgbotapi.NewMessage(msg.Chat.ID, text)
+	out.DisableWebPagePreview = true
+	if markup != nil {
+		out.ReplyMarkup = *markup
+	}
+	_, _ = a.Bot.Send(out)
+}
+
+func (a *App) cbSearch(ctx context.Context, cq *tgbotapi.CallbackQuery, token string) {
+	if cq.Message == nil {
+		a.answerCallback(cq, "")
+		return
+	}
+	payload, ok, err := a.Store.GetCallbackData(ctx, cq.From.ID, token)
+	if err != nil || !ok {
+		a.answerCallback(cq, "Поиск устарел, повторите /search.")
+		return
+	}
+	var st searchState
+	if err := json.Unmarshal([]byte(payload), &st); err != nil {
+		a.answerCallback(cq, "Поиск устарел, повторите /search.")
+		return
+	}
+
+	client, _, err := a.userClient(ctx, cq.From.ID)
+	if err != nil {
+		a.answerCallback(cq, "❌ Не настроено.")
+		return
+	}
+	text, markup, err := a.renderSearchPage(ctx, client, cq.From.ID, st)
+	if err != nil {
+		a.answerCallback(cq, err.Error())
+		return
+	}
+	a.answerCallback(cq, "")
+
+	edit := tgbotapi.NewEditMessageText(cq.Message.Chat.ID, cq.Message.MessageID, text)
+	edit.DisableWebPagePreview = true
+	edit.ReplyMarkup = markup
+	if _, err := a.Bot.Send(edit); err != nil {
+		a.logger().Warn("failed to edit search page", "err", err)
+	}
+}
+
+// renderSearchPage fetches one page and returns its text plus a "next page" keyboard when there is more.
+// Returned errors are already user-facing.
+func (a *App) renderSearchPage(ctx context.Context, client *karakeep.Client, telegramUserID int64, st searchState) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+	res, status, err := client.SearchBookmarks(ctx, st.Query, st.Cursor, searchPageSize)
+	if err != nil {
+		a.logger().Warn("karakeep search failed", "status", status, "err", err)
+		return "", nil, errors.New(userFacingKarakeepError(status, err))
+	}
+
+	text := formatSearchPage(st, res.Bookmarks)
+	if res.NextCursor == "" || len(res.Bookmarks) == 0 {
+		return text, nil, nil
+	}
+
+	next, _ := json.Marshal(searchState{Query: st.Query, Cursor: res.NextCursor, Page: st.Page + 1})
+	token, err := a.Store.PutCallbackData(ctx, telegramUserID, string(next))
+	if err != nil {
+		a.logger().Warn("store search cursor failed", "err", err)
+		return text, nil, nil
+	}
+	markup := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Далее ➡️", cbSearch+":"+token),
+	))
+	return text, &markup, nil
+}
+
+func formatSearchPage(st searchState, bookmarks []karakeep.Bookmark) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "🔎 «%s»", st.Query)
+	if st.Page > 1 {
+		fmt.Fprintf(&sb, " — стр. %d", st.Page)
+	}
+	sb.WriteString("\n")
+	if len(bookmarks) == 0 {
+		if st.Page > 1 {
+			sb.WriteString("\nБольше ничего не найдено.")
+		} else {
+			sb.WriteString("\nНичего не найдено.")
+		}
+		return sb.String()
+	}
+	for i, b := range bookmarks {
+		n := (st.Page-1)*searchPageSize + i + 1
+		fmt.Fprintf(&sb, "\n%d. %s\n", n, truncateRunes(oneLine(b.DisplayTitle()), 120))
+		if link := b.Link(); link != "" {
+			sb.WriteString(link + "\n")
+		}
+		sb.WriteString("id: " + b.ID + "\n")
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n]) + "…"
+}
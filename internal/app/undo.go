@@ -0,0 +1,75 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"karakeep-telegram-bot/internal/karakeep"
+	"karakeep-telegram-bot/internal/storage"
+)
+
+func (a *App) cmdUndo(ctx context.Context, msg *tgbotapi.Message) {
+	u, err := a.Store.GetUser(ctx, msg.From.ID)
+	if err != nil {
+		a.sendClientError(msg.Chat.ID, err)
+		return
+	}
+	bookmarkID := strings.TrimSpace(u.LastSuccessID.String)
+	if !u.LastSuccessID.Valid || bookmarkID == "" {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Нечего отменять."))
+		return
+	}
+	client, _, err := a.bookmarkClient(ctx, msg.From.ID, bookmarkID)
+	if err != nil {
+		a.sendClientError(msg.Chat.ID, err)
+		return
+	}
+	text, _ := a.undoBookmark(ctx, client, msg.From.ID, bookmarkID)
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, text))
+}
+
+// undoBookmark detaches (and thereby deletes) the bookmark's assets, deletes the bookmark and records the undo.
+// The returned text is user-facing in both the success and the error case.
+func (a *App) undoBookmark(ctx context.Context, client *karakeep.Client, telegramUserID int64, bookmarkID string) (string, error) {
+	log := a.logger()
+
+	b, st, err := client.GetBookmark(ctx, bookmarkID)
+	if err != nil && !karakeep.IsNotFound(err) {
+		log.Warn("karakeep get bookmark failed", "status", st, "err", err)
+		return userFacingKarakeepError(st, err), err
+	}
+	assets := 0
+	for _, as := range b.Assets {
+		if strings.TrimSpace(as.ID) == "" {
+			continue
+		}
+		if st, err := client.DetachAsset(ctx, bookmarkID, as.ID); err != nil && !karakeep.IsNotFound(err) {
+			log.Warn("karakeep detach asset failed", "status", st, "asset_id", as.ID, "err", err)
+			return userFacingKarakeepError(st, err), err
+		}
+		assets++
+	}
+	if st, err := client.DeleteBookmark(ctx, bookmarkID); err != nil && !karakeep.IsNotFound(err) {
+		log.Warn("karakeep delete bookmark failed", "status", st, "err", err)
+		return userFacingKarakeepError(st, err), err
+	}
+
+	if err := a.Store.ClearLastSuccess(ctx, telegramUserID, bookmarkID); err != nil {
+		log.Warn("clear last success failed", "err", err)
+	}
+	if err := a.Store.SetSaveStatusByBookmark(ctx, telegramUserID, bookmarkID, storage.SaveUndone); err != nil {
+		log.Warn("mark save undone failed", "err", err)
+	}
+	if err := a.Store.AddHistory(ctx, telegramUserID, storage.HistoryUndo, bookmarkID, fmt.Sprintf("assets=%d", assets)); err != nil {
+		log.Warn("record undo failed", "err", err)
+	}
+
+	text := "↩️ Отменено: закладка удалена (id=" + bookmarkID + ")"
+	if assets > 0 {
+		text += fmt.Sprintf(", файлов: %d", assets)
+	}
+	return text + ".", nil
+}
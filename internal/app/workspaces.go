@@ -0,0 +1,290 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"karakeep-telegram-bot/internal/storage"
+)
+
+const workspaceUsage = "Использование:\n" +
+	"/workspace — текущее пространство и участники\n" +
+	"/workspace create <название> — создать пространство с вашими /server и /key\n" +
+	"/workspace invite [admin] — код приглашения (одноразовый, 48 часов)\n" +
+	"/workspace remove <id> — исключить участника\n" +
+	"/workspace role <id> admin|member|owner — сменить роль (owner — передать владение)\n" +
+	"/workspace leave — выйти из пространства\n" +
+	"/join <код> — присоединиться по приглашению\n\n" +
+	"В пространстве /server и /key меняют общие настройки (только владелец и админы)."
+
+func (a *App) cmdWorkspace(ctx context.Context, msg *tgbotapi.Message) {
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) > 0 && strings.ToLower(args[0]) == "create" {
+		a.createWorkspace(ctx, msg, strings.Join(args[1:], " "))
+		return
+	}
+
+	ws, m, ok, err := a.Store.GetUserWorkspace(ctx, msg.From.ID)
+	if err != nil {
+		a.logger().Warn("get user workspace failed", "err", err)
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Ошибка чтения настроек."))
+		return
+	}
+	if !ok {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Вы не в общем пространстве, сохраняю с вашими /server и /key.\n\n"+workspaceUsage))
+		return
+	}
+	if len(args) == 0 {
+		a.sendWorkspaceInfo(ctx, msg, ws, m)
+		return
+	}
+
+	switch sub := strings.ToLower(args[0]); {
+	case sub == "invite" && len(args) <= 2:
+		role := storage.RoleMember
+		if len(args) == 2 {
+			role = strings.ToLower(args[1])
+		}
+		a.inviteToWorkspace(ctx, msg, ws, m, role)
+	case sub == "remove" && len(args) == 2:
+		a.removeFromWorkspace(ctx, msg, ws, m, args[1])
+	case sub == "role" && len(args) == 3:
+		a.setWorkspaceRole(ctx, msg, ws, m, args[1], strings.ToLower(args[2]))
+	case sub == "leave" && len(args) == 1:
+		a.leaveWorkspace(ctx, msg, ws, m)
+	default:
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, workspaceUsage))
+	}
+}
+
+func (a *App) cmdJoin(ctx context.Context, msg *tgbotapi.Message) {
+	code := strings.TrimSpace(msg.CommandArguments())
+	if code == "" {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Использование: /join <код>"))
+		return
+	}
+	ws, err := a.Store.JoinWorkspace(ctx, code, msg.From.ID)
+	switch {
+	case errors.Is(err, storage.ErrInviteInvalid):
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Код не найден или истёк. Попросите новый."))
+		return
+	case errors.Is(err, storage.ErrAlreadyInWorkspace):
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Вы уже в пространстве. Сначала: /workspace leave"))
+		return
+	case err != nil:
+		a.logger().Warn("join workspace failed", "err", err)
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Не удалось присоединиться к пространству."))
+		return
+	}
+	a.logger().Info("workspace joined", "workspace_id", ws.ID, "user_id", msg.From.ID)
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "✅ Вы в пространстве «"+ws.Name+"». Сохраняю в его Karakeep, ваши /server и /key не используются."))
+}
+
+func (a *App) createWorkspace(ctx context.Context, msg *tgbotapi.Message, name string) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Использование: /workspace create <название>"))
+		return
+	}
+	// The creator's own settings seed the workspace, so an already configured user doesn't have to repeat them.
+	u, err := a.Store.GetUser(ctx, msg.From.ID)
+	if err != nil {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Ошибка чтения настроек."))
+		return
+	}
+	p, _ := u.Profile("")
+	apiKey, _, err := a.Store.DecryptProfileAPIKey(ctx, p)
+	if err != nil {
+		a.logger().Warn("decrypt api key failed", "err", err)
+	}
+	ws, err := a.Store.CreateWorkspace(ctx, msg.From.ID, name, p.ServerBaseURL, apiKey)
+	if errors.Is(err, storage.ErrAlreadyInWorkspace) {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Вы уже в пространстве. Сначала: /workspace leave"))
+		return
+	}
+	if err != nil {
+		a.logger().Warn("create workspace failed", "err", err)
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Не удалось создать пространство."))
+		return
+	}
+	text := "✅ Пространство «" + ws.Name + "» создано, вы владелец.\nПригласить участников: /workspace invite"
+	if strings.TrimSpace(ws.ServerBaseURL) == "" || apiKey == "" {
+		text += "\n\nЗадайте общие настройки: /server https://<host> и /key <API_KEY>"
+	}
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, text))
+}
+
+func (a *App) sendWorkspaceInfo(ctx context.Context, msg *tgbotapi.Message, ws storage.Workspace, m storage.WorkspaceMember) {
+	members, err := a.Store.WorkspaceMembers(ctx, ws.ID)
+	if err != nil {
+		a.logger().Warn("list workspace members failed", "err", err)
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Ошибка чтения настроек."))
+		return
+	}
+	_, keySet, _ := a.Store.DecryptWorkspaceAPIKey(ctx, ws)
+	var sb strings.Builder
+	sb.WriteString("Пространство «" + ws.Name + "»\n")
+	sb.WriteString("Сервер: " + formatServer(storage.Credentials{ServerBaseURL: ws.ServerBaseURL}) + "\n")
+	if keySet {
+		sb.WriteString("Ключ: да\n")
+	} else {
+		sb.WriteString("Ключ: нет\n")
+	}
+	sb.WriteString("Ваша роль: " + roleName(m.Role) + "\n")
+	fmt.Fprintf(&sb, "\nУчастники (%d):\n", len(members))
+	for _, mm := range members {
+		line := fmt.Sprintf("• id=%d — %s", mm.TelegramUserID, roleName(mm.Role))
+		if mm.TelegramUserID == msg.From.ID {
+			line += " (вы)"
+		}
+		sb.WriteString(line + "\n")
+	}
+	sb.WriteString("\n" + workspaceUsage)
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, sb.String()))
+}
+
+func (a *App) inviteToWorkspace(ctx context.Context, msg *tgbotapi.Message, ws storage.Workspace, m storage.WorkspaceMember, role string) {
+	if !m.CanManage() {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Приглашать могут только владелец и админы."))
+		return
+	}
+	if role != storage.RoleMember && role != storage.RoleAdmin {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Использование: /workspace invite [admin]"))
+		return
+	}
+	code, expiresAt, err := a.Store.CreateWorkspaceInvite(ctx, ws.ID, msg.From.ID, role)
+	if err != nil {
+		a.logger().Warn("create workspace invite failed", "err", err)
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Не удалось создать приглашение."))
+		return
+	}
+	text := "Приглашение в «" + ws.Name + "» (" + roleName(role) + "), действует до " +
+		expiresAt.In(time.Local).Format("2006-01-02 15:04") + ".\n" +
+		"Перешлите коллеге, пусть отправит боту:\n\n/join " + code
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, text))
+}
+
+func (a *App) removeFromWorkspace(ctx context.Context, msg *tgbotapi.Message, ws storage.Workspace, m storage.WorkspaceMember, arg string) {
+	if !m.CanManage() {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Исключать участников могут только владелец и админы."))
+		return
+	}
+	target, ok := a.workspaceMember(ctx, msg, ws, arg)
+	if !ok {
+		return
+	}
+	switch {
+	case target.TelegramUserID == msg.From.ID:
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Чтобы выйти самому: /workspace leave"))
+		return
+	case target.Role == storage.RoleOwner, target.Role == storage.RoleAdmin && m.Role != storage.RoleOwner:
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Недостаточно прав, чтобы исключить этого участника."))
+		return
+	}
+	if _, err := a.Store.RemoveWorkspaceMember(ctx, ws.ID, target.TelegramUserID); err != nil {
+		a.logger().Warn("remove workspace member failed", "err", err)
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Не удалось исключить участника."))
+		return
+	}
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(target.TelegramUserID, "Вас исключили из пространства «"+ws.Name+"». Сохраняю с вашими /server и /key."))
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("✅ Участник id=%d исключён.", target.TelegramUserID)))
+}
+
+func (a *App) setWorkspaceRole(ctx context.Context, msg *tgbotapi.Message, ws storage.Workspace, m storage.WorkspaceMember, arg string, role string) {
+	if m.Role != storage.RoleOwner {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Менять роли может только владелец."))
+		return
+	}
+	if role != storage.RoleOwner && role != storage.RoleAdmin && role != storage.RoleMember {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, workspaceUsage))
+		return
+	}
+	target, ok := a.workspaceMember(ctx, msg, ws, arg)
+	if !ok {
+		return
+	}
+	if target.TelegramUserID == msg.From.ID {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Свою роль сменить нельзя: передайте владение другому участнику."))
+		return
+	}
+	text := fmt.Sprintf("✅ Участник id=%d теперь %s.", target.TelegramUserID, roleName(role))
+	if role == storage.RoleOwner {
+		// There is exactly one owner: handing over ownership makes the previous owner an admin.
+		if err := a.Store.TransferWorkspaceOwnership(ctx, ws.ID, msg.From.ID, target.TelegramUserID); err != nil {
+			a.logger().Warn("transfer workspace ownership failed", "err", err)
+			_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Не удалось передать владение. Вы остаётесь владельцем."))
+			return
+		}
+		text += " Вы теперь админ."
+	} else if _, err := a.Store.SetWorkspaceMemberRole(ctx, ws.ID, target.TelegramUserID, role); err != nil {
+		a.logger().Warn("set workspace role failed", "err", err)
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Не удалось сменить роль."))
+		return
+	}
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, text))
+}
+
+func (a *App) leaveWorkspace(ctx context.Context, msg *tgbotapi.Message, ws storage.Workspace, m storage.WorkspaceMember) {
+	if m.Role == storage.RoleOwner {
+		members, err := a.Store.WorkspaceMembers(ctx, ws.ID)
+		if err != nil {
+			_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Ошибка чтения настроек."))
+			return
+		}
+		if len(members) > 1 {
+			_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Вы владелец. Сначала передайте владение: /workspace role <id> owner"))
+			return
+		}
+		if err := a.Store.DeleteWorkspace(ctx, ws.ID); err != nil {
+			a.logger().Warn("delete workspace failed", "err", err)
+			_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Не удалось удалить пространство."))
+			return
+		}
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "✅ Пространство «"+ws.Name+"» удалено. Сохраняю с вашими /server и /key."))
+		return
+	}
+	if _, err := a.Store.RemoveWorkspaceMember(ctx, ws.ID, msg.From.ID); err != nil {
+		a.logger().Warn("leave workspace failed", "err", err)
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Не удалось выйти из пространства."))
+		return
+	}
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "✅ Вы вышли из пространства «"+ws.Name+"». Сохраняю с вашими /server и /key."))
+}
+
+// workspaceMember finds the member with the Telegram id given in arg, replying with an error if there is none.
+func (a *App) workspaceMember(ctx context.Context, msg *tgbotapi.Message, ws storage.Workspace, arg string) (storage.WorkspaceMember, bool) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(arg, "id="), 10, 64)
+	if err != nil {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Укажите числовой id участника (см. /workspace)."))
+		return storage.WorkspaceMember{}, false
+	}
+	members, err := a.Store.WorkspaceMembers(ctx, ws.ID)
+	if err != nil {
+		_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Ошибка чтения настроек."))
+		return storage.WorkspaceMember{}, false
+	}
+	for _, mm := range members {
+		if mm.TelegramUserID == id {
+			return mm, true
+		}
+	}
+	_, _ = a.Bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Участника id=%d нет в пространстве.", id)))
+	return storage.WorkspaceMember{}, false
+}
+
+func roleName(role string) string {
+	switch role {
+	case storage.RoleOwner:
+		return "владелец"
+	case storage.RoleAdmin:
+		return "админ"
+	default:
+		return "участник"
+	}
+}
@@ -17,7 +17,7 @@ const (
 type Result struct {
 	Kind Kind
 
-	// For KindBookmark
+	// For KindBookmark (Notes is also used by KindFile for forward attribution)
 	URL   string
 	Notes string
 
@@ -27,14 +27,71 @@ type Result struct {
 	URLs []string
 
 	HasMedia bool
+
+	// ListName comes from a "#list:<name>" directive; it overrides the user's default list.
+	ListName string
+
+	// Profile comes from a leading "@<profile>"; it overrides the user's active profile.
+	Profile string
+
+	// Tags from hashtag entities, without "#".
+	Tags []string
+
+	// Forward is the origin of a forwarded message; nil otherwise.
+	Forward *Forward
+}
+
+type Options struct {
+	// StripHashtags removes hashtag entities from the note text (they are still returned as Tags).
+	StripHashtags bool
+
+	// BotUsername (without "@"): mentions of the bot trigger a save in groups and are not content.
+	BotUsername string
+	// TriggerTag (without "#"): the group trigger hashtag is removed from the text and not returned as a tag.
+	TriggerTag string
+
+	// Profiles are the sender's profile names; a leading "@<profile>" selects one and is not content.
+	Profiles []string
 }
 
 func ClassifyMessage(msg *tgbotapi.Message) Result {
+	return ClassifyMessageWithOptions(msg, Options{})
+}
+
+func ClassifyMessageWithOptions(msg *tgbotapi.Message, opts Options) Result {
 	if msg == nil {
 		return Result{Kind: KindNote}
 	}
 
-	text := strings.TrimSpace(firstNonEmpty(msg.Text, msg.Caption))
+	orig, entities := messageTextEntities(msg)
+	// One pass over the original entities: their UTF-16 offsets are only valid for the untouched text.
+	raw := stripEntities(orig, entities, func(e tgbotapi.MessageEntity) bool {
+		return (opts.StripHashtags && isTagEntity(orig, e)) ||
+			isMentionOf(orig, e, opts.BotUsername) ||
+			isHashtag(orig, e, opts.TriggerTag)
+	})
+
+	// Directives are bot instructions, not content: strip them before deciding the kind.
+	profile, text := ExtractProfileDirective(strings.TrimSpace(raw), opts.Profiles)
+	listName, text := ExtractListDirective(text)
+
+	res := classify(msg, text)
+	if f, ok := ForwardFromMessage(msg); ok {
+		res = applyForward(res, f)
+	} else if f, ok := ChannelPostOrigin(msg); ok {
+		res = applyForward(res, f)
+	}
+	res.ListName = listName
+	res.Profile = profile
+	for _, tag := range ExtractHashtags(orig, entities) {
+		if !strings.EqualFold(tag, opts.TriggerTag) {
+			res.Tags = append(res.Tags, tag)
+		}
+	}
+	return res
+}
+
+func classify(msg *tgbotapi.Message, text string) Result {
 	urls := ExtractURLsFromMessage(msg)
 
 	hasMedia := messageHasMedia(msg)
@@ -66,13 +123,6 @@ func ClassifyMessage(msg *tgbotapi.Message) Result {
 	}
 }
 
-func firstNonEmpty(a, b string) string {
-	if strings.TrimSpace(a) != "" {
-		return a
-	}
-	return b
-}
-
 func messageHasMedia(msg *tgbotapi.Message) bool {
 	if msg == nil {
 		return false
@@ -0,0 +1,43 @@
+package classifier
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// listDirectiveRE matches "#list:<name>" anywhere in the text. Telegram only marks "#list" as a hashtag,
+// so we parse the directive from plain text rather than from entities.
+var listDirectiveRE = regexp.MustCompile(`(?i)(^|\s)#list:(\S+)`)
+
+// ExtractListDirective returns the list name from the first "#list:<name>" directive
+// and the text with all directives removed.
+func ExtractListDirective(text string) (name string, rest string) {
+	m := listDirectiveRE.FindStringSubmatch(text)
+	if m == nil {
+		return "", text
+	}
+	name = strings.TrimSpace(m[2])
+	rest = listDirectiveRE.ReplaceAllString(text, "$1")
+	return name, strings.TrimSpace(rest)
+}
+
+// ExtractProfileDirective returns the profile picked by a leading "@<name>" and the text without it.
+// Only names in profiles count (case-insensitive), so a message that starts with an ordinary @mention is left alone.
+func ExtractProfileDirective(text string, profiles []string) (name string, rest string) {
+	trimmed := strings.TrimSpace(text)
+	if !strings.HasPrefix(trimmed, "@") {
+		return "", text
+	}
+	end := strings.IndexFunc(trimmed, unicode.IsSpace)
+	if end < 0 {
+		end = len(trimmed)
+	}
+	candidate := strings.ToLower(trimmed[1:end])
+	for _, p := range profiles {
+		if strings.ToLower(p) == candidate {
+			return candidate, strings.TrimSpace(trimmed[end:])
+		}
+	}
+	return "", text
+}
@@ -0,0 +1,39 @@
+package classifier
+
+import "testing"
+
+func TestExtractListDirective(t *testing.T) {
+	name, rest := ExtractListDirective("https://example.com #list:read_later")
+	if name != "read_later" || rest != "https://example.com" {
+		t.Fatalf("got name=%q rest=%q", name, rest)
+	}
+
+	name, rest = ExtractListDirective("#LIST:Work\nsome note")
+	if name != "Work" || rest != "some note" {
+		t.Fatalf("got name=%q rest=%q", name, rest)
+	}
+
+	name, rest = ExtractListDirective("no directive here #listing")
+	if name != "" || rest != "no directive here #listing" {
+		t.Fatalf("got name=%q rest=%q", name, rest)
+	}
+}
+
+func TestExtractProfileDirective(t *testing.T) {
+	profiles := []string{"default", "work"}
+
+	name, rest := ExtractProfileDirective("@Work https://example.com", profiles)
+	if name != "work" || rest != "https://example.com" {
+		t.Fatalf("got name=%q rest=%q", name, rest)
+	}
+
+	name, rest = ExtractProfileDirective("@work\nsome note", profiles)
+	if name != "work" || rest != "some note" {
+		t.Fatalf("got name=%q rest=%q", name, rest)
+	}
+
+	name, rest = ExtractProfileDirective("@golang_news is great", profiles)
+	if name != "" || rest != "@golang_news is great" {
+		t.Fatalf("got name=%q rest=%q", name, rest)
+	}
+}
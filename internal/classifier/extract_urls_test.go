@@ -38,3 +38,16 @@ func TestExtractURLs_TextLink(t *testing.T) {
 	}
 }
 
+func TestExtractURLs_URL_Entity_BeforeEmoji(t *testing.T) {
+	// The entity ends exactly where a surrogate pair starts; the emoji is not part of the URL.
+	text := "https://example.com😊 tail"
+
+	entities := []tgbotapi.MessageEntity{
+		{Type: "url", Offset: 0, Length: len("https://example.com")},
+	}
+
+	urls := ExtractURLs(text, entities)
+	if len(urls) != 1 || urls[0] != "https://example.com" {
+		t.Fatalf("unexpected urls: %#v", urls)
+	}
+}
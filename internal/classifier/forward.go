@@ -0,0 +1,152 @@
+package classifier
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Forward describes where a forwarded message originally came from.
+type Forward struct {
+	// From is the channel/chat title or the original sender's name.
+	From string
+	// Username is the public @username of the channel or user, without "@".
+	Username string
+	// Author is the post signature in channels.
+	Author string
+	Date   time.Time
+
+	// Permalink is the t.me link to the original channel post; empty when it can't be built.
+	Permalink string
+
+	// Direct is set for posts read straight from a bound channel rather than forwarded to the bot.
+	Direct bool
+}
+
+// ForwardFromMessage extracts the origin of a forwarded message. ok is false for regular messages.
+func ForwardFromMessage(msg *tgbotapi.Message) (Forward, bool) {
+	if msg == nil {
+		return Forward{}, false
+	}
+	var f Forward
+	switch {
+	case msg.ForwardFromChat != nil:
+		c := msg.ForwardFromChat
+		f.From = strings.TrimSpace(c.Title)
+		f.Username = c.UserName
+		f.Author = strings.TrimSpace(msg.ForwardSignature)
+		if msg.ForwardFromMessageID != 0 {
+			f.Permalink = channelPermalink(c, msg.ForwardFromMessageID)
+		}
+	case msg.ForwardFrom != nil:
+		u := msg.ForwardFrom
+		f.From = strings.TrimSpace(u.FirstName + " " + u.LastName)
+		f.Username = u.UserName
+	case msg.ForwardSenderName != "":
+		// The sender hides their account: only the display name is known.
+		f.From = strings.TrimSpace(msg.ForwardSenderName)
+	default:
+		return Forward{}, false
+	}
+	if msg.ForwardDate != 0 {
+		f.Date = time.Unix(int64(msg.ForwardDate), 0).UTC()
+	}
+	return f, true
+}
+
+// ChannelPostOrigin describes a post the bot received as a channel member. ok is false outside channels.
+func ChannelPostOrigin(msg *tgbotapi.Message) (Forward, bool) {
+	if msg == nil || msg.Chat == nil || !msg.Chat.IsChannel() {
+		return Forward{}, false
+	}
+	f := Forward{
+		From:      strings.TrimSpace(msg.Chat.Title),
+		Username:  msg.Chat.UserName,
+		Author:    strings.TrimSpace(msg.AuthorSignature),
+		Permalink: channelPermalink(msg.Chat, msg.MessageID),
+		Direct:    true,
+	}
+	if msg.Date != 0 {
+		f.Date = time.Unix(int64(msg.Date), 0).UTC()
+	}
+	return f, true
+}
+
+// channelPermalink builds https://t.me/<username>/<id> for public channels and
+// https://t.me/c/<internal id>/<id> for private ones (the latter only opens for members).
+func channelPermalink(c *tgbotapi.Chat, messageID int) string {
+	if c.UserName != "" {
+		return fmt.Sprintf("https://t.me/%s/%d", c.UserName, messageID)
+	}
+	// Supergroup/channel ids are -100<internal id>.
+	const channelIDPrefix = -1000000000000
+	if c.ID < channelIDPrefix {
+		return "https://t.me/c/" + strconv.FormatInt(channelIDPrefix-c.ID, 10) + "/" + strconv.Itoa(messageID)
+	}
+	return ""
+}
+
+// Attribution renders the origin as notes text, e.g.
+// "Forwarded from Go Weekly (@goweekly), author: Rob, 2024-05-01 10:00 UTC" plus the permalink on its own line.
+// Direct channel posts read "Posted in …" instead.
+func (f Forward) Attribution(withLink bool) string {
+	var sb strings.Builder
+	if f.Direct {
+		sb.WriteString("Posted in ")
+	} else {
+		sb.WriteString("Forwarded from ")
+	}
+	switch {
+	case f.From != "" && f.Username != "":
+		sb.WriteString(f.From + " (@" + f.Username + ")")
+	case f.From != "":
+		sb.WriteString(f.From)
+	case f.Username != "":
+		sb.WriteString("@" + f.Username)
+	default:
+		sb.WriteString("Telegram")
+	}
+	if f.Author != "" {
+		sb.WriteString(", author: " + f.Author)
+	}
+	if !f.Date.IsZero() {
+		sb.WriteString(", " + f.Date.Format("2006-01-02 15:04") + " UTC")
+	}
+	if withLink && f.Permalink != "" {
+		sb.WriteString("\n" + f.Permalink)
+	}
+	return sb.String()
+}
+
+// applyForward attributes a forwarded message to its origin.
+// A channel post without its own link becomes a bookmark of the post's permalink;
+// otherwise the attribution (with the permalink) is appended to the notes.
+func applyForward(res Result, f Forward) Result {
+	switch res.Kind {
+	case KindNote:
+		if !res.HasMedia && len(res.URLs) == 0 && f.Permalink != "" {
+			return Result{Kind: KindBookmark, URL: f.Permalink, Notes: joinBlocks(res.Text, f.Attribution(false)), Forward: &f}
+		}
+		res.Text = joinBlocks(res.Text, f.Attribution(true))
+	case KindBookmark:
+		res.Notes = joinBlocks(res.Notes, f.Attribution(true))
+	case KindFile:
+		res.Notes = f.Attribution(true)
+	}
+	res.Forward = &f
+	return res
+}
+
+func joinBlocks(a, b string) string {
+	a, b = strings.TrimSpace(a), strings.TrimSpace(b)
+	switch {
+	case a == "":
+		return b
+	case b == "":
+		return a
+	}
+	return a + "\n\n" + b
+}
@@ -0,0 +1,75 @@
+package classifier
+
+import (
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestClassifyForwardedChannelPost_WithoutLink(t *testing.T) {
+	msg := &tgbotapi.Message{
+		Text:                 "Big news today",
+		ForwardFromChat:      &tgbotapi.Chat{ID: -1001234567890, Type: "channel", Title: "Go Weekly", UserName: "goweekly"},
+		ForwardFromMessageID: 42,
+		ForwardSignature:     "Rob",
+		ForwardDate:          1714557600, // 2024-05-01 10:00 UTC
+	}
+
+	res := ClassifyMessage(msg)
+	if res.Kind != KindBookmark || res.URL != "https://t.me/goweekly/42" {
+		t.Fatalf("expected permalink bookmark, got kind=%s url=%q", res.Kind, res.URL)
+	}
+	want := "Big news today\n\nForwarded from Go Weekly (@goweekly), author: Rob, 2024-05-01 10:00 UTC"
+	if res.Notes != want {
+		t.Fatalf("unexpected notes: %q", res.Notes)
+	}
+}
+
+func TestClassifyForwardedChannelPost_WithOwnLink(t *testing.T) {
+	text := "Read https://example.com/post"
+	msg := &tgbotapi.Message{
+		Text:                 text,
+		Entities:             []tgbotapi.MessageEntity{{Type: "url", Offset: 5, Length: 24}},
+		ForwardFromChat:      &tgbotapi.Chat{ID: -1001234567890, Type: "channel", Title: "Private"},
+		ForwardFromMessageID: 7,
+	}
+
+	res := ClassifyMessage(msg)
+	if res.Kind != KindBookmark || res.URL != "https://example.com/post" {
+		t.Fatalf("expected bookmark of the post link, got kind=%s url=%q", res.Kind, res.URL)
+	}
+	want := text + "\n\nForwarded from Private\nhttps://t.me/c/1234567890/7"
+	if res.Notes != want {
+		t.Fatalf("unexpected notes: %q", res.Notes)
+	}
+}
+
+func TestClassifyForwardedFromHiddenUser(t *testing.T) {
+	msg := &tgbotapi.Message{Text: "hello", ForwardSenderName: "Anna"}
+
+	res := ClassifyMessage(msg)
+	if res.Kind != KindNote || res.Text != "hello\n\nForwarded from Anna" {
+		t.Fatalf("unexpected result: kind=%s text=%q", res.Kind, res.Text)
+	}
+}
+
+func TestClassifyChannelPost(t *testing.T) {
+	text := "Worth a read https://example.com/a"
+	msg := &tgbotapi.Message{
+		MessageID:       15,
+		Chat:            &tgbotapi.Chat{ID: -1001234567890, Type: "channel", Title: "Team links", UserName: "teamlinks"},
+		Date:            1714557600,
+		AuthorSignature: "Kim",
+		Text:            text,
+		Entities:        []tgbotapi.MessageEntity{{Type: "url", Offset: 13, Length: 21}},
+	}
+
+	res := ClassifyMessage(msg)
+	if res.Kind != KindBookmark || res.URL != "https://example.com/a" {
+		t.Fatalf("expected bookmark of the post link, got kind=%s url=%q", res.Kind, res.URL)
+	}
+	want := text + "\n\nPosted in Team links (@teamlinks), author: Kim, 2024-05-01 10:00 UTC\nhttps://t.me/teamlinks/15"
+	if res.Notes != want {
+		t.Fatalf("unexpected notes: %q", res.Notes)
+	}
+}
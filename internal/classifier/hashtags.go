@@ -0,0 +1,135 @@
+package classifier
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf16"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var repeatedBlanksRE = regexp.MustCompile(`[ \t]{2,}`)
+
+// ExtractHashtags returns hashtag entities as tag names (without "#"), deduplicated case-insensitively.
+// "#list:<name>" directives are not tags and are skipped.
+func ExtractHashtags(text string, entities []tgbotapi.MessageEntity) []string {
+	var out []string
+	seen := make(map[string]struct{}, 4)
+	for _, e := range entities {
+		if !isTagEntity(text, e) {
+			continue
+		}
+		tag := strings.TrimPrefix(strings.TrimSpace(SliceByUTF16(text, e.Offset, e.Length)), "#")
+		if tag == "" {
+			continue
+		}
+		key := strings.ToLower(tag)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		out = append(out, tag)
+	}
+	return out
+}
+
+// StripHashtags removes hashtag entities from text, using the entities' UTF-16 offsets.
+func StripHashtags(text string, entities []tgbotapi.MessageEntity) string {
+	return stripEntities(text, entities, func(e tgbotapi.MessageEntity) bool {
+		return isTagEntity(text, e)
+	})
+}
+
+// stripEntities removes the entities matched by drop and tidies the whitespace left behind.
+func stripEntities(text string, entities []tgbotapi.MessageEntity, match func(tgbotapi.MessageEntity) bool) string {
+	units := utf16.Encode([]rune(text))
+	drop := make([]bool, len(units))
+	found := false
+	for _, e := range entities {
+		if !match(e) {
+			continue
+		}
+		for i := e.Offset; i < e.Offset+e.Length && i < len(units); i++ {
+			if i >= 0 {
+				drop[i] = true
+				found = true
+			}
+		}
+	}
+	if !found {
+		return text
+	}
+	kept := make([]uint16, 0, len(units))
+	for i, u := range units {
+		if !drop[i] {
+			kept = append(kept, u)
+		}
+	}
+	lines := strings.Split(string(utf16.Decode(kept)), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(repeatedBlanksRE.ReplaceAllString(l, " "), " \t")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// HashtagsFromMessage combines message text+entities or caption+caption_entities depending on what exists.
+func HashtagsFromMessage(msg *tgbotapi.Message) []string {
+	text, entities := messageTextEntities(msg)
+	return ExtractHashtags(text, entities)
+}
+
+func isTagEntity(text string, e tgbotapi.MessageEntity) bool {
+	if e.Type != "hashtag" {
+		return false
+	}
+	// Telegram marks "#list" in "#list:work" as a hashtag; that's our directive, not a tag.
+	return SliceByUTF16(text, e.Offset+e.Length, 1) != ":"
+}
+
+// isMentionOf reports whether e is an "@username" mention of the given user (case-insensitive).
+func isMentionOf(text string, e tgbotapi.MessageEntity, username string) bool {
+	if e.Type != "mention" || username == "" {
+		return false
+	}
+	return strings.EqualFold(strings.TrimPrefix(SliceByUTF16(text, e.Offset, e.Length), "@"), username)
+}
+
+// isHashtag reports whether e is the hashtag "#tag" (case-insensitive).
+func isHashtag(text string, e tgbotapi.MessageEntity, tag string) bool {
+	if tag == "" || !isTagEntity(text, e) {
+		return false
+	}
+	return strings.EqualFold(strings.TrimPrefix(SliceByUTF16(text, e.Offset, e.Length), "#"), tag)
+}
+
+// MentionsUser reports whether the message text/caption mentions "@username".
+func MentionsUser(msg *tgbotapi.Message, username string) bool {
+	text, entities := messageTextEntities(msg)
+	for _, e := range entities {
+		if isMentionOf(text, e, username) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasHashtag reports whether the message text/caption carries "#tag".
+func HasHashtag(msg *tgbotapi.Message, tag string) bool {
+	text, entities := messageTextEntities(msg)
+	for _, e := range entities {
+		if isHashtag(text, e, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func messageTextEntities(msg *tgbotapi.Message) (string, []tgbotapi.MessageEntity) {
+	if msg == nil {
+		return "", nil
+	}
+	if strings.TrimSpace(msg.Text) != "" {
+		return msg.Text, msg.Entities
+	}
+	return msg.Caption, msg.CaptionEntities
+}
@@ -0,0 +1,51 @@
+package classifier
+
+import (
+	"reflect"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestExtractHashtags_UTF16Offsets(t *testing.T) {
+	// "😊 " is 3 UTF-16 code units.
+	text := "😊 read this #golang #GoLang #list:work"
+	entities := []tgbotapi.MessageEntity{
+		{Type: "hashtag", Offset: 13, Length: 7},
+		{Type: "hashtag", Offset: 21, Length: 7},
+		{Type: "hashtag", Offset: 29, Length: 5},
+	}
+
+	tags := ExtractHashtags(text, entities)
+	if !reflect.DeepEqual(tags, []string{"golang"}) {
+		t.Fatalf("unexpected tags: %#v", tags)
+	}
+
+	got := StripHashtags(text, entities)
+	if got != "😊 read this #list:work" {
+		t.Fatalf("unexpected stripped text: %q", got)
+	}
+}
+
+func TestClassifyGroupTriggersAreNotContent(t *testing.T) {
+	text := "@KeepBot look #save #go"
+	msg := &tgbotapi.Message{
+		Text: text,
+		Entities: []tgbotapi.MessageEntity{
+			{Type: "mention", Offset: 0, Length: 8},
+			{Type: "hashtag", Offset: 14, Length: 5},
+			{Type: "hashtag", Offset: 20, Length: 3},
+		},
+	}
+	if !MentionsUser(msg, "keepbot") || !HasHashtag(msg, "SAVE") {
+		t.Fatalf("expected mention and trigger tag to be detected")
+	}
+
+	res := ClassifyMessageWithOptions(msg, Options{BotUsername: "keepbot", TriggerTag: "save"})
+	if res.Text != "look #go" {
+		t.Fatalf("unexpected text: %q", res.Text)
+	}
+	if !reflect.DeepEqual(res.Tags, []string{"go"}) {
+		t.Fatalf("unexpected tags: %#v", res.Tags)
+	}
+}
@@ -34,13 +34,7 @@ func SliceByUTF16(s string, off, length int) string {
 		curCU += len(utf16.Encode([]rune{r}))
 	}
 
-	// If start/end are after the last rune, they should clamp to len(runes).
-	if startCU >= curCU {
-		startRI = len(runes)
-	}
-	if endCU >= curCU {
-		endRI = len(runes)
-	}
+	// If start/end are after the last rune, the loop never set them and they stay clamped to len(runes).
 	if startRI > endRI {
 		startRI = endRI
 	}
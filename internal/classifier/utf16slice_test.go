@@ -16,3 +16,25 @@ func TestSliceByUTF16(t *testing.T) {
 	}
 }
 
+func TestSliceByUTF16_EndsAtBoundary(t *testing.T) {
+	s := "a😊b😊"
+
+	// Entity ending right after a surrogate pair must not run on to the end of the string.
+	if got := SliceByUTF16(s, 0, 3); got != "a😊" {
+		t.Fatalf("off=0 len=3: got %q", got)
+	}
+	if got := SliceByUTF16(s, 1, 2); got != "😊" {
+		t.Fatalf("off=1 len=2: got %q", got)
+	}
+	// Entity ending exactly at the end of the string.
+	if got := SliceByUTF16(s, 4, 2); got != "😊" {
+		t.Fatalf("off=4 len=2: got %q", got)
+	}
+	if got := SliceByUTF16(s, 0, 6); got != s {
+		t.Fatalf("off=0 len=6: got %q", got)
+	}
+	// Past the end is clamped.
+	if got := SliceByUTF16(s, 6, 1); got != "" {
+		t.Fatalf("off=6 len=1: got %q", got)
+	}
+}
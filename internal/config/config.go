@@ -3,31 +3,98 @@ package config
 import (
 	"errors"
 	"fmt"
+	"net/netip"
 	"os"
 	"strconv"
 	"strings"
+	"time"
+)
+
+const (
+	TelegramModeWebhook = "webhook"
+	TelegramModePolling = "polling"
 )
 
 type Config struct {
 	ListenAddr string
 
 	TelegramBotToken     string
+	// TelegramMode is "webhook" (default) or "polling" (getUpdates, no public HTTPS needed).
+	TelegramMode         string
+	// TelegramWebhookURL, if set in webhook mode, is registered with Telegram on startup.
+	TelegramWebhookURL   string
 	TelegramWebhookPath  string
 	TelegramWebhookSecret string
 	TelegramDebug        bool
 
 	DBPath          string
 	APIKeyMasterKey string
+	// APIKeyMasterKeyVersion tags ciphertexts sealed with APIKeyMasterKey; bump it when rotating.
+	APIKeyMasterKeyVersion int
+	// APIKeyMasterKeyPrevious is the key being rotated away from; it is only used for decryption.
+	APIKeyMasterKeyPrevious        string
+	APIKeyMasterKeyPreviousVersion int
+
+	// Operator SSRF policy for Karakeep servers (security.Policy); host lists are comma-separated patterns.
+	KarakeepAllowedHosts []string
+	KarakeepDeniedHosts  []string
+	KarakeepPrivateHosts []string
+	KarakeepAllowedCIDRs []netip.Prefix
+	KarakeepDeniedCIDRs  []netip.Prefix
+	KarakeepHTTPHosts    []string
+
+	// AccessMode is "open" (default), "allowlist" or "invite" (see app.AccessPolicy).
+	AccessMode string
+	// BotAdmins are Telegram user ids allowed to manage access with /approve, /revoke and /users.
+	BotAdmins []int64
+	// AllowedUsers are ids or @usernames admitted without /approve.
+	AllowedUsers []string
+
+	// StripHashtags removes #tags from saved note text (tags are attached in Karakeep regardless).
+	StripHashtags bool
+
+	// JobWorkers is the number of goroutines draining the persistent save queue.
+	JobWorkers     int
+	JobMaxAttempts int
+	// JobRetention is how long done and failed jobs (with their message payloads) are kept.
+	JobRetention time.Duration
+
+	// Rate limits (token buckets, see app.Limits); a zero rate disables the limit.
+	UserRatePerMinute   float64
+	UserRateBurst       int
+	ServerRatePerMinute float64
+	ServerRateBurst     int
+
+	// UpdateWorkers handle Telegram updates; UpdateQueueSize updates may wait for them before
+	// the webhook asks Telegram to redeliver (the poller waits instead). The queue is split evenly
+	// between the workers, so a chat can be refused once its worker's part is full.
+	UpdateWorkers   int
+	UpdateQueueSize int
+	// InteractiveWorkers run handlers waiting on Karakeep for a user's answer (search, buttons,
+	// /server and /key checks) beside the update workers, so a slow server doesn't stall other chats.
+	InteractiveWorkers int
+	// ShutdownTimeout bounds how long a stopping bot finishes queued updates and running saves.
+	ShutdownTimeout time.Duration
 }
 
 func FromEnv() (Config, error) {
 	var cfg Config
+	var err error
 
 	cfg.ListenAddr = envString("LISTEN_ADDR", ":8080")
+	cfg.TelegramMode = strings.ToLower(envString("TELEGRAM_MODE", TelegramModeWebhook))
+	cfg.TelegramWebhookURL = envString("TELEGRAM_WEBHOOK_URL", "")
 	cfg.TelegramWebhookPath = envString("TELEGRAM_WEBHOOK_PATH", "/telegram/webhook")
 	cfg.TelegramWebhookSecret = envString("TELEGRAM_WEBHOOK_SECRET", "")
 	cfg.DBPath = envString("DB_PATH", "./data/bot.sqlite")
 	cfg.APIKeyMasterKey = strings.TrimSpace(os.Getenv("API_KEY_MASTER_KEY"))
+	if cfg.APIKeyMasterKeyVersion, err = envInt("API_KEY_MASTER_KEY_VERSION", 1); err != nil {
+		return Config{}, err
+	}
+	cfg.APIKeyMasterKeyPrevious = strings.TrimSpace(os.Getenv("API_KEY_MASTER_KEY_PREVIOUS"))
+	if cfg.APIKeyMasterKeyPreviousVersion, err = envInt("API_KEY_MASTER_KEY_PREVIOUS_VERSION", cfg.APIKeyMasterKeyVersion-1); err != nil {
+		return Config{}, err
+	}
 
 	cfg.TelegramBotToken = strings.TrimSpace(os.Getenv("TELEGRAM_BOT_TOKEN"))
 	if cfg.TelegramBotToken == "" {
@@ -36,6 +103,66 @@ func FromEnv() (Config, error) {
 
 	cfg.TelegramDebug = envBool("TELEGRAM_DEBUG", false)
 
+	cfg.KarakeepAllowedHosts = envList("KARAKEEP_ALLOWED_HOSTS")
+	cfg.KarakeepDeniedHosts = envList("KARAKEEP_DENIED_HOSTS")
+	cfg.KarakeepHTTPHosts = envList("KARAKEEP_HTTP_HOSTS")
+	cfg.KarakeepPrivateHosts = envList("KARAKEEP_PRIVATE_HOSTS")
+	for _, h := range cfg.KarakeepPrivateHosts {
+		if strings.Contains(h, "*") {
+			return Config{}, fmt.Errorf("KARAKEEP_PRIVATE_HOSTS: wildcards are not allowed: %q", h)
+		}
+	}
+	if cfg.KarakeepAllowedCIDRs, err = envPrefixes("KARAKEEP_ALLOWED_CIDRS"); err != nil {
+		return Config{}, err
+	}
+	if cfg.KarakeepDeniedCIDRs, err = envPrefixes("KARAKEEP_DENIED_CIDRS"); err != nil {
+		return Config{}, err
+	}
+
+	cfg.AccessMode = strings.ToLower(envString("ACCESS_MODE", "open"))
+	if cfg.BotAdmins, err = envInt64s("BOT_ADMINS"); err != nil {
+		return Config{}, err
+	}
+	cfg.AllowedUsers = envList("ALLOWED_USERS")
+
+	cfg.StripHashtags = envBool("STRIP_HASHTAGS", false)
+
+	if cfg.JobWorkers, err = envInt("JOB_WORKERS", 4); err != nil {
+		return Config{}, err
+	}
+	if cfg.JobMaxAttempts, err = envInt("JOB_MAX_ATTEMPTS", 5); err != nil {
+		return Config{}, err
+	}
+	if cfg.JobRetention, err = envDuration("JOB_RETENTION", 7*24*time.Hour); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.UserRatePerMinute, err = envFloat("USER_RATE_PER_MINUTE", 20); err != nil {
+		return Config{}, err
+	}
+	if cfg.UserRateBurst, err = envInt("USER_RATE_BURST", 10); err != nil {
+		return Config{}, err
+	}
+	if cfg.ServerRatePerMinute, err = envFloat("SERVER_RATE_PER_MINUTE", 60); err != nil {
+		return Config{}, err
+	}
+	if cfg.ServerRateBurst, err = envInt("SERVER_RATE_BURST", 20); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.UpdateWorkers, err = envInt("UPDATE_WORKERS", 8); err != nil {
+		return Config{}, err
+	}
+	if cfg.UpdateQueueSize, err = envInt("UPDATE_QUEUE_SIZE", 1000); err != nil {
+		return Config{}, err
+	}
+	if cfg.InteractiveWorkers, err = envInt("INTERACTIVE_WORKERS", 16); err != nil {
+		return Config{}, err
+	}
+	if cfg.ShutdownTimeout, err = envDuration("SHUTDOWN_TIMEOUT", 25*time.Second); err != nil {
+		return Config{}, err
+	}
+
 	return cfg, nil
 }
 
@@ -60,16 +187,141 @@ func envBool(key string, def bool) bool {
 	return b
 }
 
+// envList splits a comma-separated variable, dropping empty items.
+func envList(key string) []string {
+	var out []string
+	for _, s := range strings.Split(os.Getenv(key), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// envPrefixes parses a comma-separated list of CIDRs; a bare IP means just that address.
+func envPrefixes(key string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, s := range envList(key) {
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid ip or cidr %q", key, s)
+			}
+			out = append(out, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid cidr %q", key, s)
+		}
+		out = append(out, p.Masked())
+	}
+	return out, nil
+}
+
+// envInt64s parses a comma-separated list of integers, e.g. Telegram user ids.
+func envInt64s(key string) ([]int64, error) {
+	var out []int64
+	for _, s := range envList(key) {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid id %q", key, s)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+func envFloat(key string, def float64) (float64, error) {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid number %q", key, v)
+	}
+	return f, nil
+}
+
+func envDuration(key string, def time.Duration) (time.Duration, error) {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid duration %q (e.g. 30s)", key, v)
+	}
+	return d, nil
+}
+
+func envInt(key string, def int) (int, error) {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid integer %q", key, v)
+	}
+	return n, nil
+}
+
 func (c Config) Validate() error {
 	if c.TelegramBotToken == "" {
 		return errors.New("telegram bot token is empty")
 	}
+	if c.TelegramMode != TelegramModeWebhook && c.TelegramMode != TelegramModePolling {
+		return fmt.Errorf("TELEGRAM_MODE must be %q or %q: %q", TelegramModeWebhook, TelegramModePolling, c.TelegramMode)
+	}
+	if c.TelegramWebhookURL != "" && !strings.HasPrefix(c.TelegramWebhookURL, "https://") {
+		return fmt.Errorf("TELEGRAM_WEBHOOK_URL must be https:// : %q", c.TelegramWebhookURL)
+	}
 	if !strings.HasPrefix(c.TelegramWebhookPath, "/") {
 		return fmt.Errorf("TELEGRAM_WEBHOOK_PATH must start with '/': %q", c.TelegramWebhookPath)
 	}
 	if strings.TrimSpace(c.APIKeyMasterKey) == "" {
 		return errors.New("API_KEY_MASTER_KEY is required (used to encrypt api_key in SQLite)")
 	}
+	if c.APIKeyMasterKeyVersion <= 0 {
+		return fmt.Errorf("API_KEY_MASTER_KEY_VERSION must be positive: %d", c.APIKeyMasterKeyVersion)
+	}
+	if c.APIKeyMasterKeyPrevious != "" && (c.APIKeyMasterKeyPreviousVersion <= 0 || c.APIKeyMasterKeyPreviousVersion == c.APIKeyMasterKeyVersion) {
+		return fmt.Errorf("API_KEY_MASTER_KEY_PREVIOUS_VERSION must be positive and differ from API_KEY_MASTER_KEY_VERSION: %d", c.APIKeyMasterKeyPreviousVersion)
+	}
+	switch c.AccessMode {
+	case "open", "allowlist", "invite":
+	default:
+		return fmt.Errorf("ACCESS_MODE must be open, allowlist or invite: %q", c.AccessMode)
+	}
+	if c.AccessMode != "open" && len(c.BotAdmins) == 0 && len(c.AllowedUsers) == 0 {
+		return fmt.Errorf("ACCESS_MODE=%s needs BOT_ADMINS or ALLOWED_USERS, otherwise nobody can use the bot", c.AccessMode)
+	}
+	if c.JobWorkers <= 0 {
+		return fmt.Errorf("JOB_WORKERS must be positive: %d", c.JobWorkers)
+	}
+	if c.JobMaxAttempts <= 0 {
+		return fmt.Errorf("JOB_MAX_ATTEMPTS must be positive: %d", c.JobMaxAttempts)
+	}
+	if c.UserRatePerMinute < 0 || c.ServerRatePerMinute < 0 {
+		return fmt.Errorf("USER_RATE_PER_MINUTE and SERVER_RATE_PER_MINUTE must not be negative: %g, %g", c.UserRatePerMinute, c.ServerRatePerMinute)
+	}
+	if c.UserRateBurst <= 0 || c.ServerRateBurst <= 0 {
+		return fmt.Errorf("USER_RATE_BURST and SERVER_RATE_BURST must be positive: %d, %d", c.UserRateBurst, c.ServerRateBurst)
+	}
+	if c.JobRetention <= 0 {
+		return fmt.Errorf("JOB_RETENTION must be positive: %s", c.JobRetention)
+	}
+	if c.UpdateWorkers <= 0 || c.UpdateQueueSize <= 0 {
+		return fmt.Errorf("UPDATE_WORKERS and UPDATE_QUEUE_SIZE must be positive: %d, %d", c.UpdateWorkers, c.UpdateQueueSize)
+	}
+	if c.InteractiveWorkers <= 0 {
+		return fmt.Errorf("INTERACTIVE_WORKERS must be positive: %d", c.InteractiveWorkers)
+	}
+	if c.ShutdownTimeout <= 0 {
+		return fmt.Errorf("SHUTDOWN_TIMEOUT must be positive: %s", c.ShutdownTimeout)
+	}
 	return nil
 }
 
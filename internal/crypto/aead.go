@@ -50,17 +50,19 @@ func NewAEAD(key Key) (*AEAD, error) {
 	return &AEAD{aead: a}, nil
 }
 
-func (a *AEAD) Encrypt(plaintext []byte) (nonce []byte, ciphertext []byte, err error) {
+// Encrypt seals plaintext; additionalData (may be nil) is authenticated but not stored,
+// so Decrypt only succeeds with the same additionalData.
+func (a *AEAD) Encrypt(plaintext, additionalData []byte) (nonce []byte, ciphertext []byte, err error) {
 	nonce = make([]byte, a.aead.NonceSize())
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return nil, nil, fmt.Errorf("nonce: %w", err)
 	}
-	ciphertext = a.aead.Seal(nil, nonce, plaintext, nil)
+	ciphertext = a.aead.Seal(nil, nonce, plaintext, additionalData)
 	return nonce, ciphertext, nil
 }
 
-func (a *AEAD) Decrypt(nonce, ciphertext []byte) ([]byte, error) {
-	pt, err := a.aead.Open(nil, nonce, ciphertext, nil)
+func (a *AEAD) Decrypt(nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	pt, err := a.aead.Open(nil, nonce, ciphertext, additionalData)
 	if err != nil {
 		return nil, fmt.Errorf("decrypt: %w", err)
 	}
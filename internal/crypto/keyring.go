@@ -0,0 +1,70 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Keyring holds several versioned master keys: new data is sealed with the primary one,
+// older versions stay readable until everything is re-encrypted.
+type Keyring struct {
+	primary int
+	keys    map[int]*AEAD
+}
+
+// NewKeyring creates a keyring whose primary (encrypting) key is version.
+func NewKeyring(version int, primary *AEAD) (*Keyring, error) {
+	if version <= 0 {
+		return nil, fmt.Errorf("key version must be positive: %d", version)
+	}
+	return &Keyring{primary: version, keys: map[int]*AEAD{version: primary}}, nil
+}
+
+// Add registers an older key that is only used for decryption.
+func (k *Keyring) Add(version int, a *AEAD) error {
+	if version <= 0 {
+		return fmt.Errorf("key version must be positive: %d", version)
+	}
+	if _, ok := k.keys[version]; ok {
+		return fmt.Errorf("duplicate key version %d", version)
+	}
+	k.keys[version] = a
+	return nil
+}
+
+// Primary is the version new ciphertexts are sealed with.
+func (k *Keyring) Primary() int { return k.primary }
+
+func (k *Keyring) Encrypt(plaintext, additionalData []byte) (version int, nonce []byte, ciphertext []byte, err error) {
+	nonce, ciphertext, err = k.keys[k.primary].Encrypt(plaintext, additionalData)
+	return k.primary, nonce, ciphertext, err
+}
+
+// Decrypt opens a ciphertext sealed with the given key version. Version 0 marks data written
+// before keys were versioned: every key is tried, the primary one first.
+func (k *Keyring) Decrypt(version int, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if version != 0 {
+		a, ok := k.keys[version]
+		if !ok {
+			return nil, fmt.Errorf("decrypt: unknown key version %d", version)
+		}
+		return a.Decrypt(nonce, ciphertext, additionalData)
+	}
+	versions := make([]int, 0, len(k.keys))
+	for v := range k.keys {
+		if v != k.primary {
+			versions = append(versions, v)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(versions)))
+	var errs []error
+	for _, v := range append([]int{k.primary}, versions...) {
+		pt, err := k.keys[v].Decrypt(nonce, ciphertext, additionalData)
+		if err == nil {
+			return pt, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
@@ -0,0 +1,79 @@
+package crypto
+
+import "testing"
+
+func testAEAD(t *testing.T, secret string) *AEAD {
+	t.Helper()
+	k, err := DeriveKeyFromSecret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewAEAD(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestKeyringDecrypt(t *testing.T) {
+	old, cur := testAEAD(t, "old secret"), testAEAD(t, "current secret")
+	k, err := NewKeyring(2, cur)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Add(1, old); err != nil {
+		t.Fatal(err)
+	}
+	ad := []byte("user:1")
+
+	version, nonce, ct, err := k.Encrypt([]byte("secret"), ad)
+	if err != nil || version != 2 {
+		t.Fatalf("Encrypt = version %d, %v; want 2", version, err)
+	}
+	if pt, err := k.Decrypt(2, nonce, ct, ad); err != nil || string(pt) != "secret" {
+		t.Errorf("Decrypt(2) = %q, %v", pt, err)
+	}
+	if _, err := k.Decrypt(1, nonce, ct, ad); err == nil {
+		t.Error("Decrypt with the wrong version succeeded")
+	}
+	if _, err := k.Decrypt(3, nonce, ct, ad); err == nil {
+		t.Error("Decrypt with an unknown version succeeded")
+	}
+	if _, err := k.Decrypt(2, nonce, ct, []byte("user:2")); err == nil {
+		t.Error("Decrypt with other associated data succeeded")
+	}
+
+	// Version 0 (written before versioning) is opened by whichever key sealed it.
+	oldNonce, oldCT, err := old.Encrypt([]byte("legacy"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pt, err := k.Decrypt(0, oldNonce, oldCT, nil); err != nil || string(pt) != "legacy" {
+		t.Errorf("Decrypt(0) of an old-key ciphertext = %q, %v", pt, err)
+	}
+	if pt, err := k.Decrypt(0, nonce, ct, ad); err != nil || string(pt) != "secret" {
+		t.Errorf("Decrypt(0) of a current-key ciphertext = %q, %v", pt, err)
+	}
+	stranger := testAEAD(t, "unknown secret")
+	sNonce, sCT, _ := stranger.Encrypt([]byte("x"), nil)
+	if _, err := k.Decrypt(0, sNonce, sCT, nil); err == nil {
+		t.Error("Decrypt(0) with no matching key succeeded")
+	}
+}
+
+func TestKeyringVersions(t *testing.T) {
+	a := testAEAD(t, "secret")
+	if _, err := NewKeyring(0, a); err == nil {
+		t.Error("NewKeyring(0) succeeded")
+	}
+	k, err := NewKeyring(1, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Add(1, a); err == nil {
+		t.Error("Add of a duplicate version succeeded")
+	}
+	if err := k.Add(-1, a); err == nil {
+		t.Error("Add of a negative version succeeded")
+	}
+}
@@ -13,6 +13,8 @@ import (
 	"path"
 	"strings"
 	"time"
+
+	"karakeep-telegram-bot/internal/security"
 )
 
 type Client struct {
@@ -25,6 +27,10 @@ type Client struct {
 	apiPrefix string
 }
 
+// transport is shared by all clients (they are created per request) and refuses to connect to
+// internal addresses, so a server URL that re-resolves to one can't be used for SSRF.
+var transport = security.NewTransport()
+
 type ClientOpts struct {
 	BaseURL string
 	APIKey  string
@@ -43,8 +49,9 @@ func NewClient(opts ClientOpts) (*Client, error) {
 	if err != nil || u.Scheme == "" || u.Host == "" {
 		return nil, fmt.Errorf("invalid base url: %q", base)
 	}
-	if u.Scheme != "https" {
-		return nil, fmt.Errorf("base url must be https: %q", base)
+	// Stored URLs are re-checked: the operator policy may have changed since /server.
+	if err := security.CheckURL(u); err != nil {
+		return nil, fmt.Errorf("base url %q: %w", base, err)
 	}
 
 	apiKey := strings.TrimSpace(opts.APIKey)
@@ -65,7 +72,9 @@ func NewClient(opts ClientOpts) (*Client, error) {
 		baseURL: u,
 		apiKey:  apiKey,
 		http: &http.Client{
-			Timeout: timeout,
+			Timeout:       timeout,
+			Transport:     transport,
+			CheckRedirect: security.CheckRedirect,
 		},
 		apiPrefix: pickPrefix(opts.APIPrefix),
 	}, nil
@@ -169,6 +178,13 @@ func (c *Client) UpdateBookmark(ctx context.Context, bookmarkID string, patch ma
 	return out, status, nil
 }
 
+func (c *Client) DeleteBookmark(ctx context.Context, bookmarkID string) (int, error) {
+	// https://docs.karakeep.app/api/delete-a-bookmark
+	p := "/bookmarks/" + url.PathEscape(bookmarkID)
+	status, _, err := c.doJSON(ctx, http.MethodDelete, p, nil, nil)
+	return status, err
+}
+
 func (c *Client) Summarize(ctx context.Context, bookmarkID string) (Bookmark, int, error) {
 	// Official doc page: POST /bookmarks/:bookmarkId/summarize
 	// https://docs.karakeep.app/api/summarize-a-bookmark
@@ -270,18 +286,147 @@ func (c *Client) ReplaceAsset(ctx context.Context, bookmarkID string, assetID st
 	return out, status, nil
 }
 
-func (c *Client) DetachAsset(ctx context.Context, bookmarkID string) (Bookmark, int, error) {
+func (c *Client) DetachAsset(ctx context.Context, bookmarkID string, assetID string) (int, error) {
 	// https://docs.karakeep.app/api/detach-asset
-	var out Bookmark
-	p := "/bookmarks/" + url.PathEscape(bookmarkID) + "/assets"
-	status, raw, err := c.doJSON(ctx, http.MethodDelete, p, nil, &out)
+	// DELETE /bookmarks/:bookmarkId/assets/:assetId; Karakeep removes the asset itself along with the link.
+	p := "/bookmarks/" + url.PathEscape(bookmarkID) + "/assets/" + url.PathEscape(assetID)
+	status, _, err := c.doJSON(ctx, http.MethodDelete, p, nil, nil)
+	return status, err
+}
+
+func (c *Client) SearchBookmarks(ctx context.Context, query string, cursor string, limit int) (SearchResult, int, error) {
+	// https://docs.karakeep.app/api/search-bookmarks
+	q := url.Values{}
+	q.Set("q", query)
+	if limit > 0 {
+		q.Set("limit", fmt.Sprint(limit))
+	}
+	if cursor != "" {
+		q.Set("cursor", cursor)
+	}
+	// Search results are only shown as a list; skip full page content to keep responses small.
+	q.Set("includeContent", "false")
+
+	var out SearchResult
+	status, _, err := c.doJSON(ctx, http.MethodGet, "/bookmarks/search?"+q.Encode(), nil, &out)
 	if err != nil {
-		return Bookmark{}, status, err
+		return SearchResult{}, status, err
+	}
+	return out, status, nil
+}
+
+func (c *Client) AttachTags(ctx context.Context, bookmarkID string, tagNames []string) (int, error) {
+	// https://docs.karakeep.app/api/attach-tags-to-a-bookmark
+	tags := make([]map[string]any, 0, len(tagNames))
+	for _, name := range tagNames {
+		if name = strings.TrimSpace(name); name != "" {
+			tags = append(tags, map[string]any{"tagName": name})
+		}
+	}
+	if len(tags) == 0 {
+		return 0, nil
+	}
+	p := "/bookmarks/" + url.PathEscape(bookmarkID) + "/tags"
+	status, _, err := c.doJSON(ctx, http.MethodPost, p, map[string]any{"tags": tags}, nil)
+	return status, err
+}
+
+func (c *Client) ListLists(ctx context.Context) ([]List, int, error) {
+	// https://docs.karakeep.app/api/get-all-lists
+	var out struct {
+		Lists []List `json:"lists"`
+	}
+	status, raw, err := c.doJSON(ctx, http.MethodGet, "/lists", nil, &out)
+	if err != nil {
+		return nil, status, err
+	}
+	if out.Lists == nil {
+		// Some deployments may return a bare array.
+		var arr []List
+		if err := json.Unmarshal(raw, &arr); err == nil {
+			out.Lists = arr
+		}
+	}
+	return out.Lists, status, nil
+}
+
+func (c *Client) CreateList(ctx context.Context, name string, icon string) (List, int, error) {
+	// https://docs.karakeep.app/api/create-a-new-list
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return List{}, 0, errors.New("list name is empty")
+	}
+	if strings.TrimSpace(icon) == "" {
+		icon = "📁"
+	}
+	body := map[string]any{
+		"name": name,
+		"icon": icon,
+	}
+	var out List
+	status, raw, err := c.doJSON(ctx, http.MethodPost, "/lists", body, &out)
+	if err != nil {
+		return List{}, status, err
+	}
+	out.Raw = raw
+	return out, status, nil
+}
+
+func (c *Client) AddBookmarkToList(ctx context.Context, listID string, bookmarkID string) (int, error) {
+	// https://docs.karakeep.app/api/add-a-bookmark-to-a-list
+	p := "/lists/" + url.PathEscape(listID) + "/bookmarks/" + url.PathEscape(bookmarkID)
+	status, _, err := c.doJSON(ctx, http.MethodPut, p, nil, nil)
+	return status, err
+}
+
+func (c *Client) RemoveBookmarkFromList(ctx context.Context, listID string, bookmarkID string) (int, error) {
+	// https://docs.karakeep.app/api/remove-a-bookmark-from-a-list
+	p := "/lists/" + url.PathEscape(listID) + "/bookmarks/" + url.PathEscape(bookmarkID)
+	status, _, err := c.doJSON(ctx, http.MethodDelete, p, nil, nil)
+	return status, err
+}
+
+func (c *Client) WhoAmI(ctx context.Context) (Account, int, error) {
+	// https://docs.karakeep.app/api/get-current-user-info
+	var out Account
+	status, raw, err := c.doJSON(ctx, http.MethodGet, "/users/me", nil, &out)
+	if err != nil {
+		return Account{}, status, err
 	}
 	out.Raw = raw
 	return out, status, nil
 }
 
+// ServerVersion asks the Karakeep web app for its version (GET /api/version, outside the API prefix).
+// Older servers don't have the endpoint; callers should treat errors as "unknown".
+func (c *Client) ServerVersion(ctx context.Context) (string, int, error) {
+	u := *c.baseURL
+	u.Path = "/api/version"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", resp.StatusCode, &APIError{StatusCode: resp.StatusCode}
+	}
+	var out struct {
+		Version string `json:"version"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&out); err != nil {
+		return "", resp.StatusCode, err
+	}
+	return strings.TrimSpace(out.Version), resp.StatusCode, nil
+}
+
+// APIPrefix is the API path prefix in use; after a request it reflects what auto-detection settled on.
+func (c *Client) APIPrefix() string { return c.apiPrefix }
+
 func (c *Client) doJSON(ctx context.Context, method string, p string, body any, out any) (status int, raw json.RawMessage, err error) {
 	var rdr io.Reader
 	if body != nil {
@@ -319,6 +464,11 @@ func (c *Client) doJSON(ctx context.Context, method string, p string, body any,
 
 func (c *Client) newRequest(ctx context.Context, method string, p string, body io.Reader) (*http.Request, error) {
 	u := *c.baseURL
+	// p may carry a query string (e.g. search); keep it out of path.Join.
+	if i := strings.IndexByte(p, '?'); i >= 0 {
+		u.RawQuery = p[i+1:]
+		p = p[:i]
+	}
 	// path.Join cleans slashes; ensure p is treated as relative.
 	p = strings.TrimPrefix(p, "/")
 	u.Path = path.Join(u.Path, strings.TrimPrefix(c.apiPrefix, "/"), p)
@@ -368,6 +518,7 @@ func (c *Client) do(req *http.Request) (int, json.RawMessage, error) {
 				}
 				tail := strings.Join(parts[start:], "/")
 				u.Path = path.Join(strings.TrimPrefix(c.apiPrefix, "/"), tail)
+				u.RawQuery = req.URL.RawQuery
 				req2.URL, _ = url.Parse(u.String())
 
 				resp2, err2 := c.http.Do(req2)
@@ -394,6 +545,18 @@ func (c *Client) do(req *http.Request) (int, json.RawMessage, error) {
 	return resp.StatusCode, b, nil
 }
 
+// IsNotFound reports whether err is a Karakeep 404 (e.g. the bookmark was deleted meanwhile).
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// IsUnauthorized reports whether Karakeep rejected the API key (401/403).
+func IsUnauthorized(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden)
+}
+
 func pickPrefix(p string) string {
 	p = strings.TrimSpace(p)
 	if p == "" {
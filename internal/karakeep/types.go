@@ -24,9 +24,54 @@ type Bookmark struct {
 
 	Tags []Tag `json:"tags,omitempty"`
 
+	// Assets attached to the bookmark (uploaded files, screenshots, ...).
+	Assets []BookmarkAsset `json:"assets,omitempty"`
+
+	Archived   bool `json:"archived,omitempty"`
+	Favourited bool `json:"favourited,omitempty"`
+
+	// Content holds type-specific fields (link url/title, note text); newer API versions nest them here.
+	Content BookmarkContent `json:"content,omitempty"`
+
 	Raw json.RawMessage `json:"-"`
 }
 
+type BookmarkAsset struct {
+	ID        string `json:"id,omitempty"`
+	AssetType string `json:"assetType,omitempty"`
+}
+
+type BookmarkContent struct {
+	Type  string `json:"type,omitempty"`
+	URL   string `json:"url,omitempty"`
+	Title string `json:"title,omitempty"`
+	Text  string `json:"text,omitempty"`
+}
+
+// DisplayTitle picks the best human-readable title: user title, page title, note text, then URL.
+func (b Bookmark) DisplayTitle() string {
+	for _, s := range []string{b.Title, b.Content.Title, b.Content.Text, b.Link()} {
+		if s = strings.TrimSpace(s); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// Link returns the bookmarked URL, if any.
+func (b Bookmark) Link() string {
+	if s := strings.TrimSpace(b.Content.URL); s != "" {
+		return s
+	}
+	return strings.TrimSpace(b.URL)
+}
+
+// SearchResult is one page of bookmark search results.
+type SearchResult struct {
+	Bookmarks  []Bookmark `json:"bookmarks"`
+	NextCursor string     `json:"nextCursor,omitempty"`
+}
+
 func (b Bookmark) SummaryText() string {
 	if len(b.Summary) == 0 {
 		return ""
@@ -59,3 +104,22 @@ type Asset struct {
 	Raw json.RawMessage `json:"-"`
 }
 
+// Account is the Karakeep user an API key belongs to.
+type Account struct {
+	ID    string `json:"id,omitempty"`
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+
+	Raw json.RawMessage `json:"-"`
+}
+
+// List is a best-effort representation of Karakeep List.
+type List struct {
+	ID       string `json:"id,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Icon     string `json:"icon,omitempty"`
+	ParentID string `json:"parentId,omitempty"`
+
+	Raw json.RawMessage `json:"-"`
+}
+
@@ -0,0 +1,84 @@
+// Package ratelimit implements keyed token buckets for throttling users and Karakeep servers.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter holds one token bucket per key. A nil Limiter, or one with a non-positive rate, allows everything.
+type Limiter struct {
+	mu        sync.Mutex
+	perSecond float64
+	burst     float64
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time // replaced in tests
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// sweepEvery bounds how often idle buckets are dropped; a full bucket carries no state worth keeping.
+const sweepEvery = time.Minute
+
+// New returns a limiter refilling perMinute tokens a minute, holding at most burst of them.
+func New(perMinute float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		perSecond: perMinute / 60,
+		burst:     float64(burst),
+		buckets:   make(map[string]*bucket),
+		now:       time.Now,
+	}
+}
+
+// Allow takes a token from key's bucket. If the bucket is empty it returns false and how long until
+// the next token; the failed call doesn't use anything up.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil || l.perSecond <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	now := l.now()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, at: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.at = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.perSecond * float64(time.Second))
+	return false, wait
+}
+
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	t := b.tokens + now.Sub(b.at).Seconds()*l.perSecond
+	if t > l.burst {
+		t = l.burst
+	}
+	return t
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepEvery {
+		return
+	}
+	l.lastSweep = now
+	for k, b := range l.buckets {
+		if l.refill(b, now) >= l.burst {
+			delete(l.buckets, k)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLimiter(perMinute float64, burst int) (*Limiter, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := New(perMinute, burst)
+	l.now = clock.now
+	return l, clock
+}
+
+func TestAllowBurst(t *testing.T) {
+	l, _ := newTestLimiter(60, 3)
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("u"); !ok {
+			t.Fatalf("call %d denied within burst", i+1)
+		}
+	}
+	ok, wait := l.Allow("u")
+	if ok {
+		t.Fatal("call beyond burst allowed")
+	}
+	if wait != time.Second {
+		t.Errorf("wait = %s, want 1s", wait)
+	}
+}
+
+func TestAllowRefill(t *testing.T) {
+	l, clock := newTestLimiter(60, 2)
+	l.Allow("u")
+	l.Allow("u")
+	if ok, _ := l.Allow("u"); ok {
+		t.Fatal("empty bucket allowed a call")
+	}
+
+	clock.advance(500 * time.Millisecond)
+	ok, wait := l.Allow("u")
+	if ok {
+		t.Fatal("half a token allowed a call")
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("wait = %s, want 500ms", wait)
+	}
+
+	clock.advance(500 * time.Millisecond)
+	if ok, _ := l.Allow("u"); !ok {
+		t.Fatal("refilled token denied")
+	}
+
+	// Refill stops at burst however long the key was idle.
+	clock.advance(time.Hour)
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("u"); !ok {
+			t.Fatalf("call %d after idle denied", i+1)
+		}
+	}
+	if ok, _ := l.Allow("u"); ok {
+		t.Error("idle key got more than burst tokens")
+	}
+}
+
+func TestAllowPerKey(t *testing.T) {
+	l, _ := newTestLimiter(60, 1)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Fatal("first call of a denied")
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Fatal("second call of a allowed")
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("b throttled by a's calls")
+	}
+}
+
+func TestAllowSweepKeepsState(t *testing.T) {
+	l, clock := newTestLimiter(0.5, 1)
+	l.Allow("busy")
+	clock.advance(sweepEvery)
+	l.Allow("other")
+	if ok, _ := l.Allow("busy"); ok {
+		t.Error("sweep reset a bucket that was not full")
+	}
+}
+
+func TestAllowDisabled(t *testing.T) {
+	var nilLimiter *Limiter
+	for name, l := range map[string]*Limiter{"nil": nilLimiter, "zero rate": New(0, 1)} {
+		for i := 0; i < 100; i++ {
+			if ok, wait := l.Allow("u"); !ok || wait != 0 {
+				t.Fatalf("%s limiter: Allow = %v, %s; want true, 0", name, ok, wait)
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package security
+
+import (
+	"net/netip"
+	"strings"
+	"sync/atomic"
+)
+
+// Policy is the operator's adjustment of the SSRF rules, e.g. for a Karakeep reachable only over a VPN.
+// Host patterns are exact names or IPs ("karakeep.lan", "10.0.0.5") or "*.example.com" for subdomains.
+type Policy struct {
+	// AllowedHosts, if set, are the only Karakeep hosts users may configure. They only restrict:
+	// their addresses are checked like any other.
+	AllowedHosts []string
+	// DeniedHosts are never used, whatever else allows them.
+	DeniedHosts []string
+	// PrivateHosts are exact host names (no wildcards) trusted to resolve to private, loopback
+	// and other disallowed addresses (but not to DeniedNets).
+	PrivateHosts []string
+
+	// AllowedNets are otherwise disallowed ranges any host may resolve to.
+	AllowedNets []netip.Prefix
+	// DeniedNets are never connected to, even for PrivateHosts.
+	DeniedNets []netip.Prefix
+
+	// HTTPHosts may be used over plain http (a LAN instance without TLS).
+	HTTPHosts []string
+}
+
+var policy atomic.Pointer[Policy]
+
+// SetPolicy replaces the operator policy; it is normally called once at startup.
+// Wildcard patterns in PrivateHosts are ignored.
+func SetPolicy(p Policy) {
+	p.AllowedHosts = normalizeHosts(p.AllowedHosts)
+	p.DeniedHosts = normalizeHosts(p.DeniedHosts)
+	p.HTTPHosts = normalizeHosts(p.HTTPHosts)
+	var private []string
+	for _, h := range normalizeHosts(p.PrivateHosts) {
+		if !strings.Contains(h, "*") {
+			private = append(private, h)
+		}
+	}
+	p.PrivateHosts = private
+	p.AllowedNets = append([]netip.Prefix(nil), p.AllowedNets...)
+	p.DeniedNets = append([]netip.Prefix(nil), p.DeniedNets...)
+	policy.Store(&p)
+}
+
+// normalizeHosts returns normalized copies of hosts, leaving the caller's slice alone.
+func normalizeHosts(hosts []string) []string {
+	out := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		out = append(out, normalizeHost(h))
+	}
+	return out
+}
+
+func currentPolicy() *Policy {
+	if p := policy.Load(); p != nil {
+		return p
+	}
+	return &Policy{}
+}
+
+// trustsHost reports whether host may resolve to private addresses: it is one of PrivateHosts,
+// permitted by AllowedHosts if that is set, and not denied.
+func (p *Policy) trustsHost(host string) bool {
+	host = normalizeHost(host)
+	if matchHost(p.DeniedHosts, host) || (len(p.AllowedHosts) > 0 && !matchHost(p.AllowedHosts, host)) {
+		return false
+	}
+	for _, h := range p.PrivateHosts {
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
+
+func matchHost(patterns []string, host string) bool {
+	host = normalizeHost(host)
+	for _, pat := range patterns {
+		if suffix, ok := strings.CutPrefix(pat, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if host == pat {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeHost(h string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(h), "."))
+}
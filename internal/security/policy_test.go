@@ -0,0 +1,140 @@
+package security
+
+import (
+	"net/netip"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func setTestPolicy(t *testing.T, p Policy) {
+	t.Helper()
+	SetPolicy(p)
+	t.Cleanup(func() { SetPolicy(Policy{}) })
+}
+
+func TestMatchHost(t *testing.T) {
+	patterns := normalizeHosts([]string{"Karakeep.LAN.", "*.corp.example", "10.0.0.5"})
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"karakeep.lan", true},
+		{"KARAKEEP.lan.", true},
+		{"x.karakeep.lan", false},
+		{"a.corp.example", true},
+		{"a.b.corp.example", true},
+		{"corp.example", false},
+		{"evilcorp.example", false},
+		{"10.0.0.5", true},
+		{"10.0.0.6", false},
+	}
+	for _, tt := range tests {
+		if got := matchHost(patterns, tt.host); got != tt.want {
+			t.Errorf("matchHost(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestSetPolicyDoesNotMutateCaller(t *testing.T) {
+	allowed := []string{" Karakeep.LAN. "}
+	private := []string{"Karakeep.LAN", "*.corp.example"}
+	nets := []netip.Prefix{netip.MustParsePrefix("10.8.0.0/16")}
+	setTestPolicy(t, Policy{AllowedHosts: allowed, PrivateHosts: private, AllowedNets: nets})
+
+	if !reflect.DeepEqual(allowed, []string{" Karakeep.LAN. "}) {
+		t.Errorf("AllowedHosts changed to %q", allowed)
+	}
+	if !reflect.DeepEqual(private, []string{"Karakeep.LAN", "*.corp.example"}) {
+		t.Errorf("PrivateHosts changed to %q", private)
+	}
+	nets[0] = netip.MustParsePrefix("0.0.0.0/0")
+	if err := checkIP(netip.MustParseAddr("10.9.0.1"), false); err == nil {
+		t.Error("policy follows later changes to the caller's AllowedNets")
+	}
+}
+
+func TestCheckURLHosts(t *testing.T) {
+	setTestPolicy(t, Policy{
+		AllowedHosts: []string{"*.corp.example", "10.0.0.5", "10.0.0.6", "karakeep.lan"},
+		DeniedHosts:  []string{"bad.corp.example"},
+		PrivateHosts: []string{"10.0.0.5"},
+	})
+	tests := []struct {
+		raw     string
+		allowed bool
+	}{
+		{"https://a.corp.example/", true},
+		{"https://karakeep.lan/", true},
+		{"https://10.0.0.5/", true},
+		{"https://example.com/", false},
+		{"https://bad.corp.example/", false},
+		{"https://BAD.corp.example./", false},
+		// Allowed by name but not trusted on private addresses.
+		{"https://10.0.0.6/", false},
+		{"http://a.corp.example/", false},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.raw)
+		if err := CheckURL(u); (err == nil) != tt.allowed {
+			t.Errorf("CheckURL(%s) = %v, want allowed=%v", tt.raw, err, tt.allowed)
+		}
+	}
+}
+
+func TestTrustsHost(t *testing.T) {
+	setTestPolicy(t, Policy{
+		AllowedHosts: []string{"*.corp.example", "karakeep.lan"},
+		DeniedHosts:  []string{"bad.corp.example"},
+		PrivateHosts: []string{"Karakeep.LAN.", "bad.corp.example", "*.corp.example", "other.lan"},
+	})
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"karakeep.lan", true},
+		{"KARAKEEP.lan", true},
+		// A wildcard in AllowedHosts or PrivateHosts grants no private trust.
+		{"a.corp.example", false},
+		// DeniedHosts wins over PrivateHosts.
+		{"bad.corp.example", false},
+		// PrivateHosts doesn't widen AllowedHosts.
+		{"other.lan", false},
+	}
+	p := currentPolicy()
+	for _, tt := range tests {
+		if got := p.trustsHost(tt.host); got != tt.want {
+			t.Errorf("trustsHost(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestCheckIPNets(t *testing.T) {
+	setTestPolicy(t, Policy{
+		AllowedNets: []netip.Prefix{netip.MustParsePrefix("10.8.0.0/16")},
+		DeniedNets: []netip.Prefix{
+			netip.MustParsePrefix("10.8.5.0/24"),
+			netip.MustParsePrefix("8.8.8.0/24"),
+		},
+	})
+	tests := []struct {
+		addr    string
+		trusted bool
+		allowed bool
+	}{
+		{"10.8.1.1", false, true},
+		{"10.9.0.1", false, false},
+		{"10.9.0.1", true, true},
+		{"1.1.1.1", false, true},
+		// DeniedNets wins over AllowedNets, private trust and public addresses.
+		{"10.8.5.1", false, false},
+		{"10.8.5.1", true, false},
+		{"8.8.8.8", false, false},
+	}
+	for _, tt := range tests {
+		err := checkIP(netip.MustParseAddr(tt.addr), tt.trusted)
+		if (err == nil) != tt.allowed {
+			t.Errorf("checkIP(%s, trusted=%v) = %v, want allowed=%v", tt.addr, tt.trusted, err, tt.allowed)
+		}
+	}
+}
@@ -1,18 +1,24 @@
 package security
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
+	"net/netip"
 	"net/url"
 	"strings"
+	"syscall"
+	"time"
 )
 
-// ValidateServerBaseURL enforces MVP SSRF protections:
-// - https only
-// - no localhost/127.0.0.1
-// - no private/loopback/link-local ranges
+// ValidateServerBaseURL enforces SSRF protections for a user-supplied Karakeep URL:
+// - https only (unless the operator allowed http for the host)
+// - no localhost, hosts permitted by the operator's Policy only
+// - the host must resolve, and only to public addresses (see checkIP) or ones the Policy allows
 // Returns normalized base URL (scheme+host[:port]).
+// Resolution may change later (DNS rebinding), so requests must also go through NewTransport.
 func ValidateServerBaseURL(raw string) (string, error) {
 	raw = strings.TrimSpace(raw)
 	if raw == "" {
@@ -22,24 +28,20 @@ func ValidateServerBaseURL(raw string) (string, error) {
 	if err != nil || u.Scheme == "" || u.Host == "" {
 		return "", fmt.Errorf("invalid url: %q", raw)
 	}
-	if u.Scheme != "https" {
-		return "", errors.New("only https is allowed")
+	if err := CheckURL(u); err != nil {
+		return "", err
 	}
 
-	host := u.Hostname()
-	if host == "" {
-		return "", errors.New("empty hostname")
-	}
-	if isLocalHostname(host) {
-		return "", errors.New("localhost is not allowed")
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil {
+		return "", fmt.Errorf("resolve host: %w", err)
 	}
-
-	ips, err := net.LookupIP(host)
-	if err == nil {
-		for _, ip := range ips {
-			if isDisallowedIP(ip) {
-				return "", fmt.Errorf("host resolves to disallowed ip: %s", ip.String())
-			}
+	trusted := currentPolicy().trustsHost(u.Hostname())
+	for _, ip := range ips {
+		addr, _ := netip.AddrFromSlice(ip)
+		// net.IP keeps IPv4 in 16-byte form; that is not an IPv4-mapped IPv6 answer.
+		if err := checkIP(addr.Unmap(), trusted); err != nil {
+			return "", err
 		}
 	}
 
@@ -47,49 +49,170 @@ func ValidateServerBaseURL(raw string) (string, error) {
 	return normalized.String(), nil
 }
 
+// CheckURL applies the checks that need no DNS: scheme, hostname against the Policy and literal IPs.
+func CheckURL(u *url.URL) error {
+	host := u.Hostname()
+	if host == "" {
+		return errors.New("empty hostname")
+	}
+	p := currentPolicy()
+	switch {
+	case u.Scheme == "https":
+	case u.Scheme == "http" && matchHost(p.HTTPHosts, host):
+	default:
+		return errors.New("only https is allowed")
+	}
+	if matchHost(p.DeniedHosts, host) {
+		return errors.New("host is denied by the operator")
+	}
+	if len(p.AllowedHosts) > 0 && !matchHost(p.AllowedHosts, host) {
+		return errors.New("host is not in the operator's allowed list")
+	}
+	trusted := p.trustsHost(host)
+	if isLocalHostname(host) && !trusted {
+		return errors.New("localhost is not allowed")
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return checkIP(addr, trusted)
+	}
+	return nil
+}
+
 func isLocalHostname(h string) bool {
-	h = strings.ToLower(strings.TrimSuffix(h, "."))
-	return h == "localhost" || h == "localhost.localdomain"
+	h = normalizeHost(h)
+	return h == "localhost" || h == "localhost.localdomain" || strings.HasSuffix(h, ".localhost")
+}
+
+// disallowedPrefixes are address ranges the bot never connects to: private, shared (CGNAT), loopback,
+// link-local, documentation, benchmarking, multicast and reserved space, plus IPv6 transition ranges
+// (NAT64, 6to4, Teredo) that embed an IPv4 address and could reach it indirectly.
+var disallowedPrefixes = mustPrefixes(
+	"0.0.0.0/8",       // "this network"
+	"10.0.0.0/8",      // private
+	"100.64.0.0/10",   // shared address space (CGNAT)
+	"127.0.0.0/8",     // loopback
+	"169.254.0.0/16",  // link-local, cloud metadata
+	"172.16.0.0/12",   // private
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // TEST-NET-1
+	"192.88.99.0/24",  // 6to4 relay anycast
+	"192.168.0.0/16",  // private
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // TEST-NET-2
+	"203.0.113.0/24",  // TEST-NET-3
+	"224.0.0.0/4",     // multicast
+	"240.0.0.0/4",     // reserved, broadcast
+	"::/96",           // unspecified, loopback, IPv4-compatible
+	"::ffff:0:0/96",   // IPv4-mapped
+	"64:ff9b::/96",    // NAT64
+	"64:ff9b:1::/48",  // local-use NAT64
+	"100::/64",        // discard-only
+	"2001::/23",       // IETF protocol assignments, incl. Teredo
+	"2001:db8::/32",   // documentation
+	"2002::/16",       // 6to4
+	"fc00::/7",        // unique local
+	"fe80::/10",       // link-local
+	"fec0::/10",       // site-local (deprecated)
+	"ff00::/8",        // multicast
+)
+
+func mustPrefixes(ss ...string) []netip.Prefix {
+	out := make([]netip.Prefix, 0, len(ss))
+	for _, s := range ss {
+		out = append(out, netip.MustParsePrefix(s))
+	}
+	return out
 }
 
-func isDisallowedIP(ip net.IP) bool {
-	if ip == nil {
-		return true
+// checkIP returns an error if the bot must not connect to addr: it is in a disallowed range
+// and not in the Policy's AllowedNets, or it is in DeniedNets. A host in the Policy's PrivateHosts
+// (trusted) may use any address outside DeniedNets, e.g. a LAN instance behind a VPN.
+// An IPv4-mapped IPv6 address is refused as such rather than unmapped.
+func checkIP(addr netip.Addr, trusted bool) error {
+	if !addr.IsValid() {
+		return errors.New("invalid ip")
 	}
-	// Normalize to 16-byte form where possible.
-	ip = ip.To16()
-	if ip == nil {
-		return true
+	addr = addr.WithZone("")
+	pol := currentPolicy()
+	for _, p := range pol.DeniedNets {
+		if p.Contains(addr) {
+			return fmt.Errorf("ip denied by the operator: %s (%s)", addr, p)
+		}
 	}
-	// Loopback, link-local, multicast, unspecified.
-	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
-		return true
+	if trusted {
+		return nil
 	}
-	// Private ranges (RFC1918) + unique local IPv6 (fc00::/7).
-	if isPrivate(ip) {
-		return true
+	for _, p := range pol.AllowedNets {
+		if p.Contains(addr) {
+			return nil
+		}
 	}
-	return false
+	for _, p := range disallowedPrefixes {
+		if p.Contains(addr) {
+			return fmt.Errorf("disallowed ip: %s (%s)", addr, p)
+		}
+	}
+	return nil
 }
 
-func isPrivate(ip net.IP) bool {
-	// IPv4-mapped?
-	if v4 := ip.To4(); v4 != nil {
-		switch {
-		case v4[0] == 10:
-			return true
-		case v4[0] == 172 && v4[1] >= 16 && v4[1] <= 31:
-			return true
-		case v4[0] == 192 && v4[1] == 168:
-			return true
-		case v4[0] == 127:
-			return true
-		case v4[0] == 169 && v4[1] == 254:
-			return true
-		}
-		return false
+// newDialer returns a dialer that re-checks every address right before connecting. Unlike a check
+// at /server time it also covers DNS answers that changed since (DNS rebinding) and each of several
+// A/AAAA records the dialer may fall back to.
+func newDialer(timeout time.Duration, trusted bool) *net.Dialer {
+	return &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			ap, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return fmt.Errorf("ssrf: %w", err)
+			}
+			if err := checkIP(ap.Addr(), trusted); err != nil {
+				return fmt.Errorf("ssrf: %w", err)
+			}
+			return nil
+		},
 	}
-	// IPv6 ULA fc00::/7
-	return ip[0]&0xfe == 0xfc
 }
 
+// NewTransport returns an http.Transport that only connects to allowed addresses; hosts the Policy
+// allows by name may also reach private ones. Environment proxies are ignored: the dialer checks
+// the address it connects to, which would be the proxy's.
+func NewTransport() *http.Transport {
+	strict := newDialer(10*time.Second, false)
+	trusted := newDialer(10*time.Second, true)
+	return &http.Transport{
+		Proxy: nil,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			// addr is still host:port here; the dialer resolves it and checks each IP.
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			if currentPolicy().trustsHost(host) {
+				return trusted.DialContext(ctx, network, addr)
+			}
+			return strict.DialContext(ctx, network, addr)
+		},
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+const maxRedirects = 5
+
+// CheckRedirect is an http.Client.CheckRedirect that refuses redirects CheckURL refuses (plain http,
+// localhost, hosts outside the Policy, disallowed literal IPs); hostnames are checked again by the
+// dialer once resolved.
+func CheckRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	if err := CheckURL(req.URL); err != nil {
+		return fmt.Errorf("redirect to %s refused: %w", req.URL.Redacted(), err)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package security
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckIP(t *testing.T) {
+	tests := []struct {
+		addr    string
+		allowed bool
+	}{
+		{"8.8.8.8", true},
+		{"1.1.1.1", true},
+		{"2606:4700:4700::1111", true},
+
+		{"0.0.0.0", false},
+		{"10.0.0.1", false},
+		{"100.64.0.1", false},
+		{"100.127.255.254", false},
+		{"127.0.0.1", false},
+		{"169.254.169.254", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"198.18.0.1", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::", false},
+		{"::1", false},
+		{"::ffff:10.0.0.1", false},
+		{"::ffff:8.8.8.8", false},
+		{"64:ff9b::a00:1", false},
+		{"64:ff9b:1::1", false},
+		{"2001::1", false},
+		{"2001:0:4136:e378:8000:63bf:3fff:fdd2", false},
+		{"2001:db8::1", false},
+		{"2002:a00:1::1", false},
+		{"fc00::1", false},
+		{"fd12:3456::1", false},
+		{"fe80::1", false},
+		{"fe80::1%eth0", false},
+		{"ff02::1", false},
+	}
+	for _, tt := range tests {
+		err := checkIP(netip.MustParseAddr(tt.addr), false)
+		if (err == nil) != tt.allowed {
+			t.Errorf("checkIP(%s) = %v, want allowed=%v", tt.addr, err, tt.allowed)
+		}
+	}
+	if err := checkIP(netip.Addr{}, false); err == nil {
+		t.Error("checkIP(invalid) = nil, want error")
+	}
+}
+
+func TestDialerControlRejectsDisallowedAddresses(t *testing.T) {
+	d := newDialer(time.Second, false)
+	for _, addr := range []string{"127.0.0.1:443", "10.0.0.1:443", "[::1]:443", "[::ffff:192.168.0.1]:443"} {
+		if err := d.Control("tcp", addr, nil); err == nil || !strings.Contains(err.Error(), "ssrf") {
+			t.Errorf("Control(%s) = %v, want ssrf error", addr, err)
+		}
+	}
+	if err := d.Control("tcp", "8.8.8.8:443", nil); err != nil {
+		t.Errorf("Control(8.8.8.8:443) = %v, want nil", err)
+	}
+}
+
+// A hostname that passed validation can later resolve to a private address (DNS rebinding);
+// the transport must refuse to connect at dial time. "localhost" stands in for such a name.
+func TestTransportRejectsRebinding(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	client := &http.Client{Transport: NewTransport(), Timeout: 5 * time.Second}
+	for _, host := range []string{u.Host, "localhost:" + u.Port()} {
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://"+host+"/", nil)
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			t.Errorf("GET %s succeeded, want ssrf error", host)
+			continue
+		}
+		if !strings.Contains(err.Error(), "ssrf") {
+			t.Errorf("GET %s: %v, want ssrf error", host, err)
+		}
+	}
+}
+
+func TestCheckRedirect(t *testing.T) {
+	refused := []string{
+		"http://example.com/",
+		"https://localhost/",
+		"https://app.localhost/",
+		"https://127.0.0.1/",
+		"https://10.0.0.1/",
+		"https://[::1]/",
+		"https://169.254.169.254/latest/meta-data/",
+	}
+	for _, raw := range refused {
+		req := httptest.NewRequest(http.MethodGet, raw, nil)
+		if err := CheckRedirect(req, nil); err == nil {
+			t.Errorf("CheckRedirect(%s) = nil, want error", raw)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/api/v1/bookmarks", nil)
+	if err := CheckRedirect(req, nil); err != nil {
+		t.Errorf("CheckRedirect(https://example.com) = %v, want nil", err)
+	}
+	via := make([]*http.Request, maxRedirects)
+	if err := CheckRedirect(req, via); err == nil {
+		t.Errorf("CheckRedirect after %d redirects = nil, want error", maxRedirects)
+	}
+}
@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const accessInviteTTL = 7 * 24 * time.Hour
+
+// AccessGrant is a user approved to use the bot, by Telegram id or by @username.
+type AccessGrant struct {
+	// Subject is the numeric user id or "@username" (lower-case).
+	Subject string
+	// Username is the last known username of an id grant; a grant made for @username is pinned
+	// to the user's id on first contact, so renaming doesn't lock them out.
+	Username  string
+	AddedBy   int64
+	CreatedAt time.Time
+}
+
+// AccessSubject normalizes "123", "@Name" or "name" into the form stored in access_grants;
+// ok is false if s is neither an id nor a plausible username.
+func AccessSubject(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	if id, err := strconv.ParseInt(s, 10, 64); err == nil && id > 0 {
+		return strconv.FormatInt(id, 10), true
+	}
+	name := strings.ToLower(strings.TrimPrefix(s, "@"))
+	if len(name) < 4 || len(name) > 32 {
+		return "", false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_') {
+			return "", false
+		}
+	}
+	return "@" + name, true
+}
+
+// ApproveUser grants access to subject (see AccessSubject); approving twice is a no-op.
+func (s *Store) ApproveUser(ctx context.Context, subject string, addedBy int64) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO access_grants (subject, added_by, created_at) VALUES (?, ?, ?)
+ON CONFLICT(subject) DO NOTHING
+`, subject, addedBy, now)
+	return err
+}
+
+// RevokeUser removes a grant together with the grants it is linked to by username:
+// revoking @name also removes the id it was pinned to, and revoking an id removes its @name grant.
+func (s *Store) RevokeUser(ctx context.Context, subject string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `
+DELETE FROM access_grants
+WHERE subject=?1
+   OR (?1 LIKE '@%' AND username=substr(?1, 2))
+   OR subject=(SELECT '@' || username FROM access_grants WHERE subject=?1 AND username != '')
+`, subject)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// IsApproved reports whether the user has a grant by id or by current username.
+func (s *Store) IsApproved(ctx context.Context, telegramUserID int64, username string) (bool, error) {
+	id := strconv.FormatInt(telegramUserID, 10)
+	name := ""
+	if username != "" {
+		name, _ = AccessSubject(username)
+	}
+	var subject string
+	err := s.db.QueryRowContext(ctx, `
+SELECT subject FROM access_grants WHERE subject IN (?1, ?2) ORDER BY subject=?1 DESC LIMIT 1
+`, id, name).Scan(&subject)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if subject != id {
+		// Approved by @username: pin the grant to the id.
+		now := time.Now().UTC().Format(time.RFC3339Nano)
+		_, err = s.db.ExecContext(ctx, `
+INSERT INTO access_grants (subject, username, added_by, created_at)
+SELECT ?, ?, added_by, ? FROM access_grants WHERE subject=?
+ON CONFLICT(subject) DO NOTHING
+`, id, strings.TrimPrefix(name, "@"), now, name)
+	}
+	return true, err
+}
+
+// AccessGrants lists all grants, oldest first.
+func (s *Store) AccessGrants(ctx context.Context) ([]AccessGrant, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT subject, username, added_by, created_at FROM access_grants ORDER BY created_at
+`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []AccessGrant
+	for rows.Next() {
+		var g AccessGrant
+		var createdAt string
+		if err := rows.Scan(&g.Subject, &g.Username, &g.AddedBy, &createdAt); err != nil {
+			return nil, err
+		}
+		g.CreatedAt, _ = time.Parse(time.RFC3339Nano, createdAt)
+		out = append(out, g)
+	}
+	return out, rows.Err()
+}
+
+// CreateAccessInvite returns a single-use registration code, valid for a week.
+func (s *Store) CreateAccessInvite(ctx context.Context, createdBy int64) (string, time.Time, error) {
+	code, err := newInviteCode()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now().UTC()
+	expiresAt := now.Add(accessInviteTTL)
+	_, err = s.db.ExecContext(ctx, `
+INSERT INTO access_invites (code, created_by, expires_at, created_at) VALUES (?, ?, ?, ?)
+`, code, createdBy, expiresAt.Format(time.RFC3339Nano), now.Format(time.RFC3339Nano))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	// Best-effort cleanup of expired codes.
+	_, _ = s.db.ExecContext(ctx, `DELETE FROM access_invites WHERE expires_at < ?`, now.Format(time.RFC3339Nano))
+	return code, expiresAt, nil
+}
+
+// RedeemAccessInvite approves telegramUserID with an invite code. The code is consumed even if it has expired.
+func (s *Store) RedeemAccessInvite(ctx context.Context, code string, telegramUserID int64, username string) error {
+	code = strings.ToUpper(stringsTrim(code))
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var createdBy int64
+	var expiresAt string
+	err = tx.QueryRowContext(ctx, `SELECT created_by, expires_at FROM access_invites WHERE code=?`, code).Scan(&createdBy, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInviteInvalid
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM access_invites WHERE code=?`, code); err != nil {
+		return err
+	}
+	if t, err := time.Parse(time.RFC3339Nano, expiresAt); err != nil || time.Now().After(t) {
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return ErrInviteInvalid
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO access_grants (subject, username, added_by, created_at) VALUES (?, ?, ?, ?)
+ON CONFLICT(subject) DO NOTHING
+`, strconv.FormatInt(telegramUserID, 10), strings.ToLower(username), createdBy, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
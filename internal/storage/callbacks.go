@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+// Telegram limits callback_data to 64 bytes, so larger button state (search query + cursor, etc.)
+// is kept here and buttons only carry a short random token.
+
+const callbackDataTTL = 7 * 24 * time.Hour
+
+// PutCallbackData stores payload for telegramUserID and returns a token to put into callback_data.
+func (s *Store) PutCallbackData(ctx context.Context, telegramUserID int64, payload string) (string, error) {
+	raw := make([]byte, 12)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	now := time.Now().UTC()
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO callback_data (token, telegram_user_id, payload, created_at)
+VALUES (?, ?, ?, ?)
+`, token, telegramUserID, payload, now.Format(time.RFC3339Nano))
+	if err != nil {
+		return "", err
+	}
+
+	// Best-effort cleanup; buttons older than the TTL simply stop working.
+	_, _ = s.db.ExecContext(ctx, `DELETE FROM callback_data WHERE created_at < ?`, now.Add(-callbackDataTTL).Format(time.RFC3339Nano))
+	return token, nil
+}
+
+// GetCallbackData returns the payload for token. ok is false for unknown/expired tokens
+// and for tokens that belong to a different user.
+func (s *Store) GetCallbackData(ctx context.Context, telegramUserID int64, token string) (payload string, ok bool, err error) {
+	err = s.db.QueryRowContext(ctx, `
+SELECT payload FROM callback_data WHERE token=? AND telegram_user_id=?
+`, token, telegramUserID).Scan(&payload)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return payload, true, nil
+}
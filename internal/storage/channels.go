@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// ChannelBinding routes every post of a Telegram channel into the Karakeep of TelegramUserID.
+// A channel has at most one binding.
+type ChannelBinding struct {
+	ChannelID int64
+	Title     string
+
+	TelegramUserID int64
+
+	// Target list for the channel's posts; empty falls back to the owner's default list.
+	ListID   string
+	ListName string
+	// Tags (without "#") attached to every post in addition to its own hashtags.
+	Tags []string
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (s *Store) SetChannelBinding(ctx context.Context, b ChannelBinding) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO channel_bindings (channel_id, title, telegram_user_id, list_id, list_name, tags, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(channel_id) DO UPDATE SET
+  title=excluded.title,
+  telegram_user_id=excluded.telegram_user_id,
+  list_id=excluded.list_id,
+  list_name=excluded.list_name,
+  tags=excluded.tags,
+  updated_at=excluded.updated_at
+`, b.ChannelID, b.Title, b.TelegramUserID, b.ListID, b.ListName, strings.Join(b.Tags, " "), now, now)
+	return err
+}
+
+func (s *Store) GetChannelBinding(ctx context.Context, channelID int64) (ChannelBinding, bool, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT `+channelBindingColumns+` FROM channel_bindings WHERE channel_id=?
+`, channelID)
+	if err != nil {
+		return ChannelBinding{}, false, err
+	}
+	bs, err := scanChannelBindings(rows)
+	if err != nil {
+		return ChannelBinding{}, false, err
+	}
+	if len(bs) == 0 {
+		return ChannelBinding{}, false, nil
+	}
+	return bs[0], true, nil
+}
+
+// ChannelBindings returns the channels bound by telegramUserID, oldest first.
+func (s *Store) ChannelBindings(ctx context.Context, telegramUserID int64) ([]ChannelBinding, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT `+channelBindingColumns+` FROM channel_bindings WHERE telegram_user_id=? ORDER BY created_at
+`, telegramUserID)
+	if err != nil {
+		return nil, err
+	}
+	return scanChannelBindings(rows)
+}
+
+// DeleteChannelBinding removes the binding of channelID if it belongs to telegramUserID.
+// ok is false when there was no such binding.
+func (s *Store) DeleteChannelBinding(ctx context.Context, channelID int64, telegramUserID int64) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `
+DELETE FROM channel_bindings WHERE channel_id=? AND telegram_user_id=?
+`, channelID, telegramUserID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+const channelBindingColumns = `channel_id, title, telegram_user_id, list_id, list_name, tags, created_at, updated_at`
+
+func scanChannelBindings(rows *sql.Rows) ([]ChannelBinding, error) {
+	defer rows.Close()
+	var out []ChannelBinding
+	for rows.Next() {
+		var b ChannelBinding
+		var tags, createdAt, updatedAt string
+		if err := rows.Scan(&b.ChannelID, &b.Title, &b.TelegramUserID, &b.ListID, &b.ListName, &tags, &createdAt, &updatedAt); err != nil {
+			return nil, err
+		}
+		b.Tags = strings.Fields(tags)
+		b.CreatedAt, _ = time.Parse(time.RFC3339Nano, createdAt)
+		b.UpdatedAt, _ = time.Parse(time.RFC3339Nano, updatedAt)
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ChatSettings controls what the bot saves in a group chat.
+// Chats without a row behave like DefaultChatSettings.
+type ChatSettings struct {
+	ChatID int64
+
+	// Enabled turns saving in the chat on/off altogether.
+	Enabled bool
+	// OnMention saves messages that mention the bot, OnReply saves replies to the bot's messages.
+	OnMention bool
+	OnReply   bool
+	// TriggerTag (without "#") saves messages carrying this hashtag; empty disables the trigger.
+	TriggerTag string
+
+	UpdatedAt time.Time
+}
+
+func DefaultChatSettings(chatID int64) ChatSettings {
+	return ChatSettings{ChatID: chatID, Enabled: true, OnMention: true, OnReply: true}
+}
+
+func (s *Store) GetChatSettings(ctx context.Context, chatID int64) (ChatSettings, error) {
+	cs := ChatSettings{ChatID: chatID}
+	var updatedAt string
+	err := s.db.QueryRowContext(ctx, `
+SELECT enabled, on_mention, on_reply, trigger_tag, updated_at
+FROM chat_settings WHERE chat_id=?
+`, chatID).Scan(&cs.Enabled, &cs.OnMention, &cs.OnReply, &cs.TriggerTag, &updatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return DefaultChatSettings(chatID), nil
+		}
+		return ChatSettings{}, err
+	}
+	cs.UpdatedAt, _ = time.Parse(time.RFC3339Nano, updatedAt)
+	return cs, nil
+}
+
+func (s *Store) SetChatSettings(ctx context.Context, cs ChatSettings) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO chat_settings (chat_id, enabled, on_mention, on_reply, trigger_tag, updated_at)
+VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT(chat_id) DO UPDATE SET
+  enabled=excluded.enabled,
+  on_mention=excluded.on_mention,
+  on_reply=excluded.on_reply,
+  trigger_tag=excluded.trigger_tag,
+  updated_at=excluded.updated_at
+`, cs.ChatID, cs.Enabled, cs.OnMention, cs.OnReply, cs.TriggerTag, now)
+	return err
+}
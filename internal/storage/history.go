@@ -0,0 +1,21 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// History actions.
+const (
+	HistoryUndo = "undo"
+)
+
+// AddHistory appends an audit record of a user action on a bookmark.
+func (s *Store) AddHistory(ctx context.Context, telegramUserID int64, action string, bookmarkID string, detail string) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO history (telegram_user_id, action, bookmark_id, detail, created_at)
+VALUES (?, ?, ?, ?, ?)
+`, telegramUserID, action, bookmarkID, detail, now)
+	return err
+}
@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+type JobStatus string
+
+const (
+	JobPending JobStatus = "pending"
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	JobFailed  JobStatus = "failed"
+)
+
+// JobStage records what a job already did to its bookmark past creating it, so a resumed job
+// doesn't repeat side effects such as appending notes twice.
+type JobStage int
+
+const (
+	// JobStageNew: nothing applied yet besides creating BookmarkID, if it is set.
+	JobStageNew JobStage = iota
+	// JobStageNotes: a reply's text is appended to the target bookmark's notes.
+	JobStageNotes
+	// JobStageSetUp: attachments, tags and list are applied; only enrichment is left.
+	JobStageSetUp
+)
+
+// Job is a persisted unit of save work (a single message or a whole media group).
+// Payload is opaque to storage; the app layer decides how to encode Telegram messages into it.
+type Job struct {
+	ID   int64
+	Kind string
+
+	TelegramUserID int64
+	ChatID         int64
+	MessageID      int
+
+	Payload string
+
+	Status    JobStatus
+	Attempts  int
+	LastError string
+
+	// AckMessageID is the bot message we keep editing with progress; 0 until the ack was sent.
+	AckMessageID int
+	// BookmarkID is set as soon as the bookmark exists, so a resumed job skips creation.
+	// Jobs that create one bookmark per link keep a comma-separated list of the ids created so far.
+	BookmarkID string
+	Stage      JobStage
+	// AssetsAttached counts the job's attachments already attached to BookmarkID, in payload order.
+	AssetsAttached int
+	// Footer keeps the ack's extra lines (tags, target list) from JobStageSetUp for a resumed job.
+	Footer string
+
+	NextRunAt time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (s *Store) EnqueueJob(ctx context.Context, j Job) (int64, error) {
+	if stringsTrim(j.Kind) == "" {
+		return 0, errors.New("job kind is empty")
+	}
+	now := time.Now().UTC()
+	nowStr := now.Format(time.RFC3339Nano)
+	res, err := s.db.ExecContext(ctx, `
+INSERT INTO jobs (kind, telegram_user_id, chat_id, message_id, payload, status, next_run_at, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+`, j.Kind, j.TelegramUserID, j.ChatID, j.MessageID, j.Payload, JobPending, now.UnixMilli(), nowStr, nowStr)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// ClaimNextJob atomically moves the oldest due pending job to running and bumps its attempt counter.
+// ok is false when there is nothing to do.
+func (s *Store) ClaimNextJob(ctx context.Context) (j Job, ok bool, err error) {
+	now := time.Now().UTC()
+	row := s.db.QueryRowContext(ctx, `
+UPDATE jobs SET status=?, attempts=attempts+1, updated_at=?
+WHERE id = (
+  SELECT id FROM jobs WHERE status=? AND next_run_at<=? ORDER BY next_run_at, id LIMIT 1
+)
+RETURNING id, kind, telegram_user_id, chat_id, message_id, payload, status, attempts, last_error, ack_message_id, bookmark_id, stage, assets_attached, footer, next_run_at, created_at, updated_at
+`, JobRunning, now.Format(time.RFC3339Nano), JobPending, now.UnixMilli())
+
+	j, err = scanJob(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, false, nil
+		}
+		return Job{}, false, err
+	}
+	return j, true, nil
+}
+
+func (s *Store) SetJobAckMessageID(ctx context.Context, jobID int64, messageID int) error {
+	return s.updateJob(ctx, `ack_message_id=?`, jobID, messageID)
+}
+
+func (s *Store) SetJobBookmarkID(ctx context.Context, jobID int64, bookmarkID string) error {
+	return s.updateJob(ctx, `bookmark_id=?`, jobID, bookmarkID)
+}
+
+func (s *Store) SetJobStage(ctx context.Context, jobID int64, stage JobStage) error {
+	return s.updateJob(ctx, `stage=?`, jobID, stage)
+}
+
+func (s *Store) SetJobAssetsAttached(ctx context.Context, jobID int64, n int) error {
+	return s.updateJob(ctx, `assets_attached=?`, jobID, n)
+}
+
+func (s *Store) SetJobFooter(ctx context.Context, jobID int64, footer string) error {
+	return s.updateJob(ctx, `footer=?`, jobID, footer)
+}
+
+func (s *Store) CompleteJob(ctx context.Context, jobID int64) error {
+	return s.updateJob(ctx, `status=?, last_error=''`, jobID, JobDone)
+}
+
+func (s *Store) FailJob(ctx context.Context, jobID int64, errText string) error {
+	return s.updateJob(ctx, `status=?, last_error=?`, jobID, JobFailed, errText)
+}
+
+// DeferJob puts a running job back to pending until nextRunAt without counting the attempt it was claimed for.
+func (s *Store) DeferJob(ctx context.Context, jobID int64, nextRunAt time.Time) error {
+	return s.updateJob(ctx, `status=?, attempts=MAX(attempts-1, 0), next_run_at=?`, jobID, JobPending, nextRunAt.UTC().UnixMilli())
+}
+
+// RetryJob puts a running job back to pending; it becomes claimable again at nextRunAt.
+func (s *Store) RetryJob(ctx context.Context, jobID int64, errText string, nextRunAt time.Time) error {
+	return s.updateJob(ctx, `status=?, last_error=?, next_run_at=?`, jobID, JobPending, errText, nextRunAt.UTC().UnixMilli())
+}
+
+// ReleaseJob returns a job interrupted by shutdown to the queue without charging it an attempt.
+func (s *Store) ReleaseJob(ctx context.Context, jobID int64) error {
+	return s.updateJob(ctx, `status=?, attempts=MAX(attempts-1, 0)`, jobID, JobPending)
+}
+
+// RequeueRunningJobs returns jobs left in running state (crash, restart, deploy) to the queue.
+// It must be called before workers start, otherwise it would steal their jobs.
+func (s *Store) RequeueRunningJobs(ctx context.Context) (int64, error) {
+	now := time.Now().UTC()
+	res, err := s.db.ExecContext(ctx, `
+UPDATE jobs SET status=?, next_run_at=?, updated_at=?
+WHERE status=?
+`, JobPending, now.UnixMilli(), now.Format(time.RFC3339Nano), JobRunning)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// HasUnfinishedJob reports whether a job of one of kinds for the message is pending or running.
+func (s *Store) HasUnfinishedJob(ctx context.Context, chatID int64, messageID int, kinds ...string) (bool, error) {
+	if len(kinds) == 0 {
+		return false, nil
+	}
+	args := []any{chatID, messageID, JobPending, JobRunning}
+	for _, k := range kinds {
+		args = append(args, k)
+	}
+	var n int
+	err := s.db.QueryRowContext(ctx, `
+SELECT COUNT(*) FROM jobs
+WHERE chat_id=? AND message_id=? AND status IN (?, ?) AND kind IN (?`+strings.Repeat(", ?", len(kinds)-1)+`)
+`, args...).Scan(&n)
+	return n > 0, err
+}
+
+// PruneJobs deletes done and failed jobs last updated before cutoff; their payloads hold whole messages.
+func (s *Store) PruneJobs(ctx context.Context, cutoff time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM jobs WHERE status IN (?, ?) AND updated_at < ?`,
+		JobDone, JobFailed, cutoff.UTC().Format(time.RFC3339Nano))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *Store) updateJob(ctx context.Context, set string, jobID int64, args ...any) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	args = append(args, now, jobID)
+	_, err := s.db.ExecContext(ctx, `UPDATE jobs SET `+set+`, updated_at=? WHERE id=?`, args...)
+	return err
+}
+
+func scanJob(row *sql.Row) (Job, error) {
+	var j Job
+	var status string
+	var nextRunAt int64
+	var createdAt, updatedAt string
+	err := row.Scan(
+		&j.ID,
+		&j.Kind,
+		&j.TelegramUserID,
+		&j.ChatID,
+		&j.MessageID,
+		&j.Payload,
+		&status,
+		&j.Attempts,
+		&j.LastError,
+		&j.AckMessageID,
+		&j.BookmarkID,
+		&j.Stage,
+		&j.AssetsAttached,
+		&j.Footer,
+		&nextRunAt,
+		&createdAt,
+		&updatedAt,
+	)
+	if err != nil {
+		return Job{}, err
+	}
+	j.Status = JobStatus(status)
+	j.NextRunAt = time.UnixMilli(nextRunAt).UTC()
+	j.CreatedAt, _ = time.Parse(time.RFC3339Nano, createdAt)
+	j.UpdatedAt, _ = time.Parse(time.RFC3339Nano, updatedAt)
+	return j, nil
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func enqueueTestJob(t *testing.T, s *Store, messageID int) int64 {
+	t.Helper()
+	id, err := s.EnqueueJob(context.Background(), Job{Kind: "message", TelegramUserID: 1, ChatID: 10, MessageID: messageID, Payload: "{}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func claimTestJob(t *testing.T, s *Store) (Job, bool) {
+	t.Helper()
+	j, ok, err := s.ClaimNextJob(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return j, ok
+}
+
+func jobState(t *testing.T, s *Store, id int64) (JobStatus, int) {
+	t.Helper()
+	var status string
+	var attempts int
+	if err := s.db.QueryRow(`SELECT status, attempts FROM jobs WHERE id=?`, id).Scan(&status, &attempts); err != nil {
+		t.Fatal(err)
+	}
+	return JobStatus(status), attempts
+}
+
+func TestClaimNextJobOrder(t *testing.T) {
+	ctx := context.Background()
+	s, _ := openTestStore(t, MasterKeys{Current: testMasterKey, CurrentVersion: 1})
+	first := enqueueTestJob(t, s, 1)
+	second := enqueueTestJob(t, s, 2)
+	third := enqueueTestJob(t, s, 3)
+
+	// Equal next_run_at falls back to id order.
+	j, ok := claimTestJob(t, s)
+	if !ok || j.ID != first || j.Status != JobRunning || j.Attempts != 1 {
+		t.Fatalf("first claim = %+v, %v; want job %d running with 1 attempt", j, ok, first)
+	}
+	// A job retried into the future waits; one retried into the past goes ahead of older jobs.
+	if err := s.RetryJob(ctx, first, "boom", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec(`UPDATE jobs SET next_run_at=next_run_at-1000 WHERE id=?`, third); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []int64{third, second} {
+		if j, ok := claimTestJob(t, s); !ok || j.ID != want {
+			t.Fatalf("claim = %d, %v; want %d", j.ID, ok, want)
+		}
+	}
+	if j, ok := claimTestJob(t, s); ok {
+		t.Fatalf("claimed job %d scheduled an hour ahead", j.ID)
+	}
+
+	if err := s.RetryJob(ctx, first, "boom", time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	j, ok = claimTestJob(t, s)
+	if !ok || j.ID != first || j.Attempts != 2 || j.LastError != "boom" {
+		t.Fatalf("retried claim = %+v, %v; want job %d with 2 attempts and its last error", j, ok, first)
+	}
+}
+
+func TestDeferAndReleaseRefundAttempt(t *testing.T) {
+	ctx := context.Background()
+	s, _ := openTestStore(t, MasterKeys{Current: testMasterKey, CurrentVersion: 1})
+	id := enqueueTestJob(t, s, 1)
+
+	claimTestJob(t, s)
+	if err := s.DeferJob(ctx, id, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if status, attempts := jobState(t, s, id); status != JobPending || attempts != 0 {
+		t.Fatalf("after DeferJob: %s with %d attempts, want pending with 0", status, attempts)
+	}
+	if j, ok := claimTestJob(t, s); ok {
+		t.Fatalf("claimed deferred job %d before its time", j.ID)
+	}
+
+	if _, err := s.db.Exec(`UPDATE jobs SET next_run_at=0 WHERE id=?`, id); err != nil {
+		t.Fatal(err)
+	}
+	claimTestJob(t, s)
+	if err := s.ReleaseJob(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if status, attempts := jobState(t, s, id); status != JobPending || attempts != 0 {
+		t.Fatalf("after ReleaseJob: %s with %d attempts, want pending with 0", status, attempts)
+	}
+	if j, ok := claimTestJob(t, s); !ok || j.ID != id || j.Attempts != 1 {
+		t.Fatalf("claim after release = %+v, %v; want job %d with 1 attempt", j, ok, id)
+	}
+}
+
+// A job left running by a crash comes back with its progress once the store is reopened.
+func TestRequeueRunningJobsAfterCrash(t *testing.T) {
+	ctx := context.Background()
+	keys := MasterKeys{Current: testMasterKey, CurrentVersion: 1}
+	s, path := openTestStore(t, keys)
+	id := enqueueTestJob(t, s, 1)
+	done := enqueueTestJob(t, s, 2)
+
+	j, _ := claimTestJob(t, s)
+	if err := s.SetJobBookmarkID(ctx, j.ID, "bm1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetJobAssetsAttached(ctx, j.ID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetJobFooter(ctx, j.ID, "🏷 #go"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetJobStage(ctx, j.ID, JobStageSetUp); err != nil {
+		t.Fatal(err)
+	}
+	claimTestJob(t, s)
+	if err := s.CompleteJob(ctx, done); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestStoreAt(t, path, keys)
+	n, err := s.RequeueRunningJobs(ctx)
+	if err != nil || n != 1 {
+		t.Fatalf("RequeueRunningJobs = %d, %v; want 1", n, err)
+	}
+	j, ok := claimTestJob(t, s)
+	if !ok || j.ID != id {
+		t.Fatalf("claim after requeue = %+v, %v; want job %d", j, ok, id)
+	}
+	if j.Attempts != 2 || j.BookmarkID != "bm1" || j.AssetsAttached != 1 || j.Stage != JobStageSetUp || j.Footer != "🏷 #go" {
+		t.Errorf("requeued job lost its progress: %+v", j)
+	}
+	if j, ok := claimTestJob(t, s); ok {
+		t.Errorf("claimed completed job %d", j.ID)
+	}
+}
+
+func TestHasUnfinishedJobAndPrune(t *testing.T) {
+	ctx := context.Background()
+	s, _ := openTestStore(t, MasterKeys{Current: testMasterKey, CurrentVersion: 1})
+	id := enqueueTestJob(t, s, 1)
+
+	has := func(kinds ...string) bool {
+		t.Helper()
+		ok, err := s.HasUnfinishedJob(ctx, 10, 1, kinds...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+	if !has("edit", "message") || has("edit") || has() {
+		t.Error("HasUnfinishedJob doesn't match the pending job by kind")
+	}
+	claimTestJob(t, s)
+	if !has("message") {
+		t.Error("HasUnfinishedJob misses a running job")
+	}
+
+	if err := s.FailJob(ctx, id, "boom"); err != nil {
+		t.Fatal(err)
+	}
+	if has("message") {
+		t.Error("HasUnfinishedJob reports a failed job")
+	}
+	pending := enqueueTestJob(t, s, 2)
+	if n, err := s.PruneJobs(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Fatalf("PruneJobs(an hour ago) = %d, %v; want 0", n, err)
+	}
+	if n, err := s.PruneJobs(ctx, time.Now().Add(time.Second)); err != nil || n != 1 {
+		t.Fatalf("PruneJobs(now) = %d, %v; want 1", n, err)
+	}
+	if status, _ := jobState(t, s, pending); status != JobPending {
+		t.Errorf("PruneJobs touched a pending job: %s", status)
+	}
+}
@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// RotationStats counts the API keys RotateAPIKeys re-encrypted, per table.
+type RotationStats struct {
+	Users      int
+	Profiles   int
+	Workspaces int
+}
+
+func (r RotationStats) Total() int { return r.Users + r.Profiles + r.Workspaces }
+
+// RotateAPIKeys re-encrypts every stored API key that is not sealed with the current master key yet,
+// binding legacy keys to their owner on the way. It runs in a single transaction: if any key can't be
+// decrypted, nothing is changed.
+func (s *Store) RotateAPIKeys(ctx context.Context) (RotationStats, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return RotationStats{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var stats RotationStats
+	for _, t := range []struct {
+		table string
+		n     *int
+	}{
+		{"users", &stats.Users},
+		{"profiles", &stats.Profiles},
+		{"workspaces", &stats.Workspaces},
+	} {
+		if *t.n, err = s.rotateTable(ctx, tx, t.table); err != nil {
+			return RotationStats{}, fmt.Errorf("rotate %s: %w", t.table, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return RotationStats{}, err
+	}
+	return stats, nil
+}
+
+// StaleAPIKeys counts stored API keys sealed with an older master key or not bound to their owner yet.
+func (s *Store) StaleAPIKeys(ctx context.Context) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `
+SELECT
+  (SELECT COUNT(*) FROM users WHERE `+staleAPIKeyCond+`) +
+  (SELECT COUNT(*) FROM profiles WHERE `+staleAPIKeyCond+`) +
+  (SELECT COUNT(*) FROM workspaces WHERE `+staleAPIKeyCond+`)
+`, s.keys.Primary()).Scan(&n)
+	return n, err
+}
+
+const staleAPIKeyCond = `api_key_ciphertext_b64 != '' AND (api_key_version != ?1 OR api_key_bound = 0)`
+
+// keyOwnerColumns selects what identifies a row's owner, as (id, name) for keyOwner.
+var keyOwnerColumns = map[string]string{
+	"users":      "telegram_user_id, ''",
+	"profiles":   "telegram_user_id, name",
+	"workspaces": "id, ''",
+}
+
+func keyOwner(table string, id int64, name string) apiKeyOwner {
+	switch table {
+	case "profiles":
+		return profileKeyOwner(id, name)
+	case "workspaces":
+		return workspaceKeyOwner(id)
+	default:
+		return userKeyOwner(id)
+	}
+}
+
+func (s *Store) rotateTable(ctx context.Context, tx *sql.Tx, table string) (int, error) {
+	type sealed struct {
+		owner           apiKeyOwner
+		ctB64, nonceB64 string
+		version         int
+		bound           bool
+	}
+	rows, err := tx.QueryContext(ctx, `
+SELECT `+keyOwnerColumns[table]+`, api_key_ciphertext_b64, api_key_nonce_b64, api_key_version, api_key_bound FROM `+table+`
+WHERE `+staleAPIKeyCond, s.keys.Primary())
+	if err != nil {
+		return 0, err
+	}
+	var stale []sealed
+	for rows.Next() {
+		var r sealed
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name, &r.ctB64, &r.nonceB64, &r.version, &r.bound); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		r.owner = keyOwner(table, id, name)
+		stale = append(stale, r)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return 0, err
+	}
+	_ = rows.Close()
+
+	for _, r := range stale {
+		apiKey, _, err := s.openAPIKey(r.owner, r.ctB64, r.nonceB64, r.version, r.bound)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", r.owner.ad, err)
+		}
+		if err := s.bindAPIKey(ctx, tx, r.owner, apiKey, r.ctB64); err != nil {
+			return 0, err
+		}
+	}
+	return len(stale), nil
+}
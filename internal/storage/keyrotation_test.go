@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"reflect"
+	"testing"
+)
+
+var (
+	keysV1       = MasterKeys{Current: testMasterKey, CurrentVersion: 1}
+	keysV2       = MasterKeys{Current: testMasterKeyNext, CurrentVersion: 2}
+	keysRotating = MasterKeys{Current: testMasterKeyNext, CurrentVersion: 2, Previous: testMasterKey, PreviousVersion: 1}
+)
+
+// seedAPIKeys stores a key for user 1, their profile "work", user 2 and the workspace owned by user 3.
+func seedAPIKeys(t *testing.T, s *Store) map[string]string {
+	t.Helper()
+	ctx := context.Background()
+	for _, id := range []int64{1, 2, 3} {
+		if err := s.UpsertUser(ctx, id); err != nil {
+			t.Fatalf("UpsertUser: %v", err)
+		}
+	}
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(s.SetAPIKey(ctx, 1, "key-user-1"))
+	must(s.AddProfile(ctx, 1, "work", "https://work.example"))
+	must(s.SetProfileAPIKey(ctx, 1, "work", "key-profile-work"))
+	must(s.SetAPIKey(ctx, 2, "key-user-2"))
+	_, err := s.CreateWorkspace(ctx, 3, "team", "https://team.example", "key-workspace")
+	must(err)
+	return map[string]string{
+		"user 1":       "key-user-1",
+		"profile work": "key-profile-work",
+		"user 2":       "key-user-2",
+		"workspace":    "key-workspace",
+	}
+}
+
+// readAPIKeys decrypts what seedAPIKeys stored.
+func readAPIKeys(t *testing.T, s *Store) map[string]string {
+	t.Helper()
+	ctx := context.Background()
+	out := make(map[string]string)
+	read := func(name string, key string, ok bool, err error) {
+		t.Helper()
+		if err != nil || !ok {
+			t.Fatalf("decrypt %s: ok=%v err=%v", name, ok, err)
+		}
+		out[name] = key
+	}
+	u1, err := s.GetUser(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, ok, err := s.DecryptAPIKey(ctx, u1)
+	read("user 1", key, ok, err)
+	p, found := u1.Profile("work")
+	if !found {
+		t.Fatal("profile work not found")
+	}
+	key, ok, err = s.DecryptProfileAPIKey(ctx, p)
+	read("profile work", key, ok, err)
+	u2, err := s.GetUser(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, ok, err = s.DecryptAPIKey(ctx, u2)
+	read("user 2", key, ok, err)
+	ws, _, _, err := s.GetUserWorkspace(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, ok, err = s.DecryptWorkspaceAPIKey(ctx, ws)
+	read("workspace", key, ok, err)
+	return out
+}
+
+// makeLegacy rewrites user's key the way it was stored before versioning and owner binding:
+// version 0, no associated data.
+func makeLegacy(t *testing.T, s *Store, telegramUserID int64, secret string, apiKey string) {
+	t.Helper()
+	a, err := newAEAD(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce, ct, err := a.Encrypt([]byte(apiKey), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec(`
+UPDATE users SET api_key_ciphertext_b64=?, api_key_nonce_b64=?, api_key_version=0, api_key_bound=0 WHERE telegram_user_id=?
+`, base64.StdEncoding.EncodeToString(ct), base64.StdEncoding.EncodeToString(nonce), telegramUserID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type sealedRow struct {
+	ct, nonce string
+	version   int
+	bound     bool
+}
+
+func sealedUsers(t *testing.T, s *Store) map[int64]sealedRow {
+	t.Helper()
+	rows, err := s.db.Query(`SELECT telegram_user_id, api_key_ciphertext_b64, api_key_nonce_b64, api_key_version, api_key_bound FROM users`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	out := make(map[int64]sealedRow)
+	for rows.Next() {
+		var id int64
+		var r sealedRow
+		if err := rows.Scan(&id, &r.ct, &r.nonce, &r.version, &r.bound); err != nil {
+			t.Fatal(err)
+		}
+		out[id] = r
+	}
+	return out
+}
+
+func TestRotateAPIKeys(t *testing.T) {
+	ctx := context.Background()
+	s, path := openTestStore(t, keysV1)
+	want := seedAPIKeys(t, s)
+	_ = s.Close()
+
+	s = openTestStoreAt(t, path, keysRotating)
+	if n, err := s.StaleAPIKeys(ctx); err != nil || n != 4 {
+		t.Fatalf("StaleAPIKeys before rotation = %d, %v; want 4", n, err)
+	}
+	stats, err := s.RotateAPIKeys(ctx)
+	if err != nil {
+		t.Fatalf("RotateAPIKeys: %v", err)
+	}
+	if stats != (RotationStats{Users: 2, Profiles: 1, Workspaces: 1}) {
+		t.Errorf("RotateAPIKeys stats = %+v", stats)
+	}
+	if n, err := s.StaleAPIKeys(ctx); err != nil || n != 0 {
+		t.Errorf("StaleAPIKeys after rotation = %d, %v; want 0", n, err)
+	}
+	_ = s.Close()
+
+	// The old key can be dropped now.
+	s = openTestStoreAt(t, path, keysV2)
+	if got := readAPIKeys(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("keys after rotation = %v, want %v", got, want)
+	}
+}
+
+func TestRotateAPIKeysMixedBinding(t *testing.T) {
+	ctx := context.Background()
+	s, path := openTestStore(t, keysV1)
+	want := seedAPIKeys(t, s)
+	makeLegacy(t, s, 2, testMasterKey, "key-user-2")
+	_ = s.Close()
+
+	s = openTestStoreAt(t, path, keysRotating)
+	stats, err := s.RotateAPIKeys(ctx)
+	if err != nil {
+		t.Fatalf("RotateAPIKeys: %v", err)
+	}
+	if stats.Total() != 4 {
+		t.Errorf("RotateAPIKeys stats = %+v, want 4 keys", stats)
+	}
+	for id, r := range sealedUsers(t, s) {
+		if r.ct != "" && (r.version != 2 || !r.bound) {
+			t.Errorf("user %d after rotation: version %d, bound %v; want 2, true", id, r.version, r.bound)
+		}
+	}
+	_ = s.Close()
+
+	s = openTestStoreAt(t, path, keysV2)
+	if got := readAPIKeys(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("keys after rotation = %v, want %v", got, want)
+	}
+}
+
+// A legacy key is bound to its owner on first read, without rotation.
+func TestDecryptAPIKeyBindsLegacyKey(t *testing.T) {
+	ctx := context.Background()
+	s, _ := openTestStore(t, keysV1)
+	want := seedAPIKeys(t, s)
+	makeLegacy(t, s, 2, testMasterKey, "key-user-2")
+
+	if got := readAPIKeys(t, s); !reflect.DeepEqual(got, want) {
+		t.Fatalf("keys = %v, want %v", got, want)
+	}
+	r := sealedUsers(t, s)[2]
+	if r.version != 1 || !r.bound {
+		t.Errorf("legacy key after read: version %d, bound %v; want 1, true", r.version, r.bound)
+	}
+	if n, err := s.StaleAPIKeys(ctx); err != nil || n != 0 {
+		t.Errorf("StaleAPIKeys = %d, %v; want 0", n, err)
+	}
+}
+
+func TestRotateAPIKeysWrongPreviousKeyChangesNothing(t *testing.T) {
+	ctx := context.Background()
+	s, path := openTestStore(t, keysV1)
+	want := seedAPIKeys(t, s)
+	// User 1 goes first and opens with the new key, so the failure comes after a row was rewritten.
+	makeLegacy(t, s, 1, testMasterKeyNext, "key-user-1")
+	before := sealedUsers(t, s)
+	_ = s.Close()
+
+	s = openTestStoreAt(t, path, MasterKeys{Current: testMasterKeyNext, CurrentVersion: 2, Previous: "not the old key", PreviousVersion: 1})
+	if _, err := s.RotateAPIKeys(ctx); err == nil {
+		t.Fatal("RotateAPIKeys with a wrong previous key succeeded")
+	}
+	if after := sealedUsers(t, s); !reflect.DeepEqual(after, before) {
+		t.Errorf("users changed by a failed rotation:\nbefore %v\nafter  %v", before, after)
+	}
+	if n, err := s.StaleAPIKeys(ctx); err != nil || n != 4 {
+		t.Errorf("StaleAPIKeys after failed rotation = %d, %v; want 4", n, err)
+	}
+	_ = s.Close()
+
+	s = openTestStoreAt(t, path, MasterKeys{Current: testMasterKey, CurrentVersion: 1, Previous: testMasterKeyNext, PreviousVersion: 2})
+	if got := readAPIKeys(t, s); !reflect.DeepEqual(got, want) {
+		t.Errorf("keys after failed rotation = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const pendingInputTTL = time.Hour
+
+// PendingInput is a question the bot asked with ForceReply; the user's reply to PromptMessageID answers it.
+// A user has at most one pending input: asking a new question replaces the previous one.
+type PendingInput struct {
+	TelegramUserID  int64
+	Kind            string
+	Payload         string
+	ChatID          int64
+	PromptMessageID int
+	CreatedAt       time.Time
+}
+
+func (s *Store) SetPendingInput(ctx context.Context, p PendingInput) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO pending_inputs (telegram_user_id, kind, payload, chat_id, prompt_message_id, created_at)
+VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT(telegram_user_id) DO UPDATE SET
+  kind=excluded.kind, payload=excluded.payload, chat_id=excluded.chat_id,
+  prompt_message_id=excluded.prompt_message_id, created_at=excluded.created_at
+`, p.TelegramUserID, p.Kind, p.Payload, p.ChatID, p.PromptMessageID, now)
+	return err
+}
+
+// TakePendingInput returns and removes the user's pending input if it was asked by promptMessageID
+// in chatID and has not expired.
+func (s *Store) TakePendingInput(ctx context.Context, telegramUserID int64, chatID int64, promptMessageID int) (PendingInput, bool, error) {
+	p := PendingInput{TelegramUserID: telegramUserID}
+	var createdAt string
+	err := s.db.QueryRowContext(ctx, `
+SELECT kind, payload, chat_id, prompt_message_id, created_at
+FROM pending_inputs WHERE telegram_user_id=? AND chat_id=? AND prompt_message_id=?
+`, telegramUserID, chatID, promptMessageID).Scan(&p.Kind, &p.Payload, &p.ChatID, &p.PromptMessageID, &createdAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return PendingInput{}, false, nil
+		}
+		return PendingInput{}, false, err
+	}
+	p.CreatedAt, _ = time.Parse(time.RFC3339Nano, createdAt)
+
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM pending_inputs WHERE telegram_user_id=?`, telegramUserID); err != nil {
+		return PendingInput{}, false, err
+	}
+	if time.Since(p.CreatedAt) > pendingInputTTL {
+		return PendingInput{}, false, nil
+	}
+	return p, true, nil
+}
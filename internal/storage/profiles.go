@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultProfile names the server/key stored on the users row itself; it always exists and can't be removed.
+const DefaultProfile = "default"
+
+var (
+	ErrProfileExists   = errors.New("profile already exists")
+	ErrProfileNotFound = errors.New("profile not found")
+)
+
+var profileNameRE = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// ValidProfileName reports whether name (already lower-cased) can be used as a profile name.
+func ValidProfileName(name string) bool {
+	return profileNameRE.MatchString(name)
+}
+
+// Profile is a named Karakeep server + API key of a user, e.g. "work" next to the personal default.
+type Profile struct {
+	TelegramUserID int64
+	Name           string
+
+	ServerBaseURL string
+
+	APIKeyCiphertextB64 string
+	APIKeyNonceB64      string
+	APIKeyVersion       int
+	APIKeyBound         bool
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Profile returns the user's profile by name; an empty name means the active profile.
+func (u User) Profile(name string) (Profile, bool) {
+	if name == "" {
+		name = u.ActiveProfile
+	}
+	name = strings.ToLower(name)
+	if name == "" || name == DefaultProfile {
+		return Profile{
+			TelegramUserID:      u.TelegramUserID,
+			Name:                DefaultProfile,
+			ServerBaseURL:       u.ServerBaseURL,
+			APIKeyCiphertextB64: u.APIKeyCiphertextB64,
+			APIKeyNonceB64:      u.APIKeyNonceB64,
+			APIKeyVersion:       u.APIKeyVersion,
+			APIKeyBound:         u.APIKeyBound,
+			CreatedAt:           u.CreatedAt,
+			UpdatedAt:           u.UpdatedAt,
+		}, true
+	}
+	for _, p := range u.Profiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Profile{}, false
+}
+
+// ProfileNames lists all profile names of the user, the default one first.
+func (u User) ProfileNames() []string {
+	names := []string{DefaultProfile}
+	for _, p := range u.Profiles {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+// ActiveProfileName is the profile new saves use when the message doesn't pick one.
+func (u User) ActiveProfileName() string {
+	if u.ActiveProfile == "" {
+		return DefaultProfile
+	}
+	return u.ActiveProfile
+}
+
+func (s *Store) DecryptProfileAPIKey(ctx context.Context, p Profile) (string, bool, error) {
+	return s.decryptAPIKey(ctx, profileKeyOwner(p.TelegramUserID, p.Name), p.APIKeyCiphertextB64, p.APIKeyNonceB64, p.APIKeyVersion, p.APIKeyBound)
+}
+
+// profileKeyOwner binds a named profile's key to both the user and the name; the default profile is the users row.
+func profileKeyOwner(telegramUserID int64, name string) apiKeyOwner {
+	if name == "" || name == DefaultProfile {
+		return userKeyOwner(telegramUserID)
+	}
+	return apiKeyOwner{"profiles", "telegram_user_id=? AND name=?", []any{telegramUserID, name}, fmt.Sprintf("profile:%d:%s", telegramUserID, name)}
+}
+
+// AddProfile creates an empty named profile; serverBaseURL may be empty and set later with /server.
+func (s *Store) AddProfile(ctx context.Context, telegramUserID int64, name string, serverBaseURL string) error {
+	if !ValidProfileName(name) || name == DefaultProfile {
+		return errors.New("invalid profile name")
+	}
+	var n int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM profiles WHERE telegram_user_id=? AND name=?`, telegramUserID, name).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrProfileExists
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO profiles (telegram_user_id, name, server_base_url, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?)
+`, telegramUserID, name, serverBaseURL, now, now)
+	return err
+}
+
+// RemoveProfile deletes a named profile; if it was active, the user falls back to the default profile.
+func (s *Store) RemoveProfile(ctx context.Context, telegramUserID int64, name string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM profiles WHERE telegram_user_id=? AND name=?`, telegramUserID, name)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	_, err = s.db.ExecContext(ctx, `
+UPDATE users SET active_profile='', updated_at=? WHERE telegram_user_id=? AND active_profile=?
+`, now, telegramUserID, name)
+	return true, err
+}
+
+// SetActiveProfile switches the profile used by new saves, /server and /key.
+func (s *Store) SetActiveProfile(ctx context.Context, telegramUserID int64, name string) error {
+	if name == DefaultProfile {
+		name = ""
+	}
+	if name != "" {
+		var n int
+		if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM profiles WHERE telegram_user_id=? AND name=?`, telegramUserID, name).Scan(&n); err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrProfileNotFound
+		}
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	_, err := s.db.ExecContext(ctx, `
+UPDATE users SET active_profile=?, updated_at=? WHERE telegram_user_id=?
+`, name, now, telegramUserID)
+	return err
+}
+
+// SetProfileServerBaseURL sets the server of a profile; the default profile lives on the users row.
+func (s *Store) SetProfileServerBaseURL(ctx context.Context, telegramUserID int64, name string, serverBaseURL string) error {
+	if name == "" || name == DefaultProfile {
+		return s.SetServerBaseURL(ctx, telegramUserID, serverBaseURL)
+	}
+	if stringsTrim(serverBaseURL) == "" {
+		return errors.New("server base url is empty")
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	_, err := s.db.ExecContext(ctx, `
+UPDATE profiles SET server_base_url=?, updated_at=? WHERE telegram_user_id=? AND name=?
+`, serverBaseURL, now, telegramUserID, name)
+	return err
+}
+
+func (s *Store) SetProfileAPIKey(ctx context.Context, telegramUserID int64, name string, apiKey string) error {
+	if name == "" || name == DefaultProfile {
+		return s.SetAPIKey(ctx, telegramUserID, apiKey)
+	}
+	if stringsTrim(apiKey) == "" {
+		return errors.New("api key is empty")
+	}
+	ctB64, nonceB64, version, err := s.encryptAPIKey(apiKey, profileKeyOwner(telegramUserID, name))
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	_, err = s.db.ExecContext(ctx, `
+UPDATE profiles SET api_key_ciphertext_b64=?, api_key_nonce_b64=?, api_key_version=?, api_key_bound=1, updated_at=? WHERE telegram_user_id=? AND name=?
+`, ctB64, nonceB64, version, now, telegramUserID, name)
+	return err
+}
+
+func (s *Store) listProfiles(ctx context.Context, telegramUserID int64) ([]Profile, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT name, server_base_url, api_key_ciphertext_b64, api_key_nonce_b64, api_key_version, api_key_bound, created_at, updated_at
+FROM profiles WHERE telegram_user_id=? ORDER BY name
+`, telegramUserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Profile
+	for rows.Next() {
+		p := Profile{TelegramUserID: telegramUserID}
+		var createdAt, updatedAt string
+		if err := rows.Scan(&p.Name, &p.ServerBaseURL, &p.APIKeyCiphertextB64, &p.APIKeyNonceB64, &p.APIKeyVersion, &p.APIKeyBound, &createdAt, &updatedAt); err != nil {
+			return nil, err
+		}
+		p.CreatedAt, _ = time.Parse(time.RFC3339Nano, createdAt)
+		p.UpdatedAt, _ = time.Parse(time.RFC3339Nano, updatedAt)
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
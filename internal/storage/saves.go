@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type SaveStatus string
+
+const (
+	SavePending SaveStatus = "pending"
+	SaveSaved   SaveStatus = "saved"
+	SaveFailed  SaveStatus = "failed"
+	SaveUndone  SaveStatus = "undone"
+	SaveDeleted SaveStatus = "deleted"
+)
+
+// Save links a Telegram message to the Karakeep bookmark created from it.
+type Save struct {
+	TelegramUserID int64
+	ChatID         int64
+	MessageID      int
+
+	BookmarkID string
+	// Kind is classifier.Kind as a string (storage does not depend on the classifier).
+	Kind   string
+	Status SaveStatus
+	Error  string
+
+	// Profile is the user's profile the bookmark was saved with; empty for workspace saves and older rows.
+	Profile string
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// RecordSave inserts or updates the save for (user, chat, message). An empty BookmarkID or Kind
+// keeps the previously stored value, so progress updates don't need to repeat them.
+func (s *Store) RecordSave(ctx context.Context, sv Save) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO saves (telegram_user_id, chat_id, message_id, bookmark_id, kind, status, error, created_at, updated_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(telegram_user_id, chat_id, message_id) DO UPDATE SET
+  bookmark_id=CASE WHEN excluded.bookmark_id<>'' THEN excluded.bookmark_id ELSE saves.bookmark_id END,
+  kind=CASE WHEN excluded.kind<>'' THEN excluded.kind ELSE saves.kind END,
+  status=excluded.status,
+  error=excluded.error,
+  updated_at=excluded.updated_at
+`, sv.TelegramUserID, sv.ChatID, sv.MessageID, sv.BookmarkID, sv.Kind, sv.Status, sv.Error, now, now)
+	return err
+}
+
+// SetSaveStatusByBookmark updates every save of telegramUserID that points at bookmarkID.
+func (s *Store) SetSaveStatusByBookmark(ctx context.Context, telegramUserID int64, bookmarkID string, status SaveStatus) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	_, err := s.db.ExecContext(ctx, `
+UPDATE saves SET status=?, updated_at=?
+WHERE telegram_user_id=? AND bookmark_id=?
+`, status, now, telegramUserID, bookmarkID)
+	return err
+}
+
+// SetSaveProfile remembers which profile the save used, so later actions on the bookmark reach the same Karakeep.
+func (s *Store) SetSaveProfile(ctx context.Context, telegramUserID int64, chatID int64, messageID int, profile string) error {
+	_, err := s.db.ExecContext(ctx, `
+UPDATE saves SET profile=? WHERE telegram_user_id=? AND chat_id=? AND message_id=?
+`, profile, telegramUserID, chatID, messageID)
+	return err
+}
+
+// GetSaveByBookmark returns the user's latest save that points at bookmarkID.
+func (s *Store) GetSaveByBookmark(ctx context.Context, telegramUserID int64, bookmarkID string) (Save, bool, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT `+saveColumns+` FROM saves WHERE telegram_user_id=? AND bookmark_id=? ORDER BY rowid DESC LIMIT 1
+`, telegramUserID, bookmarkID)
+	if err != nil {
+		return Save{}, false, err
+	}
+	saves, err := scanSaves(rows)
+	if err != nil {
+		return Save{}, false, err
+	}
+	if len(saves) == 0 {
+		return Save{}, false, nil
+	}
+	return saves[0], true, nil
+}
+
+// GetSaveByMessage finds the save created from a Telegram message, regardless of who sent it
+// (a reply in a group may come from another member).
+func (s *Store) GetSaveByMessage(ctx context.Context, chatID int64, messageID int) (Save, bool, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT `+saveColumns+` FROM saves WHERE chat_id=? AND message_id=? ORDER BY rowid DESC LIMIT 1
+`, chatID, messageID)
+	if err != nil {
+		return Save{}, false, err
+	}
+	saves, err := scanSaves(rows)
+	if err != nil {
+		return Save{}, false, err
+	}
+	if len(saves) == 0 {
+		return Save{}, false, nil
+	}
+	return saves[0], true, nil
+}
+
+// RecentSaves returns the user's last n saves, newest first.
+func (s *Store) RecentSaves(ctx context.Context, telegramUserID int64, n int) ([]Save, error) {
+	if n <= 0 {
+		n = 10
+	}
+	rows, err := s.db.QueryContext(ctx, `
+SELECT `+saveColumns+` FROM saves WHERE telegram_user_id=? ORDER BY rowid DESC LIMIT ?
+`, telegramUserID, n)
+	if err != nil {
+		return nil, err
+	}
+	return scanSaves(rows)
+}
+
+const saveColumns = `telegram_user_id, chat_id, message_id, bookmark_id, kind, status, error, profile, created_at, updated_at`
+
+func scanSaves(rows *sql.Rows) ([]Save, error) {
+	defer rows.Close()
+	var out []Save
+	for rows.Next() {
+		var sv Save
+		var status, createdAt, updatedAt string
+		if err := rows.Scan(&sv.TelegramUserID, &sv.ChatID, &sv.MessageID, &sv.BookmarkID, &sv.Kind, &status, &sv.Error, &sv.Profile, &createdAt, &updatedAt); err != nil {
+			return nil, err
+		}
+		sv.Status = SaveStatus(status)
+		sv.CreatedAt, _ = time.Parse(time.RFC3339Nano, createdAt)
+		sv.UpdatedAt, _ = time.Parse(time.RFC3339Nano, updatedAt)
+		out = append(out, sv)
+	}
+	return out, rows.Err()
+}
@@ -17,7 +17,7 @@ import (
 
 type Store struct {
 	db   *sql.DB
-	aead *crypto.AEAD
+	keys *crypto.Keyring
 }
 
 type User struct {
@@ -27,19 +27,51 @@ type User struct {
 
 	APIKeyCiphertextB64 string
 	APIKeyNonceB64      string
+	// APIKeyVersion is the master key version the API key is sealed with (0: written before versioning).
+	APIKeyVersion int
+	// APIKeyBound is set once the ciphertext is bound to this user (see apiKeyOwner).
+	APIKeyBound bool
 
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 	LastSuccessAt sql.NullTime
 	LastSuccessID sql.NullString
+
+	// Default Karakeep list for new bookmarks; empty means inbox only.
+	DefaultListID   string
+	DefaultListName string
+
+	// MultiURLMode controls messages with several links: MultiURLNote (default) or MultiURLSplit.
+	MultiURLMode string
+
+	// ActiveProfile is the profile new saves use; empty means DefaultProfile (the server/key above).
+	ActiveProfile string
+	// Profiles are the user's named profiles besides the default one, sorted by name.
+	Profiles []Profile
 }
 
-func Open(ctx context.Context, dbPath string, masterKey string) (*Store, error) {
+const (
+	MultiURLNote  = "note"
+	MultiURLSplit = "split"
+)
+
+// MasterKeys are the secrets API keys are encrypted with. New keys are sealed with Current;
+// Previous (optional) stays readable while a rotation is in progress.
+type MasterKeys struct {
+	Current        string
+	CurrentVersion int
+
+	Previous        string
+	PreviousVersion int
+}
+
+func Open(ctx context.Context, dbPath string, masterKeys MasterKeys) (*Store, error) {
 	if stringsTrim(dbPath) == "" {
 		return nil, errors.New("db path is empty")
 	}
-	if stringsTrim(masterKey) == "" {
-		return nil, errors.New("master key is empty")
+	keys, err := newKeyring(masterKeys)
+	if err != nil {
+		return nil, err
 	}
 
 	if err := os.MkdirAll(filepath.Dir(dbPath), 0o700); err != nil {
@@ -53,24 +85,48 @@ func Open(ctx context.Context, dbPath string, masterKey string) (*Store, error)
 	db.SetMaxOpenConns(1)
 	db.SetConnMaxLifetime(0)
 
-	k, err := crypto.DeriveKeyFromSecret(masterKey)
+	s := &Store{db: db, keys: keys}
+	if err := s.migrate(ctx); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) Close() error { return s.db.Close() }
+
+func newKeyring(mk MasterKeys) (*crypto.Keyring, error) {
+	if stringsTrim(mk.Current) == "" {
+		return nil, errors.New("master key is empty")
+	}
+	current, err := newAEAD(mk.Current)
 	if err != nil {
 		return nil, err
 	}
-	a, err := crypto.NewAEAD(k)
+	keys, err := crypto.NewKeyring(mk.CurrentVersion, current)
 	if err != nil {
 		return nil, err
 	}
-
-	s := &Store{db: db, aead: a}
-	if err := s.migrate(ctx); err != nil {
-		_ = db.Close()
+	if stringsTrim(mk.Previous) == "" {
+		return keys, nil
+	}
+	previous, err := newAEAD(mk.Previous)
+	if err != nil {
 		return nil, err
 	}
-	return s, nil
+	if err := keys.Add(mk.PreviousVersion, previous); err != nil {
+		return nil, fmt.Errorf("previous master key: %w", err)
+	}
+	return keys, nil
 }
 
-func (s *Store) Close() error { return s.db.Close() }
+func newAEAD(secret string) (*crypto.AEAD, error) {
+	k, err := crypto.DeriveKeyFromSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.NewAEAD(k)
+}
 
 func (s *Store) migrate(ctx context.Context) error {
 	const ddl = `
@@ -84,14 +140,208 @@ CREATE TABLE IF NOT EXISTS users (
   last_success_at TEXT,
   last_success_id TEXT
 );
+
+CREATE TABLE IF NOT EXISTS jobs (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  kind TEXT NOT NULL,
+  telegram_user_id INTEGER NOT NULL,
+  chat_id INTEGER NOT NULL,
+  message_id INTEGER NOT NULL,
+  payload TEXT NOT NULL,
+  status TEXT NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  last_error TEXT NOT NULL DEFAULT '',
+  ack_message_id INTEGER NOT NULL DEFAULT 0,
+  bookmark_id TEXT NOT NULL DEFAULT '',
+  next_run_at INTEGER NOT NULL,
+  created_at TEXT NOT NULL,
+  updated_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS jobs_status_next_run_at ON jobs (status, next_run_at);
+CREATE INDEX IF NOT EXISTS jobs_chat_message ON jobs (chat_id, message_id);
+
+CREATE TABLE IF NOT EXISTS callback_data (
+  token TEXT PRIMARY KEY,
+  telegram_user_id INTEGER NOT NULL,
+  payload TEXT NOT NULL,
+  created_at TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS pending_inputs (
+  telegram_user_id INTEGER PRIMARY KEY,
+  kind TEXT NOT NULL,
+  payload TEXT NOT NULL,
+  chat_id INTEGER NOT NULL,
+  prompt_message_id INTEGER NOT NULL,
+  created_at TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS saves (
+  telegram_user_id INTEGER NOT NULL,
+  chat_id INTEGER NOT NULL,
+  message_id INTEGER NOT NULL,
+  bookmark_id TEXT NOT NULL DEFAULT '',
+  kind TEXT NOT NULL DEFAULT '',
+  status TEXT NOT NULL,
+  error TEXT NOT NULL DEFAULT '',
+  created_at TEXT NOT NULL,
+  updated_at TEXT NOT NULL,
+  PRIMARY KEY (telegram_user_id, chat_id, message_id)
+);
+CREATE INDEX IF NOT EXISTS saves_chat_message ON saves (chat_id, message_id);
+CREATE INDEX IF NOT EXISTS saves_bookmark ON saves (bookmark_id);
+CREATE INDEX IF NOT EXISTS saves_user_updated ON saves (telegram_user_id, updated_at);
+
+CREATE TABLE IF NOT EXISTS history (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  telegram_user_id INTEGER NOT NULL,
+  action TEXT NOT NULL,
+  bookmark_id TEXT NOT NULL DEFAULT '',
+  detail TEXT NOT NULL DEFAULT '',
+  created_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS history_user ON history (telegram_user_id, id);
+
+CREATE TABLE IF NOT EXISTS chat_settings (
+  chat_id INTEGER PRIMARY KEY,
+  enabled INTEGER NOT NULL DEFAULT 1,
+  on_mention INTEGER NOT NULL DEFAULT 1,
+  on_reply INTEGER NOT NULL DEFAULT 1,
+  trigger_tag TEXT NOT NULL DEFAULT '',
+  updated_at TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS channel_bindings (
+  channel_id INTEGER PRIMARY KEY,
+  title TEXT NOT NULL DEFAULT '',
+  telegram_user_id INTEGER NOT NULL,
+  list_id TEXT NOT NULL DEFAULT '',
+  list_name TEXT NOT NULL DEFAULT '',
+  tags TEXT NOT NULL DEFAULT '',
+  created_at TEXT NOT NULL,
+  updated_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS channel_bindings_user ON channel_bindings (telegram_user_id);
+
+CREATE TABLE IF NOT EXISTS profiles (
+  telegram_user_id INTEGER NOT NULL,
+  name TEXT NOT NULL,
+  server_base_url TEXT NOT NULL DEFAULT '',
+  api_key_ciphertext_b64 TEXT NOT NULL DEFAULT '',
+  api_key_nonce_b64 TEXT NOT NULL DEFAULT '',
+  created_at TEXT NOT NULL,
+  updated_at TEXT NOT NULL,
+  PRIMARY KEY (telegram_user_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS workspaces (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL,
+  server_base_url TEXT NOT NULL DEFAULT '',
+  api_key_ciphertext_b64 TEXT NOT NULL DEFAULT '',
+  api_key_nonce_b64 TEXT NOT NULL DEFAULT '',
+  created_at TEXT NOT NULL,
+  updated_at TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS workspace_members (
+  telegram_user_id INTEGER PRIMARY KEY,
+  workspace_id INTEGER NOT NULL,
+  role TEXT NOT NULL,
+  joined_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS workspace_members_workspace ON workspace_members (workspace_id);
+
+CREATE TABLE IF NOT EXISTS workspace_invites (
+  code TEXT PRIMARY KEY,
+  workspace_id INTEGER NOT NULL,
+  role TEXT NOT NULL,
+  created_by INTEGER NOT NULL,
+  expires_at TEXT NOT NULL,
+  created_at TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS access_grants (
+  subject TEXT PRIMARY KEY,
+  username TEXT NOT NULL DEFAULT '',
+  added_by INTEGER NOT NULL,
+  created_at TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS access_invites (
+  code TEXT PRIMARY KEY,
+  created_by INTEGER NOT NULL,
+  expires_at TEXT NOT NULL,
+  created_at TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS bot_state (
+  key TEXT PRIMARY KEY,
+  value TEXT NOT NULL,
+  updated_at TEXT NOT NULL
+);
 `
 	_, err := s.db.ExecContext(ctx, ddl)
 	if err != nil {
 		return fmt.Errorf("migrate: %w", err)
 	}
+
+	// Columns added after the initial schema; CREATE TABLE IF NOT EXISTS does not touch existing tables.
+	// backfill, if set, runs once when the column is added, to fill it in for existing rows.
+	columns := []struct{ table, column, decl, backfill string }{
+		{"users", "default_list_id", "TEXT NOT NULL DEFAULT ''", ""},
+		{"users", "default_list_name", "TEXT NOT NULL DEFAULT ''", ""},
+		{"users", "multi_url_mode", "TEXT NOT NULL DEFAULT ''", ""},
+		{"users", "active_profile", "TEXT NOT NULL DEFAULT ''", ""},
+		{"saves", "profile", "TEXT NOT NULL DEFAULT ''", ""},
+		{"users", "api_key_version", "INTEGER NOT NULL DEFAULT 0", ""},
+		{"profiles", "api_key_version", "INTEGER NOT NULL DEFAULT 0", ""},
+		{"workspaces", "api_key_version", "INTEGER NOT NULL DEFAULT 0", ""},
+		{"users", "api_key_bound", "INTEGER NOT NULL DEFAULT 0", ""},
+		{"profiles", "api_key_bound", "INTEGER NOT NULL DEFAULT 0", ""},
+		{"workspaces", "api_key_bound", "INTEGER NOT NULL DEFAULT 0", ""},
+		// Jobs from before stages only set bookmark_id once everything but enrichment was done.
+		{"jobs", "stage", "INTEGER NOT NULL DEFAULT 0", `UPDATE jobs SET stage=2 WHERE bookmark_id != ''`}, // JobStageSetUp
+		{"jobs", "assets_attached", "INTEGER NOT NULL DEFAULT 0", ""},
+		{"jobs", "footer", "TEXT NOT NULL DEFAULT ''", ""},
+	}
+	for _, c := range columns {
+		added, err := s.addColumnIfMissing(ctx, c.table, c.column, c.decl)
+		if err != nil {
+			return fmt.Errorf("migrate %s.%s: %w", c.table, c.column, err)
+		}
+		if added && c.backfill != "" {
+			if _, err := s.db.ExecContext(ctx, c.backfill); err != nil {
+				return fmt.Errorf("migrate %s.%s: backfill: %w", c.table, c.column, err)
+			}
+		}
+	}
 	return nil
 }
 
+func (s *Store) addColumnIfMissing(ctx context.Context, table, column, decl string) (bool, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return false, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	_ = rows.Close()
+	_, err = s.db.ExecContext(ctx, `ALTER TABLE `+table+` ADD COLUMN `+column+` `+decl)
+	return err == nil, err
+}
+
 func (s *Store) UpsertUser(ctx context.Context, telegramUserID int64) error {
 	now := time.Now().UTC().Format(time.RFC3339Nano)
 	_, err := s.db.ExecContext(ctx, `
@@ -110,16 +360,22 @@ func (s *Store) GetUser(ctx context.Context, telegramUserID int64) (User, error)
 	var lastSuccessAt sql.NullString
 
 	err := s.db.QueryRowContext(ctx, `
-SELECT server_base_url, api_key_ciphertext_b64, api_key_nonce_b64, created_at, updated_at, last_success_at, last_success_id
+SELECT server_base_url, api_key_ciphertext_b64, api_key_nonce_b64, api_key_version, api_key_bound, created_at, updated_at, last_success_at, last_success_id, default_list_id, default_list_name, multi_url_mode, active_profile
 FROM users WHERE telegram_user_id=?
 `, telegramUserID).Scan(
 		&u.ServerBaseURL,
 		&u.APIKeyCiphertextB64,
 		&u.APIKeyNonceB64,
+		&u.APIKeyVersion,
+		&u.APIKeyBound,
 		&createdAt,
 		&updatedAt,
 		&lastSuccessAt,
 		&u.LastSuccessID,
+		&u.DefaultListID,
+		&u.DefaultListName,
+		&u.MultiURLMode,
+		&u.ActiveProfile,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -135,6 +391,9 @@ FROM users WHERE telegram_user_id=?
 			u.LastSuccessAt = sql.NullTime{Time: t, Valid: true}
 		}
 	}
+	if u.Profiles, err = s.listProfiles(ctx, telegramUserID); err != nil {
+		return User{}, err
+	}
 	return u, nil
 }
 
@@ -155,32 +414,93 @@ func (s *Store) SetAPIKey(ctx context.Context, telegramUserID int64, apiKey stri
 		return errors.New("api key is empty")
 	}
 
-	nonce, ct, err := s.aead.Encrypt([]byte(apiKey))
+	ctB64, nonceB64, version, err := s.encryptAPIKey(apiKey, userKeyOwner(telegramUserID))
 	if err != nil {
 		return err
 	}
 
 	now := time.Now().UTC().Format(time.RFC3339Nano)
 	_, err = s.db.ExecContext(ctx, `
-UPDATE users SET api_key_ciphertext_b64=?, api_key_nonce_b64=?, updated_at=?
+UPDATE users SET api_key_ciphertext_b64=?, api_key_nonce_b64=?, api_key_version=?, api_key_bound=1, updated_at=?
 WHERE telegram_user_id=?
-`, base64.StdEncoding.EncodeToString(ct), base64.StdEncoding.EncodeToString(nonce), now, telegramUserID)
+`, ctB64, nonceB64, version, now, telegramUserID)
+	return err
+}
+
+func (s *Store) DecryptAPIKey(ctx context.Context, u User) (string, bool, error) {
+	return s.decryptAPIKey(ctx, userKeyOwner(u.TelegramUserID), u.APIKeyCiphertextB64, u.APIKeyNonceB64, u.APIKeyVersion, u.APIKeyBound)
+}
+
+// apiKeyOwner is the row a stored API key belongs to. Its ad is authenticated together with the
+// ciphertext, so a key copied into another user's, profile's or workspace's row no longer decrypts.
+type apiKeyOwner struct {
+	table string
+	where string
+	args  []any
+	ad    string
+}
+
+func userKeyOwner(telegramUserID int64) apiKeyOwner {
+	return apiKeyOwner{"users", "telegram_user_id=?", []any{telegramUserID}, fmt.Sprintf("user:%d", telegramUserID)}
+}
+
+// encryptAPIKey seals apiKey for owner with the current master key; returns base64 ciphertext and nonce
+// as stored in SQLite, plus the key version to store next to them.
+func (s *Store) encryptAPIKey(apiKey string, owner apiKeyOwner) (ctB64 string, nonceB64 string, version int, err error) {
+	version, nonce, ct, err := s.keys.Encrypt([]byte(apiKey), []byte(owner.ad))
+	if err != nil {
+		return "", "", 0, err
+	}
+	return base64.StdEncoding.EncodeToString(ct), base64.StdEncoding.EncodeToString(nonce), version, nil
+}
+
+// decryptAPIKey opens owner's stored key. Keys written before owner binding (bound=false) are opened
+// without associated data and re-sealed for owner on the spot, so each legacy row is upgraded on first read.
+func (s *Store) decryptAPIKey(ctx context.Context, owner apiKeyOwner, ctB64 string, nonceB64 string, version int, bound bool) (string, bool, error) {
+	apiKey, ok, err := s.openAPIKey(owner, ctB64, nonceB64, version, bound)
+	if err != nil || !ok || bound {
+		return apiKey, ok, err
+	}
+	// A failed upgrade is retried on the next read; the key itself is fine.
+	_ = s.bindAPIKey(ctx, s.db, owner, apiKey, ctB64)
+	return apiKey, true, nil
+}
+
+// bindAPIKey re-seals apiKey for owner, unless the row's ciphertext changed since it was read (prevCtB64).
+func (s *Store) bindAPIKey(ctx context.Context, db execer, owner apiKeyOwner, apiKey string, prevCtB64 string) error {
+	ctB64, nonceB64, version, err := s.encryptAPIKey(apiKey, owner)
+	if err != nil {
+		return err
+	}
+	args := append([]any{ctB64, nonceB64, version}, owner.args...)
+	_, err = db.ExecContext(ctx, `
+UPDATE `+owner.table+` SET api_key_ciphertext_b64=?, api_key_nonce_b64=?, api_key_version=?, api_key_bound=1
+WHERE `+owner.where+` AND api_key_ciphertext_b64=?
+`, append(args, prevCtB64)...)
 	return err
 }
 
-func (s *Store) DecryptAPIKey(u User) (string, bool, error) {
-	if stringsTrim(u.APIKeyCiphertextB64) == "" || stringsTrim(u.APIKeyNonceB64) == "" {
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func (s *Store) openAPIKey(owner apiKeyOwner, ctB64 string, nonceB64 string, version int, bound bool) (string, bool, error) {
+	if stringsTrim(ctB64) == "" || stringsTrim(nonceB64) == "" {
 		return "", false, nil
 	}
-	ct, err := base64.StdEncoding.DecodeString(u.APIKeyCiphertextB64)
+	ct, err := base64.StdEncoding.DecodeString(ctB64)
 	if err != nil {
 		return "", false, fmt.Errorf("decode api_key ciphertext: %w", err)
 	}
-	nonce, err := base64.StdEncoding.DecodeString(u.APIKeyNonceB64)
+	nonce, err := base64.StdEncoding.DecodeString(nonceB64)
 	if err != nil {
 		return "", false, fmt.Errorf("decode api_key nonce: %w", err)
 	}
-	pt, err := s.aead.Decrypt(nonce, ct)
+	var ad []byte
+	if bound {
+		ad = []byte(owner.ad)
+	}
+	pt, err := s.keys.Decrypt(version, nonce, ct, ad)
 	if err != nil {
 		return "", false, err
 	}
@@ -196,6 +516,39 @@ WHERE telegram_user_id=?
 	return err
 }
 
+// ClearLastSuccess forgets the last saved bookmark, but only if it is still bookmarkID
+// (a newer save must not be hidden by undoing an older one).
+func (s *Store) ClearLastSuccess(ctx context.Context, telegramUserID int64, bookmarkID string) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	_, err := s.db.ExecContext(ctx, `
+UPDATE users SET last_success_id=NULL, updated_at=?
+WHERE telegram_user_id=? AND last_success_id=?
+`, now, telegramUserID, bookmarkID)
+	return err
+}
+
+// SetDefaultList stores the list new bookmarks are added to; pass empty strings to reset to inbox only.
+func (s *Store) SetDefaultList(ctx context.Context, telegramUserID int64, listID string, listName string) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	_, err := s.db.ExecContext(ctx, `
+UPDATE users SET default_list_id=?, default_list_name=?, updated_at=?
+WHERE telegram_user_id=?
+`, listID, listName, now, telegramUserID)
+	return err
+}
+
+func (s *Store) SetMultiURLMode(ctx context.Context, telegramUserID int64, mode string) error {
+	if mode != MultiURLNote && mode != MultiURLSplit {
+		return fmt.Errorf("unknown multi url mode: %q", mode)
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	_, err := s.db.ExecContext(ctx, `
+UPDATE users SET multi_url_mode=?, updated_at=?
+WHERE telegram_user_id=?
+`, mode, now, telegramUserID)
+	return err
+}
+
 func stringsTrim(s string) string {
 	// tiny helper to avoid pulling strings in every file
 	i := 0
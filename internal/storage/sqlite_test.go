@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	testMasterKey     = "test master key one"
+	testMasterKeyNext = "test master key two"
+)
+
+// openTestStore opens a fresh database under t.TempDir; reopen it with other keys via openTestStoreAt.
+func openTestStore(t *testing.T, keys MasterKeys) (*Store, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "bot.sqlite")
+	return openTestStoreAt(t, path, keys), path
+}
+
+func openTestStoreAt(t *testing.T, path string, keys MasterKeys) *Store {
+	t.Helper()
+	s, err := Open(context.Background(), path, keys)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+// A sealed API key copied into another user's, profile's or workspace's row must not decrypt there.
+func TestAPIKeyBoundToOwner(t *testing.T) {
+	ctx := context.Background()
+	s, _ := openTestStore(t, MasterKeys{Current: testMasterKey, CurrentVersion: 1})
+	seedAPIKeys(t, s)
+	if err := s.UpsertUser(ctx, 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddProfile(ctx, 1, "home", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateWorkspace(ctx, 4, "other", "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	copyKey := func(fromTable, fromWhere, toTable, toWhere string) {
+		t.Helper()
+		if _, err := s.db.Exec(`
+UPDATE ` + toTable + ` SET (api_key_ciphertext_b64, api_key_nonce_b64, api_key_version, api_key_bound) =
+  (SELECT api_key_ciphertext_b64, api_key_nonce_b64, api_key_version, api_key_bound FROM ` + fromTable + ` WHERE ` + fromWhere + `)
+WHERE ` + toWhere); err != nil {
+			t.Fatal(err)
+		}
+	}
+	copyKey("users", "telegram_user_id=1", "users", "telegram_user_id=4")
+	copyKey("profiles", "telegram_user_id=1 AND name='work'", "profiles", "telegram_user_id=1 AND name='home'")
+	copyKey("users", "telegram_user_id=2", "users", "telegram_user_id=1")
+	copyKey("workspaces", "id=(SELECT workspace_id FROM workspace_members WHERE telegram_user_id=3)",
+		"workspaces", "id=(SELECT workspace_id FROM workspace_members WHERE telegram_user_id=4)")
+
+	u4, err := s.GetUser(ctx, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, _, err := s.DecryptAPIKey(ctx, u4); err == nil {
+		t.Errorf("user 1's key opened as user 4's: %q", key)
+	}
+	u1, err := s.GetUser(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, _, err := s.DecryptAPIKey(ctx, u1); err == nil {
+		t.Errorf("user 2's key opened as user 1's: %q", key)
+	}
+	home, _ := u1.Profile("home")
+	if key, _, err := s.DecryptProfileAPIKey(ctx, home); err == nil {
+		t.Errorf("profile work's key opened as profile home's: %q", key)
+	}
+	work, _ := u1.Profile("work")
+	if key, _, err := s.DecryptProfileAPIKey(ctx, work); err != nil || key != "key-profile-work" {
+		t.Errorf("profile work's own key = %q, %v", key, err)
+	}
+	ws, _, _, err := s.GetUserWorkspace(ctx, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, _, err := s.DecryptWorkspaceAPIKey(ctx, ws); err == nil {
+		t.Errorf("workspace key opened in another workspace: %q", key)
+	}
+}
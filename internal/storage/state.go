@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Keys for the bot_state table (process-wide settings, not per user).
+const (
+	StateTelegramMode = "telegram.mode"
+	StateLastUpdateID = "telegram.last_update_id"
+)
+
+func (s *Store) GetState(ctx context.Context, key string) (string, bool, error) {
+	var v string
+	err := s.db.QueryRowContext(ctx, `SELECT value FROM bot_state WHERE key=?`, key).Scan(&v)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return v, true, nil
+}
+
+func (s *Store) SetState(ctx context.Context, key string, value string) error {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO bot_state (key, value, updated_at)
+VALUES (?, ?, ?)
+ON CONFLICT(key) DO UPDATE SET value=excluded.value, updated_at=excluded.updated_at
+`, key, value, now)
+	return err
+}
@@ -0,0 +1,396 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Workspace roles. The owner and admins manage the shared server/key and invites; members only save.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+const workspaceInviteTTL = 48 * time.Hour
+
+var (
+	ErrInviteInvalid      = errors.New("invite code is unknown or expired")
+	ErrAlreadyInWorkspace = errors.New("user already belongs to a workspace")
+)
+
+// Workspace is a Karakeep server + API key shared by several Telegram users.
+// A user belongs to at most one workspace; while they do, its credentials replace their own.
+type Workspace struct {
+	ID   int64
+	Name string
+
+	ServerBaseURL string
+
+	APIKeyCiphertextB64 string
+	APIKeyNonceB64      string
+	APIKeyVersion       int
+	APIKeyBound         bool
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type WorkspaceMember struct {
+	WorkspaceID    int64
+	TelegramUserID int64
+	Role           string
+	JoinedAt       time.Time
+}
+
+// CanManage reports whether the member may change the workspace's credentials and invite people.
+func (m WorkspaceMember) CanManage() bool {
+	return m.Role == RoleOwner || m.Role == RoleAdmin
+}
+
+// Credentials are what a user's saves go to: the workspace's server and key, or one of the user's profiles.
+type Credentials struct {
+	ServerBaseURL string
+	APIKey        string
+
+	// Workspace is set when the credentials come from a workspace.
+	Workspace *Workspace
+	// Profile names the user's profile the credentials come from; empty for a workspace.
+	Profile string
+}
+
+func (c Credentials) Configured() bool {
+	return stringsTrim(c.ServerBaseURL) != "" && c.APIKey != ""
+}
+
+// ResolveCredentials returns the credentials u saves with. Empty fields mean "not configured".
+// A named profile (e.g. from an "@work" prefix) wins; otherwise the workspace, then the user's active profile.
+func (s *Store) ResolveCredentials(ctx context.Context, u User, profile string) (Credentials, error) {
+	if profile == "" {
+		ws, _, ok, err := s.GetUserWorkspace(ctx, u.TelegramUserID)
+		if err != nil {
+			return Credentials{}, err
+		}
+		if ok {
+			key, _, err := s.DecryptWorkspaceAPIKey(ctx, ws)
+			if err != nil {
+				return Credentials{}, err
+			}
+			return Credentials{ServerBaseURL: ws.ServerBaseURL, APIKey: key, Workspace: &ws}, nil
+		}
+	}
+	p, ok := u.Profile(profile)
+	if !ok {
+		return Credentials{}, ErrProfileNotFound
+	}
+	key, _, err := s.DecryptProfileAPIKey(ctx, p)
+	if err != nil {
+		return Credentials{}, err
+	}
+	return Credentials{ServerBaseURL: p.ServerBaseURL, APIKey: key, Profile: p.Name}, nil
+}
+
+// CreateWorkspace creates a workspace owned by ownerID with the given credentials (apiKey may be empty).
+func (s *Store) CreateWorkspace(ctx context.Context, ownerID int64, name string, serverBaseURL string, apiKey string) (Workspace, error) {
+	ws := Workspace{Name: stringsTrim(name), ServerBaseURL: serverBaseURL}
+	if ws.Name == "" {
+		return Workspace{}, errors.New("workspace name is empty")
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Workspace{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var n int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM workspace_members WHERE telegram_user_id=?`, ownerID).Scan(&n); err != nil {
+		return Workspace{}, err
+	}
+	if n > 0 {
+		return Workspace{}, ErrAlreadyInWorkspace
+	}
+
+	now := time.Now().UTC()
+	nowStr := now.Format(time.RFC3339Nano)
+	res, err := tx.ExecContext(ctx, `
+INSERT INTO workspaces (name, server_base_url, created_at, updated_at)
+VALUES (?, ?, ?, ?)
+`, ws.Name, ws.ServerBaseURL, nowStr, nowStr)
+	if err != nil {
+		return Workspace{}, err
+	}
+	if ws.ID, err = res.LastInsertId(); err != nil {
+		return Workspace{}, err
+	}
+	// The key is bound to the workspace id, which only exists after the insert.
+	if apiKey != "" {
+		ws.APIKeyCiphertextB64, ws.APIKeyNonceB64, ws.APIKeyVersion, err = s.encryptAPIKey(apiKey, workspaceKeyOwner(ws.ID))
+		if err != nil {
+			return Workspace{}, err
+		}
+		ws.APIKeyBound = true
+		if _, err := tx.ExecContext(ctx, `
+UPDATE workspaces SET api_key_ciphertext_b64=?, api_key_nonce_b64=?, api_key_version=?, api_key_bound=1 WHERE id=?
+`, ws.APIKeyCiphertextB64, ws.APIKeyNonceB64, ws.APIKeyVersion, ws.ID); err != nil {
+			return Workspace{}, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO workspace_members (telegram_user_id, workspace_id, role, joined_at) VALUES (?, ?, ?, ?)
+`, ownerID, ws.ID, RoleOwner, nowStr); err != nil {
+		return Workspace{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Workspace{}, err
+	}
+	ws.CreatedAt, ws.UpdatedAt = now, now
+	return ws, nil
+}
+
+// GetUserWorkspace returns the workspace telegramUserID belongs to and their membership. ok is false if none.
+func (s *Store) GetUserWorkspace(ctx context.Context, telegramUserID int64) (Workspace, WorkspaceMember, bool, error) {
+	var ws Workspace
+	m := WorkspaceMember{TelegramUserID: telegramUserID}
+	var createdAt, updatedAt, joinedAt string
+	err := s.db.QueryRowContext(ctx, `
+SELECT w.id, w.name, w.server_base_url, w.api_key_ciphertext_b64, w.api_key_nonce_b64, w.api_key_version, w.api_key_bound, w.created_at, w.updated_at, m.role, m.joined_at
+FROM workspace_members m JOIN workspaces w ON w.id = m.workspace_id
+WHERE m.telegram_user_id=?
+`, telegramUserID).Scan(&ws.ID, &ws.Name, &ws.ServerBaseURL, &ws.APIKeyCiphertextB64, &ws.APIKeyNonceB64, &ws.APIKeyVersion, &ws.APIKeyBound, &createdAt, &updatedAt, &m.Role, &joinedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Workspace{}, WorkspaceMember{}, false, nil
+		}
+		return Workspace{}, WorkspaceMember{}, false, err
+	}
+	ws.CreatedAt, _ = time.Parse(time.RFC3339Nano, createdAt)
+	ws.UpdatedAt, _ = time.Parse(time.RFC3339Nano, updatedAt)
+	m.WorkspaceID = ws.ID
+	m.JoinedAt, _ = time.Parse(time.RFC3339Nano, joinedAt)
+	return ws, m, true, nil
+}
+
+// WorkspaceMembers lists members in join order.
+func (s *Store) WorkspaceMembers(ctx context.Context, workspaceID int64) ([]WorkspaceMember, error) {
+	rows, err := s.db.QueryContext(ctx, `
+SELECT telegram_user_id, role, joined_at FROM workspace_members WHERE workspace_id=? ORDER BY joined_at
+`, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []WorkspaceMember
+	for rows.Next() {
+		m := WorkspaceMember{WorkspaceID: workspaceID}
+		var joinedAt string
+		if err := rows.Scan(&m.TelegramUserID, &m.Role, &joinedAt); err != nil {
+			return nil, err
+		}
+		m.JoinedAt, _ = time.Parse(time.RFC3339Nano, joinedAt)
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) SetWorkspaceServerBaseURL(ctx context.Context, workspaceID int64, serverBaseURL string) error {
+	if stringsTrim(serverBaseURL) == "" {
+		return errors.New("server base url is empty")
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	_, err := s.db.ExecContext(ctx, `
+UPDATE workspaces SET server_base_url=?, updated_at=? WHERE id=?
+`, serverBaseURL, now, workspaceID)
+	return err
+}
+
+// SetWorkspaceAPIKey replaces the shared key; every member saves with the new key right away.
+func (s *Store) SetWorkspaceAPIKey(ctx context.Context, workspaceID int64, apiKey string) error {
+	if stringsTrim(apiKey) == "" {
+		return errors.New("api key is empty")
+	}
+	ctB64, nonceB64, version, err := s.encryptAPIKey(apiKey, workspaceKeyOwner(workspaceID))
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	_, err = s.db.ExecContext(ctx, `
+UPDATE workspaces SET api_key_ciphertext_b64=?, api_key_nonce_b64=?, api_key_version=?, api_key_bound=1, updated_at=? WHERE id=?
+`, ctB64, nonceB64, version, now, workspaceID)
+	return err
+}
+
+func (s *Store) DecryptWorkspaceAPIKey(ctx context.Context, ws Workspace) (string, bool, error) {
+	return s.decryptAPIKey(ctx, workspaceKeyOwner(ws.ID), ws.APIKeyCiphertextB64, ws.APIKeyNonceB64, ws.APIKeyVersion, ws.APIKeyBound)
+}
+
+func workspaceKeyOwner(workspaceID int64) apiKeyOwner {
+	return apiKeyOwner{"workspaces", "id=?", []any{workspaceID}, fmt.Sprintf("workspace:%d", workspaceID)}
+}
+
+// CreateWorkspaceInvite returns a single-use code that adds its redeemer to the workspace with role.
+func (s *Store) CreateWorkspaceInvite(ctx context.Context, workspaceID int64, createdBy int64, role string) (string, time.Time, error) {
+	if role != RoleAdmin && role != RoleMember {
+		return "", time.Time{}, fmt.Errorf("invalid invite role %q", role)
+	}
+	code, err := newInviteCode()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now().UTC()
+	expiresAt := now.Add(workspaceInviteTTL)
+	_, err = s.db.ExecContext(ctx, `
+INSERT INTO workspace_invites (code, workspace_id, role, created_by, expires_at, created_at)
+VALUES (?, ?, ?, ?, ?, ?)
+`, code, workspaceID, role, createdBy, expiresAt.Format(time.RFC3339Nano), now.Format(time.RFC3339Nano))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	// Best-effort cleanup of expired codes.
+	_, _ = s.db.ExecContext(ctx, `DELETE FROM workspace_invites WHERE expires_at < ?`, now.Format(time.RFC3339Nano))
+	return code, expiresAt, nil
+}
+
+// newInviteCode returns a random 16-character base32 code, easy to type and to put in a t.me deep link.
+func newInviteCode() (string, error) {
+	raw := make([]byte, 10)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw), nil
+}
+
+// JoinWorkspace redeems an invite code for telegramUserID. The code is consumed even if it has expired.
+func (s *Store) JoinWorkspace(ctx context.Context, code string, telegramUserID int64) (Workspace, error) {
+	code = strings.ToUpper(stringsTrim(code))
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Workspace{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var workspaceID int64
+	var role, expiresAt string
+	err = tx.QueryRowContext(ctx, `
+SELECT workspace_id, role, expires_at FROM workspace_invites WHERE code=?
+`, code).Scan(&workspaceID, &role, &expiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Workspace{}, ErrInviteInvalid
+		}
+		return Workspace{}, err
+	}
+	exp, _ := time.Parse(time.RFC3339Nano, expiresAt)
+	if time.Now().After(exp) {
+		_, _ = tx.ExecContext(ctx, `DELETE FROM workspace_invites WHERE code=?`, code)
+		_ = tx.Commit()
+		return Workspace{}, ErrInviteInvalid
+	}
+
+	var n int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM workspace_members WHERE telegram_user_id=?`, telegramUserID).Scan(&n); err != nil {
+		return Workspace{}, err
+	}
+	if n > 0 {
+		return Workspace{}, ErrAlreadyInWorkspace
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM workspace_invites WHERE code=?`, code); err != nil {
+		return Workspace{}, err
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO workspace_members (telegram_user_id, workspace_id, role, joined_at) VALUES (?, ?, ?, ?)
+`, telegramUserID, workspaceID, role, now); err != nil {
+		return Workspace{}, err
+	}
+	var ws Workspace
+	if err := tx.QueryRowContext(ctx, `SELECT id, name FROM workspaces WHERE id=?`, workspaceID).Scan(&ws.ID, &ws.Name); err != nil {
+		return Workspace{}, err
+	}
+	return ws, tx.Commit()
+}
+
+// SetWorkspaceMemberRole changes the role of a member; ok is false if telegramUserID is not in the workspace.
+func (s *Store) SetWorkspaceMemberRole(ctx context.Context, workspaceID int64, telegramUserID int64, role string) (bool, error) {
+	if role != RoleOwner && role != RoleAdmin && role != RoleMember {
+		return false, fmt.Errorf("invalid role %q", role)
+	}
+	res, err := s.db.ExecContext(ctx, `
+UPDATE workspace_members SET role=? WHERE workspace_id=? AND telegram_user_id=?
+`, role, workspaceID, telegramUserID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// TransferWorkspaceOwnership makes member `to` the owner and the current owner `from` an admin,
+// both or neither: a workspace has exactly one owner.
+func (s *Store) TransferWorkspaceOwnership(ctx context.Context, workspaceID int64, from int64, to int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	for _, u := range []struct {
+		telegramUserID int64
+		role           string
+		cond           string
+	}{
+		{from, RoleAdmin, ` AND role='` + RoleOwner + `'`},
+		{to, RoleOwner, ""},
+	} {
+		res, err := tx.ExecContext(ctx, `
+UPDATE workspace_members SET role=? WHERE workspace_id=? AND telegram_user_id=?`+u.cond,
+			u.role, workspaceID, u.telegramUserID)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return fmt.Errorf("user %d is not the expected member of workspace %d", u.telegramUserID, workspaceID)
+		}
+	}
+	return tx.Commit()
+}
+
+// RemoveWorkspaceMember removes telegramUserID from the workspace; they fall back to their own credentials.
+func (s *Store) RemoveWorkspaceMember(ctx context.Context, workspaceID int64, telegramUserID int64) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `
+DELETE FROM workspace_members WHERE workspace_id=? AND telegram_user_id=?
+`, workspaceID, telegramUserID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// DeleteWorkspace removes the workspace with its members and pending invites.
+func (s *Store) DeleteWorkspace(ctx context.Context, workspaceID int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	for _, q := range []string{
+		`DELETE FROM workspace_invites WHERE workspace_id=?`,
+		`DELETE FROM workspace_members WHERE workspace_id=?`,
+		`DELETE FROM workspaces WHERE id=?`,
+	} {
+		if _, err := tx.ExecContext(ctx, q, workspaceID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTransferWorkspaceOwnership(t *testing.T) {
+	ctx := context.Background()
+	s, _ := openTestStore(t, MasterKeys{Current: testMasterKey, CurrentVersion: 1})
+
+	ws, err := s.CreateWorkspace(ctx, 1, "team", "https://karakeep.example", "key")
+	if err != nil {
+		t.Fatalf("CreateWorkspace: %v", err)
+	}
+	code, _, err := s.CreateWorkspaceInvite(ctx, ws.ID, 1, RoleMember)
+	if err != nil {
+		t.Fatalf("CreateWorkspaceInvite: %v", err)
+	}
+	if _, err := s.JoinWorkspace(ctx, code, 2); err != nil {
+		t.Fatalf("JoinWorkspace: %v", err)
+	}
+	roles := func() map[int64]string {
+		t.Helper()
+		members, err := s.WorkspaceMembers(ctx, ws.ID)
+		if err != nil {
+			t.Fatalf("WorkspaceMembers: %v", err)
+		}
+		out := make(map[int64]string)
+		for _, m := range members {
+			out[m.TelegramUserID] = m.Role
+		}
+		return out
+	}
+
+	if err := s.TransferWorkspaceOwnership(ctx, ws.ID, 1, 2); err != nil {
+		t.Fatalf("TransferWorkspaceOwnership: %v", err)
+	}
+	if r := roles(); r[1] != RoleAdmin || r[2] != RoleOwner {
+		t.Fatalf("roles after transfer = %v, want 1 admin, 2 owner", r)
+	}
+
+	// Neither half applies when the other fails: no second owner, no ownerless workspace.
+	if err := s.TransferWorkspaceOwnership(ctx, ws.ID, 1, 2); err == nil {
+		t.Error("transfer by a non-owner succeeded")
+	}
+	if err := s.TransferWorkspaceOwnership(ctx, ws.ID, 2, 99); err == nil {
+		t.Error("transfer to a non-member succeeded")
+	}
+	if r := roles(); r[1] != RoleAdmin || r[2] != RoleOwner {
+		t.Errorf("roles after failed transfers = %v, want 1 admin, 2 owner", r)
+	}
+}
@@ -0,0 +1,157 @@
+package telegram
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type DispatcherOpts struct {
+	// Workers is the number of goroutines handling updates. Defaults to 8.
+	Workers int
+	// QueueSize is how many updates may wait for a worker in total. Defaults to 1000.
+	// Each worker gets QueueSize/Workers of it, shared by all chats mapped to that worker:
+	// one busy chat can fill it and get the other chats of its worker refused.
+	QueueSize int
+
+	Logger *slog.Logger
+
+	OnUpdate func(context.Context, tgbotapi.Update)
+}
+
+// Dispatcher hands updates to a fixed set of workers through a bounded queue. Updates of one chat
+// always go to the same worker, so they are handled one at a time and in the order received.
+type Dispatcher struct {
+	opts   DispatcherOpts
+	log    *slog.Logger
+	queues []chan tgbotapi.Update
+	wg     sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+func NewDispatcher(opts DispatcherOpts) *Dispatcher {
+	log := opts.Logger
+	if log == nil {
+		log = slog.Default()
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 8
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 1000
+	}
+	perWorker := opts.QueueSize / opts.Workers
+	if perWorker < 1 {
+		perWorker = 1
+	}
+	d := &Dispatcher{opts: opts, log: log}
+	for i := 0; i < opts.Workers; i++ {
+		q := make(chan tgbotapi.Update, perWorker)
+		d.queues = append(d.queues, q)
+		d.wg.Add(1)
+		go d.work(q)
+	}
+	return d
+}
+
+// Dispatch queues u without blocking. It returns false if the update's worker queue is full
+// or the dispatcher is shutting down; the caller should make Telegram redeliver it later.
+func (d *Dispatcher) Dispatch(u tgbotapi.Update) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return false
+	}
+	select {
+	case d.queues[d.shard(u)] <- u:
+		return true
+	default:
+		return false
+	}
+}
+
+// QueueDepth returns the number of updates waiting for a worker.
+func (d *Dispatcher) QueueDepth() int {
+	n := 0
+	for _, q := range d.queues {
+		n += len(q)
+	}
+	return n
+}
+
+// QueueCapacity returns how many updates the queue holds at most.
+func (d *Dispatcher) QueueCapacity() int {
+	n := 0
+	for _, q := range d.queues {
+		n += cap(q)
+	}
+	return n
+}
+
+// Shutdown stops accepting updates and waits until the queued ones are handled or ctx is done.
+// Updates still queued at the deadline are dropped by the process exit.
+func (d *Dispatcher) Shutdown(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		for _, q := range d.queues {
+			close(q)
+		}
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Dispatcher) work(q chan tgbotapi.Update) {
+	defer d.wg.Done()
+	for u := range q {
+		d.handle(u)
+	}
+}
+
+func (d *Dispatcher) handle(u tgbotapi.Update) {
+	if d.opts.OnUpdate == nil {
+		return
+	}
+	defer func() {
+		// prevent panics from crashing the worker
+		if r := recover(); r != nil {
+			d.log.Error("panic in update handler", "recover", r)
+		}
+	}()
+	d.opts.OnUpdate(context.Background(), u)
+}
+
+// shard picks the worker for u by chat, falling back to the sender for chat-less updates
+// (inline queries, buttons under inline messages).
+func (d *Dispatcher) shard(u tgbotapi.Update) int {
+	var key int64
+	switch {
+	case u.CallbackQuery != nil && u.CallbackQuery.Message != nil && u.CallbackQuery.Message.Chat != nil:
+		key = u.CallbackQuery.Message.Chat.ID
+	case u.CallbackQuery != nil:
+		if u.CallbackQuery.From != nil {
+			key = u.CallbackQuery.From.ID
+		}
+	case u.FromChat() != nil:
+		key = u.FromChat().ID
+	case u.SentFrom() != nil:
+		key = u.SentFrom().ID
+	}
+	return int(uint64(key) % uint64(len(d.queues)))
+}
@@ -0,0 +1,137 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func chatUpdate(id int, chatID int64) tgbotapi.Update {
+	return tgbotapi.Update{UpdateID: id, Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}}}
+}
+
+// blockingDispatcher returns a one-worker dispatcher whose handler waits for release;
+// started receives each update as the worker picks it up.
+func blockingDispatcher(queueSize int) (d *Dispatcher, started chan int, release chan struct{}) {
+	started = make(chan int, 16)
+	release = make(chan struct{})
+	d = NewDispatcher(DispatcherOpts{
+		Workers:   1,
+		QueueSize: queueSize,
+		OnUpdate: func(_ context.Context, u tgbotapi.Update) {
+			started <- u.UpdateID
+			<-release
+		},
+	})
+	return d, started, release
+}
+
+func TestDispatcherKeepsChatOrder(t *testing.T) {
+	var mu sync.Mutex
+	got := make(map[int64][]int)
+	d := NewDispatcher(DispatcherOpts{
+		Workers:   4,
+		QueueSize: 400,
+		OnUpdate: func(_ context.Context, u tgbotapi.Update) {
+			// Uneven handling times would reorder a chat's updates if they ran in parallel.
+			time.Sleep(time.Duration(u.UpdateID%3) * time.Millisecond)
+			mu.Lock()
+			got[u.Message.Chat.ID] = append(got[u.Message.Chat.ID], u.UpdateID)
+			mu.Unlock()
+		},
+	})
+	chats := []int64{1, 2, 3, -1001234567890}
+	for i := 0; i < 100; i++ {
+		if !d.Dispatch(chatUpdate(i, chats[i%len(chats)])) {
+			t.Fatalf("Dispatch(%d) = false", i)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := d.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	for _, chat := range chats {
+		ids := got[chat]
+		if len(ids) != 100/len(chats) {
+			t.Errorf("chat %d: handled %d updates, want %d", chat, len(ids), 100/len(chats))
+		}
+		for i := 1; i < len(ids); i++ {
+			if ids[i] < ids[i-1] {
+				t.Errorf("chat %d: updates handled out of order: %v", chat, ids)
+				break
+			}
+		}
+	}
+}
+
+func TestDispatcherFullQueue(t *testing.T) {
+	d, started, release := blockingDispatcher(1)
+	defer func() {
+		close(release)
+		_ = d.Shutdown(context.Background())
+	}()
+
+	if !d.Dispatch(chatUpdate(1, 1)) {
+		t.Fatal("Dispatch(1) = false")
+	}
+	<-started
+	if !d.Dispatch(chatUpdate(2, 1)) {
+		t.Fatal("Dispatch(2) = false, want it queued")
+	}
+	if d.Dispatch(chatUpdate(3, 1)) {
+		t.Fatal("Dispatch(3) = true with a full queue")
+	}
+	if depth := d.QueueDepth(); depth != 1 {
+		t.Errorf("QueueDepth = %d, want 1", depth)
+	}
+
+	h := NewWebhookHandler(WebhookHandlerOpts{Dispatcher: d})
+	body := `{"update_id":4,"message":{"message_id":1,"date":0,"chat":{"id":1,"type":"private"}}}`
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/telegram/webhook", strings.NewReader(body)))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("webhook status = %d, want 503", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("webhook 503 without Retry-After")
+	}
+}
+
+func TestDispatcherShutdownDrains(t *testing.T) {
+	d, started, release := blockingDispatcher(10)
+	d.Dispatch(chatUpdate(1, 1))
+	<-started
+	d.Dispatch(chatUpdate(2, 1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := d.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown with a busy worker = %v, want deadline exceeded", err)
+	}
+	if d.Dispatch(chatUpdate(3, 1)) {
+		t.Error("Dispatch after Shutdown = true")
+	}
+
+	close(release)
+	if err := d.Shutdown(context.Background()); err != nil {
+		t.Fatalf("second Shutdown: %v", err)
+	}
+	// The update queued before Shutdown is still handled.
+	select {
+	case id := <-started:
+		if id != 2 {
+			t.Errorf("handled update %d, want 2", id)
+		}
+	default:
+		t.Error("queued update was dropped by Shutdown")
+	}
+}
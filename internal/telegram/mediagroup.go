@@ -13,8 +13,9 @@ type MediaGroupCollector struct {
 	Delay time.Duration
 	OnFlush func(groupID string, msgs []*tgbotapi.Message)
 
-	mu     sync.Mutex
-	groups map[string]*mediaGroup
+	mu       sync.Mutex
+	groups   map[string]*mediaGroup
+	flushing sync.WaitGroup
 }
 
 type mediaGroup struct {
@@ -69,11 +70,35 @@ func (c *MediaGroupCollector) flush(groupID string) {
 			g.timer.Stop()
 		}
 		batch = g.msgs
+		c.flushing.Add(1)
 	}
 	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	defer c.flushing.Done()
 
-	if ok && c.OnFlush != nil && len(batch) > 0 {
+	if c.OnFlush != nil && len(batch) > 0 {
 		c.OnFlush(groupID, batch)
 	}
 }
 
+// Flush hands every collected album to OnFlush without waiting for Delay and returns once
+// all flushes, including ones already started by timers, are done. Used on shutdown.
+func (c *MediaGroupCollector) Flush() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	ids := make([]string, 0, len(c.groups))
+	for id := range c.groups {
+		ids = append(ids, id)
+	}
+	c.mu.Unlock()
+
+	for _, id := range ids {
+		c.flush(id)
+	}
+	c.flushing.Wait()
+}
+
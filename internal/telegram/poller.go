@@ -0,0 +1,143 @@
+package telegram
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type PollerOpts struct {
+	Bot *tgbotapi.BotAPI
+
+	Logger *slog.Logger
+
+	// Long-poll timeout passed to getUpdates (seconds). Defaults to 30.
+	TimeoutSeconds int
+
+	// LoadLastUpdateID returns the last update_id that was queued or handled (0 if none).
+	LoadLastUpdateID func(context.Context) (int, error)
+	// SaveLastUpdateID persists progress after each update, so a restart doesn't replay or skip updates.
+	SaveLastUpdateID func(context.Context, int) error
+
+	// Dispatcher handles the updates, as for the webhook. While its queue is full the poller
+	// waits and offers the update again, so nothing is dropped or reordered.
+	Dispatcher *Dispatcher
+	// OnUpdate is called inline, one update at a time, when there is no Dispatcher.
+	OnUpdate func(context.Context, tgbotapi.Update)
+}
+
+// Poller receives updates via getUpdates long polling; an alternative to the webhook handler
+// for setups without public HTTPS. Updates are handed on one by one, in order.
+type Poller struct {
+	opts PollerOpts
+	log  *slog.Logger
+}
+
+func NewPoller(opts PollerOpts) *Poller {
+	log := opts.Logger
+	if log == nil {
+		log = slog.Default()
+	}
+	if opts.TimeoutSeconds <= 0 {
+		opts.TimeoutSeconds = 30
+	}
+	return &Poller{opts: opts, log: log}
+}
+
+// Run polls until ctx is cancelled and returns right after: updates it didn't hand on by then
+// are not confirmed to Telegram and are delivered again after a restart.
+func (p *Poller) Run(ctx context.Context) {
+	lastID := 0
+	if p.opts.LoadLastUpdateID != nil {
+		id, err := p.opts.LoadLastUpdateID(ctx)
+		if err != nil {
+			p.log.Warn("load last update id failed", "err", err)
+		}
+		lastID = id
+	}
+	p.log.Info("telegram polling started", "last_update_id", lastID)
+
+	backoff := time.Second
+	for ctx.Err() == nil {
+		cfg := tgbotapi.NewUpdate(lastID + 1)
+		cfg.Timeout = p.opts.TimeoutSeconds
+
+		updates, err := p.getUpdates(ctx, cfg)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			p.log.Warn("telegram getUpdates failed", "err", err, "retry_in", backoff)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+			continue
+		}
+		backoff = time.Second
+
+		for _, u := range updates {
+			if u.UpdateID <= lastID {
+				continue
+			}
+			if !p.dispatch(ctx, u) {
+				return
+			}
+			lastID = u.UpdateID
+			if p.opts.SaveLastUpdateID != nil {
+				if err := p.opts.SaveLastUpdateID(context.WithoutCancel(ctx), lastID); err != nil {
+					p.log.Warn("save last update id failed", "update_id", lastID, "err", err)
+				}
+			}
+		}
+	}
+}
+
+// getUpdates is Bot.GetUpdates that gives up when ctx is cancelled; the long poll itself can't be
+// interrupted and finishes in the background.
+func (p *Poller) getUpdates(ctx context.Context, cfg tgbotapi.UpdateConfig) ([]tgbotapi.Update, error) {
+	type result struct {
+		updates []tgbotapi.Update
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		updates, err := p.opts.Bot.GetUpdates(cfg)
+		done <- result{updates, err}
+	}()
+	select {
+	case r := <-done:
+		return r.updates, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// dispatch hands u on; it returns false if ctx was cancelled while the dispatcher's queue was full.
+func (p *Poller) dispatch(ctx context.Context, u tgbotapi.Update) bool {
+	p.log.Info("telegram update received", "update_id", u.UpdateID)
+	d := p.opts.Dispatcher
+	if d == nil {
+		if p.opts.OnUpdate != nil {
+			handleInline(ctx, p.log, p.opts.OnUpdate, u)
+		}
+		return true
+	}
+	for waited := false; !d.Dispatch(u); waited = true {
+		if !waited {
+			p.log.Warn("telegram update queue full, waiting", "update_id", u.UpdateID, "queued", d.QueueDepth())
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(time.Second):
+		}
+	}
+	return true
+}
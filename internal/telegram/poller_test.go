@@ -0,0 +1,116 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// fakeUpdatesBot serves getUpdates with updates once; later calls hang like an idle long poll.
+func fakeUpdatesBot(t *testing.T, updates []tgbotapi.Update) *tgbotapi.BotAPI {
+	t.Helper()
+	idle := make(chan struct{})
+	var once sync.Once
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var result any = []tgbotapi.Update{}
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/getMe"):
+			result = map[string]any{"id": 42, "is_bot": true, "first_name": "bot", "username": "test_bot"}
+		case strings.HasSuffix(r.URL.Path, "/getUpdates"):
+			served := false
+			once.Do(func() { result, served = updates, true })
+			if !served {
+				select {
+				case <-idle:
+				case <-r.Context().Done():
+				}
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+	}))
+	t.Cleanup(func() {
+		close(idle)
+		srv.Close()
+	})
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint("token", srv.URL+"/bot%s/%s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bot
+}
+
+// With a full dispatcher queue the poller waits instead of dropping updates or moving past them.
+func TestPollerWaitsForDispatcher(t *testing.T) {
+	d, started, release := blockingDispatcher(1)
+	bot := fakeUpdatesBot(t, []tgbotapi.Update{chatUpdate(1, 1), chatUpdate(2, 1), chatUpdate(3, 1)})
+
+	var mu sync.Mutex
+	saved := 0
+	lastSaved := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return saved
+	}
+	p := NewPoller(PollerOpts{
+		Bot:        bot,
+		Dispatcher: d,
+		SaveLastUpdateID: func(_ context.Context, id int) error {
+			mu.Lock()
+			saved = id
+			mu.Unlock()
+			return nil
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.Run(ctx)
+	}()
+
+	// Update 1 is being handled, 2 fills the queue, 3 has to wait.
+	if id := <-started; id != 1 {
+		t.Fatalf("first handled update = %d, want 1", id)
+	}
+	waitSaved := func(id int) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for lastSaved() < id && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitSaved(2)
+	time.Sleep(50 * time.Millisecond)
+	if got := lastSaved(); got != 2 {
+		t.Fatalf("last saved update = %d with update 3 not queued, want 2", got)
+	}
+
+	close(release)
+	for _, want := range []int{2, 3} {
+		if id := <-started; id != want {
+			t.Fatalf("handled update %d, want %d", id, want)
+		}
+	}
+	if err := d.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	waitSaved(3)
+	if got := lastSaved(); got != 3 {
+		t.Errorf("last saved update = %d, want 3", got)
+	}
+
+	// Run doesn't wait for the long poll in flight.
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return after cancellation")
+	}
+}
@@ -3,6 +3,7 @@ package telegram
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
@@ -18,14 +19,23 @@ type WebhookHandlerOpts struct {
 
 	Logger *slog.Logger
 
+	// Dispatcher handles accepted updates. When its queue is full the handler answers 503,
+	// and Telegram delivers the update again later.
+	Dispatcher *Dispatcher
+	// OnUpdate is called inline, before answering Telegram, when there is no Dispatcher.
 	OnUpdate func(context.Context, tgbotapi.Update)
 }
 
+// NewWebhookHandler panics if opts has neither a Dispatcher nor OnUpdate: updates would be
+// acknowledged to Telegram and lost.
 func NewWebhookHandler(opts WebhookHandlerOpts) http.Handler {
 	log := opts.Logger
 	if log == nil {
 		log = slog.Default()
 	}
+	if opts.Dispatcher == nil && opts.OnUpdate == nil {
+		panic("telegram: webhook handler needs a Dispatcher or OnUpdate")
+	}
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
@@ -55,25 +65,63 @@ func NewWebhookHandler(opts WebhookHandlerOpts) http.Handler {
 			return
 		}
 
-		// Respond to Telegram quickly.
+		if opts.Dispatcher != nil && !opts.Dispatcher.Dispatch(upd) {
+			log.Warn("telegram update queue full, asking for redelivery", "update_id", upd.UpdateID, "queued", opts.Dispatcher.QueueDepth())
+			w.Header().Set("Retry-After", "5")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if upd.UpdateID != 0 {
+			log.Info("telegram update received", "update_id", upd.UpdateID)
+		} else {
+			log.Info("telegram update received")
+		}
+		if opts.Dispatcher == nil {
+			handleInline(r.Context(), log, opts.OnUpdate, upd)
+		}
+		// With a dispatcher Telegram gets its answer right away: the update is handled by the workers.
 		w.WriteHeader(http.StatusOK)
+	})
+}
 
-		if opts.OnUpdate != nil {
-			go func(u tgbotapi.Update) {
-				defer func() {
-					// prevent panics from crashing the server
-					if r := recover(); r != nil {
-						log.Error("panic in update handler", "recover", r)
-					}
-				}()
-				if u.UpdateID != 0 {
-					log.Info("telegram update received", "update_id", u.UpdateID)
-				} else {
-					log.Info("telegram update received")
-				}
-				opts.OnUpdate(context.Background(), u)
-			}(upd)
+// handleInline calls onUpdate for u on the caller's goroutine; a panic is logged rather than
+// taking the server down.
+func handleInline(ctx context.Context, log *slog.Logger, onUpdate func(context.Context, tgbotapi.Update), u tgbotapi.Update) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("panic in update handler", "recover", r)
 		}
-	})
+	}()
+	onUpdate(context.WithoutCancel(ctx), u)
+}
+
+// SetWebhook registers webhookURL with Telegram. Telegram then stops serving getUpdates for this bot.
+// tgbotapi v5.5.1 WebhookConfig has no secret_token field, so we build the request params ourselves.
+func SetWebhook(bot *tgbotapi.BotAPI, webhookURL string, secretToken string, dropPending bool) error {
+	params := make(tgbotapi.Params)
+	params["url"] = webhookURL
+	params.AddNonEmpty("secret_token", secretToken)
+	params.AddBool("drop_pending_updates", dropPending)
+
+	if _, err := bot.MakeRequest("setWebhook", params); err != nil {
+		return fmt.Errorf("setWebhook: %w", err)
+	}
+	return nil
+}
+
+// DeleteWebhook removes the webhook if one is registered, so getUpdates long polling can work.
+// Pending updates are kept: the poller picks them up. Returns the URL that was removed, if any.
+func DeleteWebhook(bot *tgbotapi.BotAPI) (string, error) {
+	info, err := bot.GetWebhookInfo()
+	if err != nil {
+		return "", fmt.Errorf("getWebhookInfo: %w", err)
+	}
+	if info.URL == "" {
+		return "", nil
+	}
+	if _, err := bot.Request(tgbotapi.DeleteWebhookConfig{DropPendingUpdates: false}); err != nil {
+		return "", fmt.Errorf("deleteWebhook: %w", err)
+	}
+	return info.URL, nil
 }
 
@@ -0,0 +1,43 @@
+package telegram
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestWebhookWithoutDispatcherHandlesInline(t *testing.T) {
+	var got []int
+	h := NewWebhookHandler(WebhookHandlerOpts{
+		OnUpdate: func(_ context.Context, u tgbotapi.Update) {
+			got = append(got, u.UpdateID)
+			if u.UpdateID == 2 {
+				panic("boom")
+			}
+		},
+	})
+	for _, id := range []string{"1", "2"} {
+		rec := httptest.NewRecorder()
+		body := `{"update_id":` + id + `,"message":{"message_id":1,"date":0,"chat":{"id":1,"type":"private"}}}`
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/telegram/webhook", strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Errorf("update %s: webhook status = %d, want 200", id, rec.Code)
+		}
+	}
+	if len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Errorf("handled updates %v, want [1 2] before answering", got)
+	}
+}
+
+func TestWebhookNeedsAHandler(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("NewWebhookHandler without Dispatcher and OnUpdate didn't panic")
+		}
+	}()
+	NewWebhookHandler(WebhookHandlerOpts{})
+}